@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mitro42/coback/hashcache"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// openHashCache opens the hash cache in the user's cache directory
+func openHashCache() (*hashcache.Cache, error) {
+	path, err := hashcache.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return hashcache.Open(afero.NewOsFs(), path)
+}
+
+// saveHashCache saves the hash cache, a failure is only reported because the cache can be rebuilt any time
+func saveHashCache(cache *hashcache.Cache) {
+	if err := cache.Save(); err != nil {
+		fmt.Printf("Failed to save the hash cache: %v\n", err)
+	}
+}
+
+// maintainCache prunes and verifies the hash cache as requested, prints the results and saves the cache.
+// maxAge is passed to Prune.
+func maintainCache(ctx context.Context, cache *hashcache.Cache, prune bool, maxAge time.Duration, verify bool) error {
+	fmt.Printf("Hash cache %v contains %v checksum(s)\n", cache.Path(), cache.Count())
+	if prune {
+		removed := cache.Prune(maxAge)
+		fmt.Printf("%v checksum(s) pruned\n", removed)
+	}
+	if verify {
+		result, err := cache.Verify(ctx)
+		for _, path := range result.Mismatched {
+			fmt.Printf("Wrong checksum removed: %v\n", path)
+		}
+		fmt.Printf("%v checksum(s) verified, %v removed, %v skipped\n", result.Checked, len(result.Mismatched), result.Skipped)
+		if err != nil {
+			saveHashCache(cache)
+			return err
+		}
+	}
+	return cache.Save()
+}
+
+// cacheCommand runs the cache subcommand with the given command line arguments (without the subcommand itself)
+func cacheCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("cache", flag.ContinueOnError)
+	prune := flags.Bool("prune", false, "remove the checksums of the files that were deleted or modified since they were hashed")
+	unusedDays := flags.Int("unused-days", 0, "with -prune, also remove the checksums that were not used for this many days")
+	verify := flags.Bool("verify", false, "read the cached files again and remove the checksums that don't match their content")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v cache [options]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("The cache command has no arguments")
+	}
+	if *unusedDays < 0 {
+		return errors.Errorf("Invalid number of days: %v", *unusedDays)
+	}
+
+	cache, err := openHashCache()
+	if err != nil {
+		return err
+	}
+	return maintainCache(ctx, cache, *prune, time.Duration(*unusedDays)*24*time.Hour, *verify)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/hashcache"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestMaintainCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coback")
+	th.Ok(t, err)
+	defer os.RemoveAll(dir)
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	cachePath := filepath.Join(dir, "cache", hashcache.FileName)
+	cache, err := hashcache.Open(afero.NewOsFs(), cachePath)
+	th.Ok(t, err)
+	for _, path := range []string{"a.txt", "b.txt"} {
+		_, err = catalog.NewItemWithCache(fs, path, catalog.MD5, cache)
+		th.Ok(t, err)
+	}
+	th.Ok(t, fs.Remove("b.txt"))
+
+	th.Ok(t, maintainCache(context.Background(), cache, true, 0, true))
+	cache, err = hashcache.Open(afero.NewOsFs(), cachePath)
+	th.Ok(t, err)
+	th.Equals(t, 1, cache.Count())
+}
+
+func TestCacheCommandArguments(t *testing.T) {
+	th.NokPrefix(t, cacheCommand(context.Background(), []string{"folder"}), "The cache command has no arguments")
+	th.NokPrefix(t, cacheCommand(context.Background(), []string{"-prune", "-unused-days", "-1"}), "Invalid number of days: -1")
+}
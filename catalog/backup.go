@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// catalogBackupCount is the number of previous generations of a catalog file kept next to it
+const catalogBackupCount = 3
+
+// tempFileName returns the name of the temporary file a catalog is written to before it is renamed to its final name
+func tempFileName(path string) string {
+	return path + ".tmp"
+}
+
+// generationFileName returns the name of a previous generation of a catalog file. Generation 1 is the newest.
+func generationFileName(path string, generation int) string {
+	return fmt.Sprintf("%v.%v", path, generation)
+}
+
+// writeFileAtomic writes the data to a temporary file, flushes it to the disk and renames it to the final path.
+// Before the file is replaced the previous generations are rotated, the current file becomes generation 1.
+// If the process is interrupted at any point, either the old or the new content is found at the path.
+func writeFileAtomic(fs afero.Fs, path string, data []byte) error {
+	tempPath := tempFileName(path)
+	f, err := fs.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = rotateGenerations(fs, path); err != nil {
+		return err
+	}
+	if err = fs.Rename(tempPath, path); err != nil {
+		return err
+	}
+	syncDir(fs, filepath.Dir(path))
+	return nil
+}
+
+// rotateGenerations shifts the previous generations of a file by one, dropping the oldest,
+// and copies the current file to generation 1. The current file itself is left in place.
+func rotateGenerations(fs afero.Fs, path string) error {
+	if exists, _ := afero.Exists(fs, path); !exists {
+		return nil
+	}
+	for i := catalogBackupCount - 1; i >= 1; i-- {
+		older := generationFileName(path, i)
+		if exists, _ := afero.Exists(fs, older); !exists {
+			continue
+		}
+		if err := fs.Rename(older, generationFileName(path, i+1)); err != nil {
+			return errors.Wrapf(err, "Cannot rotate catalog backup '%v'", older)
+		}
+	}
+
+	current, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, generationFileName(path, 1), current, 0644)
+}
+
+// syncDir flushes the directory entry changes (e.g. renames) to the disk. It's a best effort operation,
+// not all file systems support syncing a directory.
+func syncDir(fs afero.Fs, dir string) {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestWriteKeepsPreviousGenerations(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewCatalog()
+	for i := 0; i < 5; i++ {
+		c.DeleteChecksum(Checksum(fmt.Sprintf("%v", i)))
+		th.Ok(t, c.Write(fs))
+	}
+
+	exists, _ := afero.Exists(fs, tempFileName(CatalogFileName))
+	th.Equals(t, false, exists)
+	for i := 1; i <= catalogBackupCount; i++ {
+		backup, err := Read(fs, generationFileName(CatalogFileName, i))
+		th.Ok(t, err)
+		th.Equals(t, 5-i, backup.DeletedCount())
+	}
+	exists, _ = afero.Exists(fs, generationFileName(CatalogFileName, catalogBackupCount+1))
+	th.Equals(t, false, exists)
+}
+
+func TestReadFallsBackToNewestValidGeneration(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewCatalog()
+	c.DeleteChecksum("1")
+	th.Ok(t, c.Write(fs))
+	c.DeleteChecksum("2")
+	th.Ok(t, c.Write(fs))
+	c.DeleteChecksum("3")
+	th.Ok(t, c.Write(fs))
+
+	afero.WriteFile(fs, CatalogFileName, []byte(`{"version":1,"content":{"a":`), 0644)
+	afero.WriteFile(fs, generationFileName(CatalogFileName, 1), []byte{}, 0644)
+	c2, err := Read(fs, CatalogFileName)
+	th.NokPrefix(t, err, "The catalog is damaged (Cannot parse catalog json: 'coback.catalog'")
+	backupErr, ok := err.(*BackupUsedError)
+	th.Assert(t, ok, "unexpected error type: %T", err)
+	th.Equals(t, 2, backupErr.Generation)
+	th.Equals(t, CatalogFileName, backupErr.Path)
+	th.Equals(t, 1, c2.DeletedCount())
+	th.Equals(t, true, c2.IsDeletedChecksum("1"))
+}
+
+func TestReadDoesNotFallBackIfMissing(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewCatalog()
+	th.Ok(t, c.Write(fs))
+	th.Ok(t, c.Write(fs))
+	th.Ok(t, fs.Remove(CatalogFileName))
+
+	c2, err := Read(fs, CatalogFileName)
+	th.NokPrefix(t, err, "Cannot read catalog: 'coback.catalog'")
+	th.Equals(t, nil, c2)
+}
+
+func TestReadAllGenerationsInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte("Not a valid json"), 0644)
+	afero.WriteFile(fs, generationFileName(CatalogFileName, 1), []byte("Not a valid json either"), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.NokPrefix(t, err, "Cannot parse catalog json: 'coback.catalog'")
+	th.Equals(t, nil, c)
+}
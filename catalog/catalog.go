@@ -3,7 +3,9 @@ package catalog
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
@@ -12,7 +14,11 @@ import (
 // CatalogFileName is the file where coback stores the catalog in json format
 const CatalogFileName = "coback.catalog"
 
-type catalogState int
+// IsCatalogFile returns true if the file name belongs to a catalog or one of its backups.
+// These files are never treated as the content of a folder.
+func IsCatalogFile(name string) bool {
+	return name == CatalogFileName || strings.HasPrefix(name, CatalogFileName+".")
+}
 
 // Checksum is a string type that helps avoiding confusion between files identified by path and by checksum
 type Checksum string
@@ -28,7 +34,12 @@ type Catalog interface {
 	// ForgetPath completely removes and item with the given path from the catalog. It's hash will not be stored as deleted,
 	// and other items will not be removed even if they have the same hash.
 	ForgetPath(path string)
+	// Move changes the path of an item without changing any other data of it. Used when a file was renamed or moved
+	// in the folder, so its content doesn't have to be read again. Returns error if the item doesn't exist,
+	// or there is already an item with the new path.
+	Move(oldPath string, newPath string) error
 	// DeletePath removes the path from the Catalog. If there are no other items stored with the same hash, the hash is stored as deleted
+	// with a tombstone describing the removed item.
 	DeletePath(path string)
 	// Item returns the Item with the given path. Returns error if the path doesn't exist.
 	Item(path string) (Item, error)
@@ -44,48 +55,97 @@ type Catalog interface {
 	Count() int
 	// DeletedCount returns the number of items stored in the Catalog which are marked as deleted
 	DeletedCount() int
-	// DeleteChecksum stores the checksum as deleted and removes all items from the Catalog that have the given checksum (if any)
+	// DeleteChecksum stores the checksum as deleted and removes all items from the Catalog that have the given checksum (if any).
+	// The tombstone of the checksum records the paths and size of the removed items and the time of the deletion.
+	// If the checksum is already deleted, the paths are added to its existing tombstone.
 	DeleteChecksum(sum Checksum)
+	// AddTombstone stores the checksum as deleted with the given tombstone, replacing the existing one if any,
+	// and removes all items from the Catalog that have the given checksum. Used to transfer deletions between catalogs.
+	AddTombstone(sum Checksum, tombstone Tombstone)
+	// Tombstone returns the tombstone of a deleted checksum. Returns error if the checksum is not deleted.
+	Tombstone(sum Checksum) (Tombstone, error)
 	// UnDeleteChecksum removes the checksum from the deleted checksums. It does not affect the stored items.
 	UnDeleteChecksum(sum Checksum)
 	// IsDeletedChecksum returns true all the items with the given checksum are marked as deleted.
 	// Returns error if the path doesn't exist or some items are marked as deleted, but not all of them.
 	IsDeletedChecksum(sum Checksum) bool
-	// IsKnownChecksum returns true is the checksum is in the Catalog, either as an actual item or as a checksum marked as deleted
+	// IsKnownChecksum returns true is the checksum is in the Catalog, either as an actual item or as a checksum marked as deleted.
+	// Checksums calculated with a different algorithm than the one used by the catalog are never known.
 	IsKnownChecksum(sum Checksum) bool
-	// WriteAs writes the Catalog as a file at the given path and file system
+	// HashAlgorithm returns the algorithm used to calculate the checksums of the items in the Catalog
+	HashAlgorithm() HashAlgorithm
+	// State returns the state of the folder the Catalog belongs to
+	State() State
+	// SetState changes the state of the folder the Catalog belongs to. The new state is persisted by the next write.
+	SetState(state State)
+	// WriteAs writes the Catalog as a file at the given path and file system.
+	// The file is replaced atomically, and a few previous versions of it are kept as backups.
 	WriteAs(fs afero.Fs, path string) error
 	// Write writes the Catalog as 'coback.catalog' in the root of the file system
 	Write(fs afero.Fs) error
 	// Clone creates a deep copy of the Catalog
 	Clone() Catalog
 	// FilterNew returns a catalog that contains all items that are present in this Catalog, but not in the other
-	// (either as regular items or deleted hashes)
+	// (either as regular items or deleted hashes).
+	// Checksums calculated with different algorithms never match, so if the two catalogs use different
+	// hash algorithms all items are returned. The returned catalog uses the algorithm of this Catalog.
 	FilterNew(other Catalog) Catalog
 }
 
 type catalog struct {
-	State           catalogState      `json:"state"`
-	Items           map[string]Item   `json:"content"`
-	Deleted         map[Checksum]bool `json:"deleted_checksums"`
+	Version         int                    `json:"version"`
+	Status          State                  `json:"state"`
+	Algorithm       HashAlgorithm          `json:"hash_algorithm"`
+	Items           map[string]Item        `json:"content"`
+	Deleted         map[Checksum]Tombstone `json:"deleted_checksums"`
 	checksumToPaths map[Checksum][]string
+	// readVersion is the format version of the file the catalog was read from.
+	// If it is older than CatalogVersion, a backup of the old file is made before it's overwritten.
+	readVersion int
 }
 
 func newcatalog() *catalog {
+	return newcatalogWithAlgorithm(DefaultHashAlgorithm)
+}
+
+func newcatalogWithAlgorithm(alg HashAlgorithm) *catalog {
 	return &catalog{
+		Version:         CatalogVersion,
+		Status:          Initializing,
+		readVersion:     CatalogVersion,
+		Algorithm:       alg,
 		Items:           make(map[string]Item),
 		checksumToPaths: make(map[Checksum][]string),
-		Deleted:         make(map[Checksum]bool),
+		Deleted:         make(map[Checksum]Tombstone),
 	}
 }
 
-// NewCatalog creates a new empty Catalog
+// NewCatalog creates a new empty Catalog using the default hash algorithm
 func NewCatalog() Catalog {
 	return newcatalog()
 }
 
+// NewCatalogWithAlgorithm creates a new empty Catalog that stores checksums calculated with the given algorithm
+func NewCatalogWithAlgorithm(alg HashAlgorithm) Catalog {
+	return newcatalogWithAlgorithm(alg)
+}
+
+func (c *catalog) HashAlgorithm() HashAlgorithm {
+	return c.Algorithm
+}
+
+func (c *catalog) State() State {
+	return c.Status
+}
+
+func (c *catalog) SetState(state State) {
+	c.Status = state
+}
+
 func (c *catalog) Clone() Catalog {
-	clone := newcatalog()
+	clone := newcatalogWithAlgorithm(c.Algorithm)
+	clone.Status = c.Status
+	clone.readVersion = c.readVersion
 	for k, v := range c.Items {
 		clone.Items[k] = v
 	}
@@ -93,7 +153,7 @@ func (c *catalog) Clone() Catalog {
 		clone.checksumToPaths[k] = v
 	}
 	for k, v := range c.Deleted {
-		clone.Deleted[k] = v
+		clone.Deleted[k] = v.clone()
 	}
 	return clone
 }
@@ -102,10 +162,13 @@ func (c *catalog) Add(item Item) error {
 	if _, ok := c.Items[item.Path]; ok {
 		return fmt.Errorf("File is already in the catalog: '%v'", item.Path)
 	}
+	if alg := item.Checksum.Algorithm(); alg != c.Algorithm {
+		return fmt.Errorf("Checksum algorithm of '%v' is %v, the catalog uses %v", item.Path, alg, c.Algorithm)
+	}
 
-	delete(c.Deleted, item.Md5Sum)
+	delete(c.Deleted, item.Checksum)
 	c.Items[item.Path] = item
-	c.checksumToPaths[item.Md5Sum] = append(c.checksumToPaths[item.Md5Sum], item.Path)
+	c.checksumToPaths[item.Checksum] = append(c.checksumToPaths[item.Checksum], item.Path)
 
 	return nil
 }
@@ -122,36 +185,74 @@ func removeItem(slice []string, v string) []string {
 
 func (c *catalog) Set(newItem Item) error {
 	if item, ok := c.Items[newItem.Path]; ok {
-		origChecksum := c.Items[item.Path].Md5Sum
+		origChecksum := c.Items[item.Path].Checksum
 		delete(c.Items, item.Path)
 		c.removeChecksumToPathMapping(origChecksum, item.Path)
 	}
 	return c.Add(newItem)
 }
 
+func (c *catalog) Move(oldPath string, newPath string) error {
+	item, ok := c.Items[oldPath]
+	if !ok {
+		return errors.Errorf("No such file: %v", oldPath)
+	}
+	if _, ok := c.Items[newPath]; ok {
+		return errors.Errorf("File is already in the catalog: '%v'", newPath)
+	}
+	c.ForgetPath(oldPath)
+	item.Path = newPath
+	return c.Add(item)
+}
+
 func (c *catalog) DeletePath(path string) {
 	item, ok := c.Items[path]
 	if !ok {
 		return
 	}
-	paths, _ := c.checksumToPaths[item.Md5Sum]
+	paths, _ := c.checksumToPaths[item.Checksum]
 	if len(paths) == 1 {
-		c.Deleted[item.Md5Sum] = true
+		c.Deleted[item.Checksum] = newTombstone([]Item{item})
 	}
-	c.removeChecksumToPathMapping(item.Md5Sum, item.Path)
+	c.removeChecksumToPathMapping(item.Checksum, item.Path)
 	delete(c.Items, path)
 }
 
 func (c *catalog) DeleteChecksum(sum Checksum) {
-	paths, ok := c.checksumToPaths[sum]
-	if ok {
-		for _, p := range paths {
-			item := c.Items[p]
-			c.removeChecksumToPathMapping(item.Md5Sum, item.Path)
-			delete(c.Items, p)
-		}
+	items := c.removeChecksum(sum)
+	tombstone, ok := c.Deleted[sum]
+	if !ok {
+		c.Deleted[sum] = newTombstone(items)
+		return
+	}
+	tombstone.addItems(items)
+	c.Deleted[sum] = tombstone
+}
+
+func (c *catalog) AddTombstone(sum Checksum, tombstone Tombstone) {
+	c.removeChecksum(sum)
+	c.Deleted[sum] = tombstone.clone()
+}
+
+func (c *catalog) Tombstone(sum Checksum) (Tombstone, error) {
+	tombstone, ok := c.Deleted[sum]
+	if !ok {
+		return Tombstone{}, errors.Errorf("Checksum is not deleted: %v", sum)
+	}
+	return tombstone.clone(), nil
+}
+
+// removeChecksum removes all items with the given checksum and returns them
+func (c *catalog) removeChecksum(sum Checksum) []Item {
+	paths := append([]string(nil), c.checksumToPaths[sum]...)
+	items := make([]Item, 0, len(paths))
+	for _, p := range paths {
+		item := c.Items[p]
+		c.removeChecksumToPathMapping(item.Checksum, item.Path)
+		delete(c.Items, p)
+		items = append(items, item)
 	}
-	c.Deleted[sum] = true
+	return items
 }
 
 func (c *catalog) UnDeleteChecksum(sum Checksum) {
@@ -194,8 +295,8 @@ func (c *catalog) ItemsByChecksum(sum Checksum) ([]Item, error) {
 }
 
 func (c *catalog) IsDeletedChecksum(sum Checksum) bool {
-	deleted, ok := c.Deleted[sum]
-	return ok && deleted
+	_, ok := c.Deleted[sum]
+	return ok
 }
 
 func (c *catalog) Write(fs afero.Fs) error {
@@ -203,18 +304,25 @@ func (c *catalog) Write(fs afero.Fs) error {
 }
 
 func (c *catalog) WriteAs(fs afero.Fs, path string) error {
+	if c.readVersion < CatalogVersion {
+		if err := backupOldCatalog(fs, path); err != nil {
+			return err
+		}
+		c.readVersion = CatalogVersion
+	}
+	c.Version = CatalogVersion
 	json, _ := json.Marshal(c)
-	err := afero.WriteFile(fs, path, json, 0644)
+	err := writeFileAtomic(fs, path, json)
 	return errors.Wrapf(err, "Cannot save catalog to file: '%v'", path)
 }
 
 func (c *catalog) FilterNew(other Catalog) Catalog {
-	ret := NewCatalog()
+	ret := NewCatalogWithAlgorithm(c.Algorithm)
 	for _, item := range c.Items {
-		if other.IsDeletedChecksum(item.Md5Sum) {
+		if other.IsDeletedChecksum(item.Checksum) {
 			continue
 		}
-		if _, err := other.ItemsByChecksum(item.Md5Sum); err == nil {
+		if _, err := other.ItemsByChecksum(item.Checksum); err == nil {
 			continue
 		}
 		ret.Add(item)
@@ -247,7 +355,7 @@ func (c *catalog) ForgetPath(path string) {
 	if !ok {
 		return
 	}
-	c.removeChecksumToPathMapping(item.Md5Sum, item.Path)
+	c.removeChecksumToPathMapping(item.Checksum, item.Path)
 	delete(c.Items, item.Path)
 }
 
@@ -304,20 +412,65 @@ func (c *catalog) DeletedChecksums() <-chan Checksum {
 
 }
 
-// Read reads catalog stored in a json file
+// Read reads catalog stored in a json file.
+// Catalogs written in an older format are upgraded to the current version. The file itself is not changed,
+// but a backup of it is created when the catalog is written to the same path.
+// If the file exists but cannot be read or parsed (e.g. it was truncated by a crash), the newest valid
+// backup generation is returned instead, together with a BackupUsedError telling which generation it is.
 func Read(fs afero.Fs, path string) (Catalog, error) {
+	c, err := readFile(fs, path)
+	if err == nil {
+		return c, nil
+	}
+	if cause := errors.Cause(err); os.IsNotExist(cause) || cause == ErrUnsupportedVersion {
+		return nil, err
+	}
+	for i := 1; i <= catalogBackupCount; i++ {
+		if backup, backupErr := readFile(fs, generationFileName(path, i)); backupErr == nil {
+			return backup, &BackupUsedError{Path: path, Generation: i, Err: err}
+		}
+	}
+	return nil, err
+}
+
+// BackupUsedError is returned by Read along with the catalog read from a backup generation, when the catalog file
+// itself is damaged. Everything recorded in the catalog after the backup was made, like deleted files, is missing
+// from the returned catalog.
+type BackupUsedError struct {
+	Path string
+	// Generation is the backup generation that was read, 1 is the newest
+	Generation int
+	// Err is the reason the catalog file couldn't be read
+	Err error
+}
+
+func (e *BackupUsedError) Error() string {
+	return fmt.Sprintf("The catalog is damaged (%v), its backup '%v' is used instead, the changes made after it are lost",
+		e.Err, generationFileName(e.Path, e.Generation))
+}
+
+// readFile reads a single catalog file, without falling back to its backups
+func readFile(fs afero.Fs, path string) (*catalog, error) {
 	buf, err := afero.ReadFile(fs, path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Cannot read catalog: '%v'", path)
 	}
+	buf, version, err := migrate(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse catalog json: '%v'", path)
+	}
 	c := newcatalog()
 	err = json.Unmarshal(buf, c)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Cannot parse catalog json: '%v'", path)
 	}
+	c.readVersion = version
+	if _, err := c.Algorithm.New(); err != nil {
+		return nil, errors.Wrapf(err, "Cannot use catalog: '%v'", path)
+	}
 
 	for _, item := range c.Items {
-		c.checksumToPaths[item.Md5Sum] = append(c.checksumToPaths[item.Md5Sum], item.Path)
+		c.checksumToPaths[item.Checksum] = append(c.checksumToPaths[item.Checksum], item.Path)
 	}
 	return c, nil
 }
@@ -89,7 +89,7 @@ func TestAddDuplicatedChecksum(t *testing.T) {
 
 	th.Equals(t, 2, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
-	actualItems, err := c.ItemsByChecksum(item1.Md5Sum)
+	actualItems, err := c.ItemsByChecksum(item1.Checksum)
 	expectedItems := []Item{*item1, *item2}
 	th.Ok(t, err)
 	th.Equals(t, expectedItems, actualItems)
@@ -139,7 +139,7 @@ func TestDeleteUndeleteChecksum(t *testing.T) {
 	item, err := NewItem(fs, path)
 	th.Ok(t, err)
 	err = c.Add(*item)
-	sum2 := item.Md5Sum
+	sum2 := item.Checksum
 	th.Ok(t, err)
 	th.Equals(t, 1, c.Count())
 	th.Equals(t, 1, c.DeletedCount())
@@ -177,7 +177,7 @@ func TestDeletePathWithDuplicatedChecksum(t *testing.T) {
 	th.Ok(t, err)
 	path2 := "../test_data/test2.txt"
 	item2, err := NewItem(fs, path2)
-	item2.Md5Sum = item1.Md5Sum
+	item2.Checksum = item1.Checksum
 	th.Ok(t, err)
 	err = c.Add(*item1)
 	th.Ok(t, err)
@@ -185,7 +185,7 @@ func TestDeletePathWithDuplicatedChecksum(t *testing.T) {
 	th.Ok(t, err)
 	th.Equals(t, 2, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
-	th.Equals(t, false, c.IsDeletedChecksum(item1.Md5Sum))
+	th.Equals(t, false, c.IsDeletedChecksum(item1.Checksum))
 
 	c.DeletePath(item1.Path)
 	storedItem, err := c.Item(item1.Path)
@@ -198,7 +198,7 @@ func TestDeletePathWithDuplicatedChecksum(t *testing.T) {
 	c.DeletePath(item2.Path)
 	th.Equals(t, 0, c.Count())
 	th.Equals(t, 1, c.DeletedCount())
-	th.Equals(t, true, c.IsDeletedChecksum(item1.Md5Sum))
+	th.Equals(t, true, c.IsDeletedChecksum(item1.Checksum))
 }
 
 func TestAddFileWithDeletedChecksum(t *testing.T) {
@@ -209,16 +209,16 @@ func TestAddFileWithDeletedChecksum(t *testing.T) {
 	item, err := NewItem(fs, path)
 	th.Ok(t, err)
 
-	c.DeleteChecksum(item.Md5Sum)
+	c.DeleteChecksum(item.Checksum)
 	th.Equals(t, 0, c.Count())
 	th.Equals(t, 1, c.DeletedCount())
-	th.Equals(t, true, c.IsDeletedChecksum(item.Md5Sum))
+	th.Equals(t, true, c.IsDeletedChecksum(item.Checksum))
 
 	err = c.Add(*item)
 	th.Ok(t, err)
 	th.Equals(t, 1, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
-	th.Equals(t, false, c.IsDeletedChecksum(item.Md5Sum))
+	th.Equals(t, false, c.IsDeletedChecksum(item.Checksum))
 
 }
 
@@ -257,23 +257,23 @@ func TestSetExisting(t *testing.T) {
 	th.Ok(t, err)
 
 	other := *item
-	other.Md5Sum = "x"
+	other.Checksum = "x"
 	other.Size = 12345
 	other.ModificationTime = "yesterday"
 	th.Equals(t, 1, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
 	actual, _ := c.Item(item.Path)
 	th.Equals(t, *item, actual)
-	actualList, _ := c.ItemsByChecksum(item.Md5Sum)
+	actualList, _ := c.ItemsByChecksum(item.Checksum)
 	th.Equals(t, []Item{*item}, actualList)
 	c.Set(other)
 	th.Equals(t, 1, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
 	actual, _ = c.Item(item.Path)
 	th.Equals(t, other, actual)
-	actualList, _ = c.ItemsByChecksum(other.Md5Sum)
+	actualList, _ = c.ItemsByChecksum(other.Checksum)
 	th.Equals(t, []Item{other}, actualList)
-	actualList, _ = c.ItemsByChecksum(item.Md5Sum)
+	actualList, _ = c.ItemsByChecksum(item.Checksum)
 	th.Equals(t, []Item{}, actualList)
 }
 
@@ -370,9 +370,9 @@ func TestClone(t *testing.T) {
 }
 
 func TestFilterNew(t *testing.T) {
-	a := Item{Path: "some/path/to/a", Md5Sum: "a", Size: 42}
-	b := Item{Path: "some/other/b", Md5Sum: "b", Size: 213456}
-	c := Item{Path: "path_to/c", Md5Sum: "c", Size: 987}
+	a := Item{Path: "some/path/to/a", Checksum: "a", Size: 42}
+	b := Item{Path: "some/other/b", Checksum: "b", Size: 213456}
+	c := Item{Path: "path_to/c", Checksum: "c", Size: 987}
 	collection := NewCatalog()
 	newFolder := NewCatalog()
 
@@ -394,14 +394,14 @@ func TestFilterNew(t *testing.T) {
 }
 
 func TestFilterNewWithDeleted(t *testing.T) {
-	a := Item{Path: "some/path/to/a", Md5Sum: "a", Size: 42}
-	b := Item{Path: "some/other/b", Md5Sum: "b", Size: 213456}
-	c := Item{Path: "path_to/c", Md5Sum: "c", Size: 987}
+	a := Item{Path: "some/path/to/a", Checksum: "a", Size: 42}
+	b := Item{Path: "some/other/b", Checksum: "b", Size: 213456}
+	c := Item{Path: "path_to/c", Checksum: "c", Size: 987}
 	collection := NewCatalog()
 	newFolder := NewCatalog()
 
 	collection.Add(a)
-	collection.DeleteChecksum(b.Md5Sum)
+	collection.DeleteChecksum(b.Checksum)
 	expected := NewCatalog()
 	th.Equals(t, expected, newFolder.FilterNew(collection))
 
@@ -418,9 +418,9 @@ func TestFilterNewWithDeleted(t *testing.T) {
 }
 
 func TestAllItems(t *testing.T) {
-	a := Item{Path: "some/path/to/a", Md5Sum: "a", Size: 42}
-	b := Item{Path: "some/other/b", Md5Sum: "b", Size: 213456}
-	c := Item{Path: "path_to/c", Md5Sum: "c", Size: 987}
+	a := Item{Path: "some/path/to/a", Checksum: "a", Size: 42}
+	b := Item{Path: "some/other/b", Checksum: "b", Size: 213456}
+	c := Item{Path: "path_to/c", Checksum: "c", Size: 987}
 
 	paths := func(items <-chan Item) <-chan string {
 		ret := make(chan string)
@@ -492,27 +492,27 @@ func TestDeletedChecksums(t *testing.T) {
 }
 
 func TestIsKnownChecksum(t *testing.T) {
-	item1 := Item{Path: "some/path/to/a", Md5Sum: "a", Size: 42}
-	item2 := Item{Path: "some/other/b", Md5Sum: "b", Size: 213456}
+	item1 := Item{Path: "some/path/to/a", Checksum: "a", Size: 42}
+	item2 := Item{Path: "some/other/b", Checksum: "b", Size: 213456}
 
 	c := NewCatalog()
-	th.Equals(t, false, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, false, c.IsKnownChecksum(item2.Md5Sum))
+	th.Equals(t, false, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, false, c.IsKnownChecksum(item2.Checksum))
 	c.Add(item1)
-	th.Equals(t, true, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, false, c.IsKnownChecksum(item2.Md5Sum))
-	c.DeleteChecksum(item2.Md5Sum)
-	th.Equals(t, true, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, true, c.IsKnownChecksum(item2.Md5Sum))
-	c.DeleteChecksum(item1.Md5Sum)
-	th.Equals(t, true, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, true, c.IsKnownChecksum(item2.Md5Sum))
-	c.UnDeleteChecksum(item1.Md5Sum)
-	th.Equals(t, false, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, true, c.IsKnownChecksum(item2.Md5Sum))
-	c.UnDeleteChecksum(item2.Md5Sum)
-	th.Equals(t, false, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, false, c.IsKnownChecksum(item2.Md5Sum))
+	th.Equals(t, true, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, false, c.IsKnownChecksum(item2.Checksum))
+	c.DeleteChecksum(item2.Checksum)
+	th.Equals(t, true, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, true, c.IsKnownChecksum(item2.Checksum))
+	c.DeleteChecksum(item1.Checksum)
+	th.Equals(t, true, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, true, c.IsKnownChecksum(item2.Checksum))
+	c.UnDeleteChecksum(item1.Checksum)
+	th.Equals(t, false, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, true, c.IsKnownChecksum(item2.Checksum))
+	c.UnDeleteChecksum(item2.Checksum)
+	th.Equals(t, false, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, false, c.IsKnownChecksum(item2.Checksum))
 }
 
 func TestForgetPath(t *testing.T) {
@@ -530,22 +530,22 @@ func TestForgetPath(t *testing.T) {
 
 	c := createTestCatalog(fs)
 	c.Add(*itemCopy)
-	th.Equals(t, true, c.IsKnownChecksum(item1.Md5Sum))
-	th.Equals(t, false, c.IsDeletedChecksum(item1.Md5Sum))
-	th.Equals(t, true, c.IsKnownChecksum(item2.Md5Sum))
-	th.Equals(t, false, c.IsDeletedChecksum(item2.Md5Sum))
+	th.Equals(t, true, c.IsKnownChecksum(item1.Checksum))
+	th.Equals(t, false, c.IsDeletedChecksum(item1.Checksum))
+	th.Equals(t, true, c.IsKnownChecksum(item2.Checksum))
+	th.Equals(t, false, c.IsDeletedChecksum(item2.Checksum))
 
 	c.ForgetPath(item1.Path)
-	th.Equals(t, true, c.IsKnownChecksum(item1.Md5Sum)) // a copy is still there
-	th.Equals(t, false, c.IsDeletedChecksum(item1.Md5Sum))
+	th.Equals(t, true, c.IsKnownChecksum(item1.Checksum)) // a copy is still there
+	th.Equals(t, false, c.IsDeletedChecksum(item1.Checksum))
 
 	storedCopy, err := c.Item(itemCopy.Path)
 	th.Ok(t, err)
 	th.Equals(t, *itemCopy, storedCopy)
 
 	c.ForgetPath(item2.Path)
-	th.Equals(t, false, c.IsKnownChecksum(item2.Md5Sum))
-	th.Equals(t, false, c.IsDeletedChecksum(item2.Md5Sum))
+	th.Equals(t, false, c.IsKnownChecksum(item2.Checksum))
+	th.Equals(t, false, c.IsDeletedChecksum(item2.Checksum))
 
 	storedItem1, err := c.Item(item1.Path)
 	th.NokPrefix(t, err, "No such file")
@@ -555,3 +555,95 @@ func TestForgetPath(t *testing.T) {
 	th.NokPrefix(t, err, "No such file")
 	th.Equals(t, Item{}, storedItem2)
 }
+
+func TestAddChecksumAlgorithmMismatch(t *testing.T) {
+	c := NewCatalogWithAlgorithm(SHA256)
+	th.Equals(t, SHA256, c.HashAlgorithm())
+	err := c.Add(Item{Path: "a", Checksum: "sha256:a", Size: 42})
+	th.Ok(t, err)
+	err = c.Add(Item{Path: "b", Checksum: "b", Size: 42})
+	th.Nok(t, err, "Checksum algorithm of 'b' is md5, the catalog uses sha256")
+	th.Equals(t, 1, c.Count())
+}
+
+func TestFilterNewDifferentAlgorithms(t *testing.T) {
+	a := Item{Path: "some/path/to/a", Checksum: "a", Size: 42}
+	b := Item{Path: "some/other/b", Checksum: "sha256:a", Size: 42}
+	collection := NewCatalogWithAlgorithm(SHA256)
+	collection.Add(b)
+	newFolder := NewCatalog()
+	newFolder.Add(a)
+
+	th.Equals(t, false, collection.IsKnownChecksum(a.Checksum))
+	expected := NewCatalog()
+	expected.Add(a)
+	th.Equals(t, expected, newFolder.FilterNew(collection))
+}
+
+func TestReadLegacyMd5Catalog(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(legacyCatalog), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, MD5, c.HashAlgorithm())
+	item, err := c.Item("a.txt")
+	th.Ok(t, err)
+	th.Equals(t, Checksum("b3cd1cf6179bca32fd5d76473b129117"), item.Checksum)
+	th.Equals(t, int64(42), item.Size)
+	th.Equals(t, true, c.IsKnownChecksum("b3cd1cf6179bca32fd5d76473b129117"))
+	th.Equals(t, true, c.IsDeletedChecksum("1234"))
+}
+
+func TestWriteReadWithAlgorithm(t *testing.T) {
+	basePath, _ := os.Getwd()
+	path := "../test_data/subfolder"
+	fs := fsh.CreateSafeFs(filepath.Join(basePath, path))
+	c := NewCatalogWithAlgorithm(BLAKE3)
+	item, err := NewItemWithAlgorithm(fs, "file1.bin", BLAKE3)
+	th.Ok(t, err)
+	th.Ok(t, c.Add(*item))
+	th.Ok(t, c.Write(fs))
+	c2, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, c, c2)
+}
+
+func TestWriteReadState(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewCatalog()
+	th.Equals(t, Initializing, c.State())
+	for _, state := range []State{Initialized, Incomplete, Copying, Copied, Done, Corrupted} {
+		c.SetState(state)
+		th.Ok(t, c.Write(fs))
+		c2, err := Read(fs, CatalogFileName)
+		th.Ok(t, err)
+		th.Equals(t, state, c2.State())
+	}
+}
+
+func TestMove(t *testing.T) {
+	c := NewCatalog()
+	item1 := Item{Path: "a/file1", Size: 10, ModificationTime: "2018-10-24T23:38:47.713775685+01:00", Checksum: "1234"}
+	item2 := Item{Path: "a/file2", Size: 20, Checksum: "5678"}
+	th.Ok(t, c.Add(item1))
+	th.Ok(t, c.Add(item2))
+
+	th.Ok(t, c.Move("a/file1", "b/renamed"))
+	th.Equals(t, 2, c.Count())
+	th.Equals(t, 0, c.DeletedCount())
+	_, err := c.Item("a/file1")
+	th.NokPrefix(t, err, "No such file")
+	moved, err := c.Item("b/renamed")
+	th.Ok(t, err)
+	item1.Path = "b/renamed"
+	th.Equals(t, item1, moved)
+	items, err := c.ItemsByChecksum("1234")
+	th.Ok(t, err)
+	th.Equals(t, []Item{item1}, items)
+
+	err = c.Move("a/file1", "c")
+	th.NokPrefix(t, err, "No such file: a/file1")
+	err = c.Move("b/renamed", "a/file2")
+	th.NokPrefix(t, err, "File is already in the catalog: 'a/file2'")
+	th.Equals(t, 2, c.Count())
+}
@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zeebo/blake3"
+)
+
+// HashAlgorithm identifies the hash function used to calculate the checksums of the files
+type HashAlgorithm string
+
+const (
+	// MD5 is the original hash algorithm of CoBack. Catalogs without an explicit algorithm use this.
+	MD5 HashAlgorithm = "md5"
+	// SHA256 is the SHA-256 hash algorithm
+	SHA256 HashAlgorithm = "sha256"
+	// BLAKE3 is the BLAKE3 hash algorithm with the default 256 bit output
+	BLAKE3 HashAlgorithm = "blake3"
+)
+
+// DefaultHashAlgorithm is used for new catalogs if no algorithm is specified
+const DefaultHashAlgorithm = MD5
+
+// checksumSeparator separates the algorithm tag from the hex digest in a Checksum
+const checksumSeparator = ":"
+
+// ParseHashAlgorithm returns the HashAlgorithm with the given name. Returns error if the algorithm is not supported.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	alg := HashAlgorithm(strings.ToLower(name))
+	switch alg {
+	case MD5, SHA256, BLAKE3:
+		return alg, nil
+	}
+	return "", errors.Errorf("Unsupported hash algorithm: '%v'", name)
+}
+
+// New creates a new hash.Hash calculating the checksum with the algorithm
+func (a HashAlgorithm) New() (hash.Hash, error) {
+	switch a {
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(), nil
+	}
+	return nil, errors.Errorf("Unsupported hash algorithm: '%v'", a)
+}
+
+// NewChecksum creates a Checksum from the raw output of a hash function.
+// MD5 checksums are not tagged with the name of the algorithm to keep them compatible with
+// catalogs created before other algorithms were supported. All other checksums are
+// stored as "<algorithm>:<hex digest>".
+func NewChecksum(alg HashAlgorithm, sum []byte) Checksum {
+	digest := hex.EncodeToString(sum)
+	if alg == MD5 {
+		return Checksum(digest)
+	}
+	return Checksum(string(alg) + checksumSeparator + digest)
+}
+
+// Algorithm returns the hash algorithm that was used to calculate the checksum
+func (c Checksum) Algorithm() HashAlgorithm {
+	idx := strings.Index(string(c), checksumSeparator)
+	if idx == -1 {
+		return MD5
+	}
+	return HashAlgorithm(c[:idx])
+}
+
+// Digest returns the hex digest part of the checksum without the algorithm tag
+func (c Checksum) Digest() string {
+	idx := strings.Index(string(c), checksumSeparator)
+	return string(c[idx+1:])
+}
@@ -0,0 +1,55 @@
+package catalog
+
+import (
+	"testing"
+
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestParseHashAlgorithm(t *testing.T) {
+	alg, err := ParseHashAlgorithm("md5")
+	th.Ok(t, err)
+	th.Equals(t, MD5, alg)
+	alg, err = ParseHashAlgorithm("SHA256")
+	th.Ok(t, err)
+	th.Equals(t, SHA256, alg)
+	alg, err = ParseHashAlgorithm("blake3")
+	th.Ok(t, err)
+	th.Equals(t, BLAKE3, alg)
+	_, err = ParseHashAlgorithm("crc32")
+	th.Nok(t, err, "Unsupported hash algorithm: 'crc32'")
+}
+
+func TestChecksumAlgorithm(t *testing.T) {
+	md5Sum := Checksum("b3cd1cf6179bca32fd5d76473b129117")
+	th.Equals(t, MD5, md5Sum.Algorithm())
+	th.Equals(t, "b3cd1cf6179bca32fd5d76473b129117", md5Sum.Digest())
+
+	shaSum := Checksum("sha256:0f3a7d03932add5452fe02a8ff295d0dee8c87cc921d1160d2a59d31ef8c92fd")
+	th.Equals(t, SHA256, shaSum.Algorithm())
+	th.Equals(t, "0f3a7d03932add5452fe02a8ff295d0dee8c87cc921d1160d2a59d31ef8c92fd", shaSum.Digest())
+}
+
+func TestNewChecksum(t *testing.T) {
+	th.Equals(t, Checksum("0102ff"), NewChecksum(MD5, []byte{1, 2, 255}))
+	th.Equals(t, Checksum("sha256:0102ff"), NewChecksum(SHA256, []byte{1, 2, 255}))
+	th.Equals(t, Checksum("blake3:0102ff"), NewChecksum(BLAKE3, []byte{1, 2, 255}))
+}
+
+func TestNewItemWithAlgorithm(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := "../test_data/test1.txt"
+	item, err := NewItemWithAlgorithm(fs, path, SHA256)
+	th.Ok(t, err)
+	th.Equals(t, Checksum("sha256:0f3a7d03932add5452fe02a8ff295d0dee8c87cc921d1160d2a59d31ef8c92fd"), item.Checksum)
+
+	memFs := afero.NewMemMapFs()
+	afero.WriteFile(memFs, "empty", []byte{}, 0644)
+	item, err = NewItemWithAlgorithm(memFs, "empty", BLAKE3)
+	th.Ok(t, err)
+	th.Equals(t, Checksum("blake3:af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"), item.Checksum)
+
+	_, err = NewItemWithAlgorithm(fs, path, HashAlgorithm("crc32"))
+	th.Nok(t, err, "Unsupported hash algorithm: 'crc32'")
+}
@@ -1,40 +1,100 @@
 package catalog
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"io"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
+// hashBufferSize is the size of the buffer used to stream the content of a file to the hash function.
+// The memory used for hashing a file doesn't depend on the size of the file.
+const hashBufferSize = 64 * 1024
+
 // Item represents the metadata for one file stored in the catalog
 type Item struct {
 	Path             string   `json:"path"`
 	Size             int64    `json:"size"`
 	ModificationTime string   `json:"modification_time"`
-	Md5Sum           Checksum `json:"md5sum"`
+	Checksum         Checksum `json:"checksum"`
+	// VerificationTime is the time the content of the file was last read again and found unchanged, empty if never
+	VerificationTime string `json:"verification_time,omitempty"`
+	// Corrupted is set if the verification found that the content of the file changed without changing its size and
+	// modification time. The checksum is still the checksum of the original content.
+	Corrupted bool `json:"corrupted,omitempty"`
+}
+
+// SameContent returns true if the items describe the same version of a file: their size, modification time and
+// checksum are the same. The path and the verification results are not compared.
+func (i Item) SameContent(other Item) bool {
+	return i.Size == other.Size && i.ModificationTime == other.ModificationTime && i.Checksum == other.Checksum
 }
 
-// NewItem creates an Item for the specified file
+// NewItem creates an Item for the specified file using the default hash algorithm.
+// The content of the file is read and hashed in chunks, the file is never loaded into memory as a whole.
 func NewItem(fs afero.Fs, path string) (*Item, error) {
-	buf, err := afero.ReadFile(fs, path)
+	return NewItemWithAlgorithm(fs, path, DefaultHashAlgorithm)
+}
+
+// HashCache stores the checksums calculated for files, so they don't have to be read again while they don't change
+type HashCache interface {
+	// Checksum returns the checksum of the file calculated earlier with the algorithm, if the file hasn't changed since
+	Checksum(fs afero.Fs, path string, fi os.FileInfo, alg HashAlgorithm) (Checksum, bool)
+	// Add stores the checksum calculated for the file
+	Add(fs afero.Fs, path string, fi os.FileInfo, sum Checksum)
+}
+
+// NewItemWithAlgorithm creates an Item for the specified file, the checksum is calculated with the given algorithm.
+func NewItemWithAlgorithm(fs afero.Fs, path string, alg HashAlgorithm) (*Item, error) {
+	return NewItemWithCache(fs, path, alg, nil)
+}
+
+// NewItemWithCache creates an Item for the specified file the same way as NewItemWithAlgorithm, but the file is only
+// read if its checksum is not in the cache yet. The calculated checksums are added to the cache. The cache can be nil.
+func NewItemWithCache(fs afero.Fs, path string, alg HashAlgorithm, cache HashCache) (*Item, error) {
+	if cache != nil {
+		if fi, err := fs.Stat(path); err == nil {
+			if sum, ok := cache.Checksum(fs, path, fi, alg); ok {
+				return &Item{
+					Path:             path,
+					Size:             fi.Size(),
+					ModificationTime: fi.ModTime().Format(time.RFC3339Nano),
+					Checksum:         sum,
+				}, nil
+			}
+		}
+	}
+
+	hash, err := alg.New()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := fs.Open(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "Cannot open file")
 	}
+	defer f.Close()
 
 	fi, err := fs.Stat(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "Cannot get file info")
 	}
 
-	hash := md5.New()
-	hash.Write(buf)
+	buf := make([]byte, hashBufferSize)
+	if _, err := io.CopyBuffer(hash, f, buf); err != nil {
+		return nil, errors.Wrap(err, "Cannot read file")
+	}
+	sum := NewChecksum(alg, hash.Sum(nil))
+	if cache != nil {
+		cache.Add(fs, path, fi, sum)
+	}
 	return &Item{
 		Path:             path,
 		Size:             fi.Size(),
 		ModificationTime: fi.ModTime().Format(time.RFC3339Nano),
-		Md5Sum:           Checksum(hex.EncodeToString(hash.Sum(nil))),
+		Checksum:         sum,
 	}, nil
 }
@@ -1,6 +1,9 @@
 package catalog
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -31,7 +34,7 @@ func TestCatalogItem(t *testing.T) {
 	th.Equals(t, path, item.Path)
 	th.Equals(t, int64(1160), item.Size)
 	th.Equals(t, strTs, item.ModificationTime)
-	th.Equals(t, Checksum("b3cd1cf6179bca32fd5d76473b129117"), item.Md5Sum)
+	th.Equals(t, Checksum("b3cd1cf6179bca32fd5d76473b129117"), item.Checksum)
 }
 
 func TestCatalogItem2(t *testing.T) {
@@ -48,5 +51,77 @@ func TestCatalogItem2(t *testing.T) {
 	th.Equals(t, path, item.Path)
 	th.Equals(t, int64(1304), item.Size)
 	th.Equals(t, strTs, item.ModificationTime)
-	th.Equals(t, Checksum("89b2b34c7b8d232041f0fcc1d213d7bc"), item.Md5Sum)
+	th.Equals(t, Checksum("89b2b34c7b8d232041f0fcc1d213d7bc"), item.Checksum)
+}
+
+func TestCatalogItemLargerThanHashBuffer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "large.bin"
+	content := make([]byte, 3*hashBufferSize+123)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	err := afero.WriteFile(fs, path, content, 0644)
+	th.Ok(t, err)
+	item, err := NewItem(fs, path)
+	th.Ok(t, err)
+	expectedSum := md5.Sum(content)
+	th.Equals(t, int64(len(content)), item.Size)
+	th.Equals(t, Checksum(hex.EncodeToString(expectedSum[:])), item.Checksum)
+}
+
+// mapHashCache is a HashCache that identifies the files by their path and size
+type mapHashCache struct {
+	sums map[string]Checksum
+	adds int
+}
+
+func (c *mapHashCache) key(path string, fi os.FileInfo, alg HashAlgorithm) string {
+	return fmt.Sprintf("%v:%v:%v", path, fi.Size(), alg)
+}
+
+func (c *mapHashCache) Checksum(fs afero.Fs, path string, fi os.FileInfo, alg HashAlgorithm) (Checksum, bool) {
+	sum, ok := c.sums[c.key(path, fi, alg)]
+	return sum, ok
+}
+
+func (c *mapHashCache) Add(fs afero.Fs, path string, fi os.FileInfo, sum Checksum) {
+	c.sums[c.key(path, fi, sum.Algorithm())] = sum
+	c.adds++
+}
+
+func TestNewItemWithCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content"), 0644))
+	cache := &mapHashCache{sums: make(map[string]Checksum)}
+
+	item, err := NewItemWithCache(fs, "a.txt", MD5, cache)
+	th.Ok(t, err)
+	th.Equals(t, Checksum("9a0364b9e99bb480dd25e1f0284c8555"), item.Checksum)
+	th.Equals(t, 1, cache.adds)
+
+	// the cached checksum is used, the file is not read again
+	cache.sums[cache.key("a.txt", fileInfo(t, fs, "a.txt"), MD5)] = "cached"
+	cached, err := NewItemWithCache(fs, "a.txt", MD5, cache)
+	th.Ok(t, err)
+	th.Equals(t, Checksum("cached"), cached.Checksum)
+	th.Equals(t, item.Size, cached.Size)
+	th.Equals(t, item.ModificationTime, cached.ModificationTime)
+	th.Equals(t, 1, cache.adds)
+
+	// other algorithms are not served from the cache
+	other, err := NewItemWithCache(fs, "a.txt", SHA256, cache)
+	th.Ok(t, err)
+	th.Equals(t, SHA256, other.Checksum.Algorithm())
+	th.Equals(t, 2, cache.adds)
+
+	_, err = NewItemWithCache(fs, "no_such_file", MD5, cache)
+	th.NokPrefix(t, err, "Cannot open file")
+}
+
+func fileInfo(t *testing.T, fs afero.Fs, path string) os.FileInfo {
+	t.Helper()
+	fi, err := fs.Stat(path)
+	th.Ok(t, err)
+	return fi
 }
@@ -0,0 +1,151 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// CatalogVersion is the version of the catalog file format written by this version of CoBack.
+// Catalogs written by older versions are upgraded in memory when they are read.
+const CatalogVersion = 3
+
+// ErrUnsupportedVersion is the cause of the error returned by Read if the catalog was written by a newer version of CoBack
+var ErrUnsupportedVersion = errors.New("Unsupported catalog version")
+
+// rawCatalog is the generic json representation of a catalog file, used to migrate between the versions
+type rawCatalog map[string]interface{}
+
+// migration upgrades a raw catalog from one version to the next
+type migration func(raw rawCatalog) error
+
+// migrations contains the steps to upgrade the catalog format. The migration at index i upgrades from version i to i+1.
+var migrations = []migration{
+	migrateV0ToV1,
+	migrateV1ToV2,
+	migrateV2ToV3,
+}
+
+// migrateV0ToV1 upgrades catalogs written before the hash algorithm became configurable.
+// The items stored their md5 checksum in the "md5sum" field, and the catalog had no hash algorithm.
+func migrateV0ToV1(raw rawCatalog) error {
+	raw["hash_algorithm"] = string(MD5)
+	items, ok := raw["content"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for path, v := range items {
+		item, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("Invalid item: '%v'", path)
+		}
+		item["checksum"] = item["md5sum"]
+		delete(item, "md5sum")
+	}
+	return nil
+}
+
+// migrateV1ToV2 upgrades catalogs written before the states were used. The state was always stored as 0,
+// these catalogs are treated as initialized, any difference from the folder contents is found by the next sync.
+func migrateV1ToV2(raw rawCatalog) error {
+	raw["state"] = string(Initialized)
+	return nil
+}
+
+// migrateV2ToV3 upgrades catalogs written before the deleted checksums had tombstones.
+// Only "true" was stored for the deleted checksums, these get empty tombstones.
+func migrateV2ToV3(raw rawCatalog) error {
+	deleted, ok := raw["deleted_checksums"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for sum, v := range deleted {
+		if isDeleted, ok := v.(bool); ok && isDeleted {
+			deleted[sum] = map[string]interface{}{}
+		} else {
+			delete(deleted, sum)
+		}
+	}
+	return nil
+}
+
+// parseRawCatalog parses the contents of a catalog file and returns its format version
+func parseRawCatalog(buf []byte) (rawCatalog, int, error) {
+	var raw rawCatalog
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, 0, err
+	}
+	v, ok := raw["version"]
+	if !ok {
+		return raw, 0, nil
+	}
+	number, ok := v.(json.Number)
+	if !ok {
+		return nil, 0, errors.Errorf("Invalid catalog version: '%v'", v)
+	}
+	version, err := number.Int64()
+	if err != nil {
+		return nil, 0, errors.Errorf("Invalid catalog version: '%v'", v)
+	}
+	return raw, int(version), nil
+}
+
+// migrate upgrades the contents of a catalog file to the current format version.
+// Returns the upgraded json and the version of the original.
+func migrate(buf []byte) ([]byte, int, error) {
+	raw, version, err := parseRawCatalog(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > CatalogVersion {
+		return nil, 0, errors.Wrapf(ErrUnsupportedVersion, "Catalog version %v is newer than %v", version, CatalogVersion)
+	}
+	if version == CatalogVersion {
+		return buf, version, nil
+	}
+
+	for v := version; v < CatalogVersion; v++ {
+		if err := migrations[v](raw); err != nil {
+			return nil, 0, errors.Wrapf(err, "Cannot upgrade catalog from version %v", v)
+		}
+	}
+	raw["version"] = CatalogVersion
+	upgraded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	return upgraded, version, nil
+}
+
+// backupFileName returns the name of the backup kept of a catalog file written in an older format version
+func backupFileName(path string, version int) string {
+	return fmt.Sprintf("%v.v%v.bak", path, version)
+}
+
+// backupOldCatalog copies the catalog file at the given path next to it, if it was written in an older format version.
+// An existing backup is never overwritten.
+func backupOldCatalog(fs afero.Fs, path string) error {
+	buf, err := afero.ReadFile(fs, path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Cannot read catalog: '%v'", path)
+	}
+	_, version, err := parseRawCatalog(buf)
+	if err != nil || version >= CatalogVersion {
+		return nil
+	}
+
+	backupPath := backupFileName(path, version)
+	if exists, _ := afero.Exists(fs, backupPath); exists {
+		return nil
+	}
+	err = afero.WriteFile(fs, backupPath, buf, 0644)
+	return errors.Wrapf(err, "Cannot create backup of catalog: '%v'", path)
+}
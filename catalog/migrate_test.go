@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"testing"
+
+	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const legacyCatalog = `{"state":0,"content":{"a.txt":{"path":"a.txt","size":42,"modification_time":"2018-10-24T23:38:47.713775685+01:00","md5sum":"b3cd1cf6179bca32fd5d76473b129117"}},"deleted_checksums":{"1234":true}}`
+
+func TestMigrateCurrentVersionUnchanged(t *testing.T) {
+	buf := []byte(`{"version":3,"state":"initialized","hash_algorithm":"sha256","content":{},"deleted_checksums":{}}`)
+	migrated, version, err := migrate(buf)
+	th.Ok(t, err)
+	th.Equals(t, CatalogVersion, version)
+	th.Equals(t, buf, migrated)
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(`{"version":9999,"content":{}}`), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.NokPrefix(t, err, "Cannot parse catalog json: 'coback.catalog': Catalog version 9999 is newer than 3")
+	th.Equals(t, ErrUnsupportedVersion, errors.Cause(err))
+	th.Equals(t, nil, c)
+}
+
+func TestMigrateInvalidVersion(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(`{"version":"one","content":{}}`), 0644)
+	_, err := Read(fs, CatalogFileName)
+	th.NokPrefix(t, err, "Cannot parse catalog json: 'coback.catalog': Invalid catalog version")
+}
+
+func TestReadV0WritesVersionAndBackup(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(legacyCatalog), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 1, c.Count())
+	th.Equals(t, Initialized, c.State())
+
+	backupPath := backupFileName(CatalogFileName, 0)
+	th.Equals(t, "coback.catalog.v0.bak", backupPath)
+	exists, _ := afero.Exists(fs, backupPath)
+	th.Equals(t, false, exists)
+
+	th.Ok(t, c.Write(fs))
+	backup, err := afero.ReadFile(fs, backupPath)
+	th.Ok(t, err)
+	th.Equals(t, legacyCatalog, string(backup))
+
+	c2, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, c, c2)
+	th.Equals(t, CatalogVersion, c2.(*catalog).Version)
+
+	// writing again must not touch the backup
+	c2.DeleteChecksum("5678")
+	th.Ok(t, c2.Write(fs))
+	backup, err = afero.ReadFile(fs, backupPath)
+	th.Ok(t, err)
+	th.Equals(t, legacyCatalog, string(backup))
+}
+
+func TestCloneOfV0CatalogCreatesBackup(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(legacyCatalog), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Ok(t, c.Clone().Write(fs))
+	exists, _ := afero.Exists(fs, backupFileName(CatalogFileName, 0))
+	th.Equals(t, true, exists)
+}
+
+func TestIsCatalogFile(t *testing.T) {
+	th.Equals(t, true, IsCatalogFile("coback.catalog"))
+	th.Equals(t, true, IsCatalogFile("coback.catalog.v0.bak"))
+	th.Equals(t, false, IsCatalogFile("coback.catalogue"))
+	th.Equals(t, false, IsCatalogFile("my.coback.catalog"))
+	th.Equals(t, false, IsCatalogFile("photo.jpg"))
+}
+
+func TestMigrateV2DeletedChecksums(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, CatalogFileName, []byte(`{"version":2,"state":"initialized","hash_algorithm":"md5","content":{},"deleted_checksums":{"1234":true,"5678":false}}`), 0644)
+	c, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 1, c.DeletedCount())
+	th.Equals(t, true, c.IsDeletedChecksum("1234"))
+	th.Equals(t, false, c.IsKnownChecksum("5678"))
+	tombstone, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, Tombstone{}, tombstone)
+}
@@ -0,0 +1,28 @@
+package catalog
+
+// State is the status of a folder in the whole process, as stored in its catalog.
+//
+// A full scan starts in Initializing and ends in Initialized. If a folder is found to have changed since
+// the catalog was last written, the catalog is Incomplete until the changes are added, then it's Initialized again.
+// The catalog of an import folder is Copying while its new files are copied to the staging folder and Copied
+// once this finished. It is Done when all of its files are either in the collection or were deleted from it.
+// Any catalog can become Corrupted if it no longer matches the contents of its folder in a way that CoBack
+// cannot resolve on its own, such a catalog cannot be used until it is repaired.
+type State string
+
+const (
+	// Initializing means that a full scan of the folder is in progress
+	Initializing State = "initializing"
+	// Initialized means that the catalog contains all files of the folder
+	Initialized State = "initialized"
+	// Incomplete means that the folder contains changes that are not yet in the catalog
+	Incomplete State = "incomplete"
+	// Copying means that the new files of the import folder are being copied to the staging folder
+	Copying State = "copying"
+	// Copied means that all new files of the import folder were copied to the staging folder
+	Copied State = "copied"
+	// Done means that all files of the import folder are in the collection or were deleted from it
+	Done State = "done"
+	// Corrupted means that the catalog doesn't match the contents of the folder and must be repaired before use
+	Corrupted State = "corrupted"
+)
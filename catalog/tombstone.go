@@ -0,0 +1,56 @@
+package catalog
+
+import (
+	"sort"
+	"time"
+)
+
+// Tombstone stores what is known about the file behind a deleted checksum, so it can be told later when and why
+// a file was rejected from the collection. Tombstones of catalogs written before they were introduced are empty.
+type Tombstone struct {
+	// Paths contains the paths the file had in the folder when it was deleted, in alphabetical order
+	Paths []string `json:"paths"`
+	// Size is the size of the deleted file in bytes
+	Size int64 `json:"size"`
+	// DeletedAt is the time when the checksum was marked as deleted in RFC3339Nano format (RFC3339 with fractional
+	// seconds, e.g. "2020-01-02T15:04:05.123456789+01:00")
+	DeletedAt string `json:"deleted_at"`
+	// ImportFolder is the folder in the staging area where the file was copied by the import that brought it in
+	// (e.g. "3_photos"). Empty if the file was deleted directly from the collection.
+	ImportFolder string `json:"import_folder,omitempty"`
+}
+
+// newTombstone creates a Tombstone for the given items, all of them having the same checksum
+func newTombstone(items []Item) Tombstone {
+	t := Tombstone{DeletedAt: time.Now().Format(time.RFC3339Nano)}
+	t.addItems(items)
+	return t
+}
+
+// addItems adds the paths of the items to the tombstone if they are not in it yet
+func (t *Tombstone) addItems(items []Item) {
+	for _, item := range items {
+		t.Size = item.Size
+		if !t.hasPath(item.Path) {
+			t.Paths = append(t.Paths, item.Path)
+		}
+	}
+	sort.Strings(t.Paths)
+}
+
+func (t *Tombstone) hasPath(path string) bool {
+	for _, p := range t.Paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// clone creates a deep copy of the tombstone
+func (t Tombstone) clone() Tombstone {
+	if t.Paths != nil {
+		t.Paths = append([]string(nil), t.Paths...)
+	}
+	return t
+}
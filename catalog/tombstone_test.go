@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestDeletePathTombstone(t *testing.T) {
+	c := NewCatalog()
+	item := Item{Path: "some/file.txt", Size: 42, ModificationTime: "2018-10-24T23:38:47.713775685+01:00", Checksum: "1234"}
+	th.Ok(t, c.Add(item))
+	_, err := c.Tombstone("1234")
+	th.NokPrefix(t, err, "Checksum is not deleted: 1234")
+
+	c.DeletePath(item.Path)
+	tombstone, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, []string{"some/file.txt"}, tombstone.Paths)
+	th.Equals(t, int64(42), tombstone.Size)
+	th.Equals(t, "", tombstone.ImportFolder)
+	_, err = time.Parse(time.RFC3339Nano, tombstone.DeletedAt)
+	th.Ok(t, err)
+}
+
+func TestDeleteChecksumTombstone(t *testing.T) {
+	c := NewCatalog()
+	th.Ok(t, c.Add(Item{Path: "b", Size: 10, Checksum: "1234"}))
+	th.Ok(t, c.Add(Item{Path: "a", Size: 10, Checksum: "1234"}))
+	th.Ok(t, c.Add(Item{Path: "c", Size: 20, Checksum: "5678"}))
+
+	c.DeleteChecksum("1234")
+	tombstone, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, []string{"a", "b"}, tombstone.Paths)
+	th.Equals(t, int64(10), tombstone.Size)
+
+	// deleting again keeps the original tombstone and adds the new paths
+	th.Ok(t, c.Add(Item{Path: "d", Size: 10, Checksum: "1234"}))
+	c.DeleteChecksum("1234")
+	tombstone2, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, []string{"d"}, tombstone2.Paths)
+
+	c.DeleteChecksum("5678")
+	c.DeleteChecksum("5678")
+	tombstone3, err := c.Tombstone("5678")
+	th.Ok(t, err)
+	th.Equals(t, []string{"c"}, tombstone3.Paths)
+
+	// unknown checksum
+	c.DeleteChecksum("9999")
+	tombstone4, err := c.Tombstone("9999")
+	th.Ok(t, err)
+	th.Equals(t, []string(nil), tombstone4.Paths)
+	th.Equals(t, int64(0), tombstone4.Size)
+}
+
+func TestAddTombstone(t *testing.T) {
+	c := NewCatalog()
+	th.Ok(t, c.Add(Item{Path: "a", Size: 10, Checksum: "1234"}))
+	tombstone := Tombstone{Paths: []string{"1_folder/x"}, Size: 10, DeletedAt: "2019-01-02T03:04:05Z", ImportFolder: "1_folder"}
+	c.AddTombstone("1234", tombstone)
+	th.Equals(t, 0, c.Count())
+	th.Equals(t, true, c.IsDeletedChecksum("1234"))
+	stored, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, tombstone, stored)
+
+	// the stored tombstone is independent of the argument and the returned value
+	tombstone.Paths[0] = "changed"
+	stored.Paths[0] = "changed"
+	stored2, err := c.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, "1_folder/x", stored2.Paths[0])
+
+	c.UnDeleteChecksum("1234")
+	_, err = c.Tombstone("1234")
+	th.NokPrefix(t, err, "Checksum is not deleted")
+}
+
+func TestCloneTombstones(t *testing.T) {
+	c := NewCatalog()
+	th.Ok(t, c.Add(Item{Path: "a", Size: 10, Checksum: "1234"}))
+	c.DeleteChecksum("1234")
+	clone := c.Clone()
+	th.Ok(t, clone.Add(Item{Path: "b", Size: 10, Checksum: "5678"}))
+	th.Ok(t, c.Add(Item{Path: "c", Size: 10, Checksum: "1234"}))
+	th.Ok(t, clone.Add(Item{Path: "c", Size: 10, Checksum: "1234"}))
+	clone.DeleteChecksum("1234")
+	tombstone, err := clone.Tombstone("1234")
+	th.Ok(t, err)
+	th.Equals(t, []string{"c"}, tombstone.Paths)
+	th.Equals(t, false, c.IsDeletedChecksum("1234"))
+}
+
+func TestWriteReadTombstones(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := NewCatalog()
+	th.Ok(t, c.Add(Item{Path: "a", Size: 10, Checksum: "1234"}))
+	c.DeletePath("a")
+	c.AddTombstone("5678", Tombstone{Paths: []string{"2_photos/b"}, Size: 3, DeletedAt: "2019-01-02T03:04:05Z", ImportFolder: "2_photos"})
+	c.DeleteChecksum("9999")
+	th.Ok(t, c.Write(fs))
+	c2, err := Read(fs, CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, c, c2)
+}
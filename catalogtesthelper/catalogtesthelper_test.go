@@ -1,9 +1,11 @@
 package catalogtesthelper
 
 import (
+	"os"
 	"testing"
 
 	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
 )
 
 /////////////////////////////////////////////////////////////////////////////////////////
@@ -83,3 +85,20 @@ func TestNOkInt64Channel(t *testing.T) {
 	defer th.ExpectPanic(t, "closing element not found")
 	ReadInt64Channel(c)
 }
+
+/////////////////////////////////////////////////////////////////////////////////////////
+////// UnreadableFs
+func TestUnreadableFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	afero.WriteFile(base, "a/bad.txt", []byte("bad"), 0644)
+	afero.WriteFile(base, "a/good.txt", []byte("good"), 0644)
+	fs := NewUnreadableFs(base, "/a/bad.txt")
+
+	_, err := afero.ReadFile(fs, "a/bad.txt")
+	th.Equals(t, true, os.IsPermission(err))
+	_, err = fs.Stat("a/bad.txt")
+	th.Ok(t, err)
+	content, err := afero.ReadFile(fs, "a/good.txt")
+	th.Ok(t, err)
+	th.Equals(t, "good", string(content))
+}
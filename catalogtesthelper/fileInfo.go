@@ -0,0 +1,38 @@
+package catalogtesthelper
+
+import (
+	"os"
+	"time"
+)
+
+// FileInfo is an os.FileInfo with fixed values, so the code working with file metadata
+// can be tested without creating the files
+type FileInfo struct {
+	FileName    string
+	FileSize    int64
+	FileModTime time.Time
+	Dir         bool
+}
+
+// Name returns the base name of the file
+func (fi FileInfo) Name() string { return fi.FileName }
+
+// Size returns the size of the file in bytes
+func (fi FileInfo) Size() int64 { return fi.FileSize }
+
+// Mode returns the permissions of a usual file or folder
+func (fi FileInfo) Mode() os.FileMode {
+	if fi.Dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ModTime returns the modification time of the file
+func (fi FileInfo) ModTime() time.Time { return fi.FileModTime }
+
+// IsDir returns true if the FileInfo describes a folder
+func (fi FileInfo) IsDir() bool { return fi.Dir }
+
+// Sys returns nil
+func (fi FileInfo) Sys() interface{} { return nil }
@@ -0,0 +1,45 @@
+package catalogtesthelper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// UnreadableFs wraps a file system and fails to open the given files and folders, like a disk with bad sectors would.
+// Their metadata can still be read with Stat.
+type UnreadableFs struct {
+	afero.Fs
+	paths map[string]bool
+}
+
+// NewUnreadableFs creates an UnreadableFs that cannot open the given paths
+func NewUnreadableFs(fs afero.Fs, paths ...string) *UnreadableFs {
+	ret := &UnreadableFs{Fs: fs, paths: make(map[string]bool)}
+	for _, path := range paths {
+		ret.paths[cleanPath(path)] = true
+	}
+	return ret
+}
+
+func cleanPath(path string) string {
+	return strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator))
+}
+
+// Open opens the file, unless it is unreadable
+func (u *UnreadableFs) Open(name string) (afero.File, error) {
+	if u.paths[cleanPath(name)] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return u.Fs.Open(name)
+}
+
+// OpenFile opens the file, unless it is unreadable
+func (u *UnreadableFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if u.paths[cleanPath(name)] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return u.Fs.OpenFile(name, flag, perm)
+}
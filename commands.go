@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/hashcache"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// command is a subcommand of CoBack
+type command struct {
+	name string
+	// usage is the synopsis of the command after the name of the program
+	usage string
+	// run runs the command with the command line arguments following the name of the command
+	run func(ctx context.Context, args []string) error
+	// printError prints the error returned by run
+	printError func(err error)
+}
+
+// printPlainError prints the error as it is, it's used by the commands that don't need hints how to continue
+func printPlainError(err error) {
+	fmt.Println(err)
+}
+
+// commands are the subcommands of CoBack in the order they are listed in the usage
+var commands = []command{
+	{
+		name:  "import",
+		usage: "import [options] import-from-path staging-path collection-path",
+		run: func(ctx context.Context, args []string) error {
+			return importCommand(ctx, "import", args)
+		},
+		printError: printError,
+	},
+	{
+		name:  "stage",
+		usage: "stage [options] import-from-path staging-path collection-path",
+		run: func(ctx context.Context, args []string) error {
+			return importCommand(ctx, "stage", args)
+		},
+		printError: printError,
+	},
+	{name: "status", usage: "status [options] folder-path", run: statusCommand, printError: printPlainError},
+	{name: "scan", usage: "scan [options] folder-path", run: scanCommand, printError: printPlainError},
+	{name: "diff", usage: "diff [options] folder-path", run: diffCommand, printError: printPlainError},
+	{name: "verify", usage: "verify [options] folder-path", run: verifyCommand, printError: printVerifyError},
+	{name: "repair", usage: "repair [options] collection-path import-from-path...", run: repairCommand, printError: printPlainError},
+	{name: "undelete", usage: "undelete [options] collection-path [import-from-path...]", run: undeleteCommand, printError: printPlainError},
+	{name: "cache", usage: "cache [options]", run: cacheCommand, printError: printPlainError},
+}
+
+// findCommand returns the subcommand with the given name
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// isHelp returns true if the argument asks for the usage of CoBack
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// printUsage prints the synopsis of all subcommands
+func printUsage() {
+	fmt.Printf("Usage: %v %v\n", os.Args[0], commands[0].usage)
+	for _, cmd := range commands[1:] {
+		fmt.Printf("       %v %v\n", os.Args[0], cmd.usage)
+	}
+	fmt.Printf("Without a command the arguments are the same as the arguments of import.\n")
+	fmt.Printf("Run '%v command -h' to see the options of a command.\n", os.Args[0])
+}
+
+// isFlagSet returns true if the flag was given on the command line
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// readCatalog reads the catalog of a folder. If the catalog file is damaged and its backup is used instead, a warning
+// naming the backup is printed to the standard error, and the backup is returned.
+func readCatalog(fs afero.Fs) (catalog.Catalog, error) {
+	c, err := catalog.Read(fs, catalog.CatalogFileName)
+	if _, ok := err.(*catalog.BackupUsedError); ok {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return c, nil
+	}
+	return c, err
+}
+
+// openFolder returns a file system based in an existing folder.
+// Unlike initializeFolders it doesn't create the folder, returns error if it doesn't exist.
+func openFolder(baseFs afero.Fs, path string) (afero.Fs, error) {
+	if exists, _ := afero.DirExists(baseFs, path); !exists {
+		return nil, errors.Errorf("The folder '%v' doesn't exist", path)
+	}
+	return afero.NewBasePathFs(baseFs, path), nil
+}
+
+// syncFlags are the command line flags of the commands that sync the catalogs of folders
+type syncFlags struct {
+	hashName     *string
+	repair       *bool
+	verifyMoves  *bool
+	useHashCache *bool
+	progress     *string
+}
+
+// addSyncFlags registers the flags of syncing catalogs in the flag set
+func addSyncFlags(flags *flag.FlagSet) *syncFlags {
+	return &syncFlags{
+		hashName:     flags.String("hash", string(catalog.DefaultHashAlgorithm), "hash algorithm used if the collection has no catalog yet (md5, sha256 or blake3)"),
+		repair:       flags.Bool("repair", false, "rebuild the catalogs of the collection and staging folders if they are marked as corrupted"),
+		verifyMoves:  flags.Bool("verify-moves", false, "check the content of the files that seem to be moved in a folder since the last run"),
+		useHashCache: flags.Bool("hash-cache", false, "keep the checksums of the files in the user's cache directory, so unchanged files are not read again"),
+		progress:     flags.String("progress", "", "how to show the progress: bar, log, json or none (default bar on a terminal, otherwise log, json with -json)"),
+	}
+}
+
+// options returns the scan options selected by the flags. If the hash cache is used, it is opened and returned too,
+// the caller has to save it.
+func (f *syncFlags) options() ([]scan.Option, *hashcache.Cache, error) {
+	alg, err := catalog.ParseHashAlgorithm(*f.hashName)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []scan.Option{scan.WithHashAlgorithm(alg)}
+	if *f.repair {
+		opts = append(opts, scan.WithRepair())
+	}
+	if *f.verifyMoves {
+		opts = append(opts, scan.WithVerifiedMoves())
+	}
+	var cache *hashcache.Cache
+	if *f.useHashCache {
+		if cache, err = openHashCache(); err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, scan.WithHashCache(cache))
+	}
+	return opts, cache, nil
+}
+
+// progressInterval is how often the progress is written to the log or reported as an event
+const progressInterval = 5 * time.Second
+
+// isTerminal returns true if the file is a terminal, not a regular file or a pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressSink returns the ProgressSink selected by the -progress flag.
+// In JSON mode, when the reporter is not nil, the progress is reported as events by default, and the progress bars
+// and the log lines are written to the standard error, so the standard output only contains the events.
+func (f *syncFlags) progressSink(reporter scan.Reporter) (scan.ProgressSink, error) {
+	mode := *f.progress
+	var out io.Writer = os.Stdout
+	if reporter != nil {
+		out = os.Stderr
+		if mode == "" {
+			mode = "json"
+		}
+	}
+	if mode == "" {
+		mode = "log"
+		if isTerminal(os.Stdout) {
+			mode = "bar"
+		}
+	}
+	switch mode {
+	case "bar":
+		return scan.NewTerminalProgress(out), nil
+	case "log":
+		return scan.NewLogProgress(out, progressInterval), nil
+	case "json":
+		if reporter == nil {
+			reporter = scan.NewJSONReporter(out)
+		}
+		return scan.NewEventProgress(reporter, progressInterval), nil
+	case "none":
+		return scan.NoProgress(), nil
+	}
+	return nil, errors.Errorf("Unsupported progress mode: '%v'", mode)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"import", "stage", "status", "scan", "diff", "verify", "repair", "undelete", "cache"} {
+		cmd, found := findCommand(name)
+		th.Equals(t, true, found)
+		th.Equals(t, name, cmd.name)
+		th.Assert(t, cmd.run != nil && cmd.printError != nil, "command %v is incomplete", name)
+	}
+	_, found := findCommand("/path/of/folder-to-import")
+	th.Equals(t, false, found)
+}
+
+func TestOpenFolder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, fs.MkdirAll("photos", 0755))
+	th.Ok(t, afero.WriteFile(fs, "file.txt", []byte("content"), 0644))
+
+	folderFs, err := openFolder(fs, "photos")
+	th.Ok(t, err)
+	th.Ok(t, afero.WriteFile(folderFs, "a.jpg", []byte("content"), 0644))
+	expectFile(t, fs, "photos/a.jpg")
+
+	_, err = openFolder(fs, "no_such_folder")
+	th.NokPrefix(t, err, "The folder 'no_such_folder' doesn't exist")
+	_, err = openFolder(fs, "file.txt")
+	th.NokPrefix(t, err, "The folder 'file.txt' doesn't exist")
+}
+
+func TestImportCommandArguments(t *testing.T) {
+	err := importCommand(context.Background(), "import", []string{"import", "staging"})
+	th.NokPrefix(t, err, "The import, staging and collection folders must be specified")
+	err = importCommand(context.Background(), "stage", []string{"-hash", "crc32", "import", "staging", "collection"})
+	th.NokPrefix(t, err, "Unsupported hash algorithm: 'crc32'")
+	err = importCommand(context.Background(), "import", []string{"-staging-mode", "hardlink", "import", "staging", "collection"})
+	th.NokPrefix(t, err, "Hard links share the files of the import folder with the staging folder, use -hardlink")
+}
+
+func TestParseStagingMode(t *testing.T) {
+	mode, err := parseStagingMode("auto", false)
+	th.Ok(t, err)
+	th.Equals(t, fsh.AutoMode, mode)
+	mode, err = parseStagingMode("clone", false)
+	th.Ok(t, err)
+	th.Equals(t, fsh.ReflinkMode, mode)
+	mode, err = parseStagingMode("auto", true)
+	th.Ok(t, err)
+	th.Equals(t, fsh.HardlinkMode, mode)
+
+	_, err = parseStagingMode("hardlink", false)
+	th.NokPrefix(t, err, "Hard links share the files of the import folder")
+	_, err = parseStagingMode("copy", true)
+	th.NokPrefix(t, err, "-hardlink cannot be used with -staging-mode copy")
+	_, err = parseStagingMode("symlink", false)
+	th.NokPrefix(t, err, "Unsupported staging mode: 'symlink'")
+}
+
+func TestProgressSink(t *testing.T) {
+	parse := func(args ...string) *syncFlags {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addSyncFlags(flags)
+		th.Ok(t, flags.Parse(args))
+		return f
+	}
+	for mode, expected := range map[string]scan.ProgressSink{
+		"bar":  scan.NewTerminalProgress(os.Stdout),
+		"log":  scan.NewLogProgress(os.Stdout, progressInterval),
+		"none": scan.NoProgress(),
+	} {
+		sink, err := parse("-progress", mode).progressSink(nil)
+		th.Ok(t, err)
+		th.Equals(t, expected, sink)
+	}
+	_, err := parse("-progress", "fancy").progressSink(nil)
+	th.NokPrefix(t, err, "Unsupported progress mode: 'fancy'")
+
+	// in JSON mode the progress is reported as events by default, and nothing else is written to the standard output
+	reporter := scan.NewJSONReporter(os.Stdout)
+	sink, err := parse().progressSink(reporter)
+	th.Ok(t, err)
+	th.Equals(t, scan.NewEventProgress(reporter, progressInterval), sink)
+	sink, err = parse("-progress", "bar").progressSink(reporter)
+	th.Ok(t, err)
+	th.Equals(t, scan.NewTerminalProgress(os.Stderr), sink)
+}
+
+func TestPreviewRun(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFileCount(t, stagingFs, 0)
+	expectFileMissing(t, import1Fs, catalog.CatalogFileName)
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFolder1Contents(t, stagingFs, "1_folder1")
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFileCount(t, stagingFs, 7)
+	expectCatalogState(t, import1Fs, catalog.Copied)
+}
+
+// readCatalogFiles returns the content of the catalog files of the folders
+func readCatalogFiles(t *testing.T, folders ...afero.Fs) []string {
+	t.Helper()
+	var ret []string
+	for _, fs := range folders {
+		content, err := afero.ReadFile(fs, catalog.CatalogFileName)
+		th.Ok(t, err)
+		ret = append(ret, string(content))
+	}
+	return ret
+}
+
+func TestPreviewRunLeavesCatalogsUnchanged(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+	th.Ok(t, afero.WriteFile(import1Fs, "new.txt", []byte("new content"), 0644))
+	before := readCatalogFiles(t, import1Fs, stagingFs, collectionFs)
+
+	var r eventRecorder
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withReporter(&r)))
+	th.Equals(t, before, readCatalogFiles(t, import1Fs, stagingFs, collectionFs))
+	// the summary still counts the file deleted from the staging folder as deleted from the collection
+	th.Equals(t, 1, r.ofType(scan.RunFinished)[0].Summary.(runReport).Deleted.files)
+
+	// the real run moves the deleted file to the collection
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	importCatalog, err := catalog.Read(import1Fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	funny, err := importCatalog.Item("funny.png")
+	th.Ok(t, err)
+	collection, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Assert(t, collection.IsDeletedChecksum(funny.Checksum), "funny.png is not deleted from the collection")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// removeStaged is the reason a file can be removed from the import folder when a copy in the staging folder
+// was read and has the same checksum. The other reasons are skipInCollection and skipDeleted.
+const removeStaged = "staged"
+
+// removeEmptyFolder is the reason a folder of the import folder is removed: its files were all deleted
+const removeEmptyFolder = "empty_folder"
+
+// The status of a deletion in the audit log. An entry with auditDeleting status is written before the file is deleted,
+// then another one with auditDeleted or auditFailed status once it's known if the deletion succeeded.
+const (
+	auditDeleting = "deleting"
+	auditDeleted  = "deleted"
+	auditFailed   = "failed"
+)
+
+// auditEntry is a line of the audit log, it records a step of deleting a file or a folder from an import folder
+type auditEntry struct {
+	Time         time.Time        `json:"time"`
+	ImportFolder string           `json:"import_folder"`
+	Path         string           `json:"path"`
+	Size         int64            `json:"size"`
+	Checksum     catalog.Checksum `json:"checksum"`
+	// Reason is why the file could be deleted: staged, in_collection, deleted, or empty_folder for a folder
+	Reason string `json:"reason"`
+	// Copy is the verified copy of the file in the staging folder, if the reason is staged
+	Copy string `json:"copy,omitempty"`
+	// Status is deleting, deleted or failed
+	Status string `json:"status"`
+	// Error is why the deletion failed
+	Error string `json:"error,omitempty"`
+}
+
+// auditLog appends the entries to the audit log in the root of the staging folder, one JSON object per line
+type auditLog struct {
+	f   afero.File
+	enc *json.Encoder
+}
+
+// openAuditLog opens the audit log of the staging folder for appending, it's created if it doesn't exist yet
+func openAuditLog(stagingFs afero.Fs) (*auditLog, error) {
+	f, err := stagingFs.OpenFile(scan.AuditLogFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot open the audit log")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &auditLog{f, enc}, nil
+}
+
+func (l *auditLog) write(e auditEntry) error {
+	if err := l.enc.Encode(e); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *auditLog) Close() error {
+	return l.f.Close()
+}
+
+// remove deletes a file or a folder with the remove function, and records it in the audit log ahead of the deletion:
+// nothing is deleted unless the entry with deleting status is saved. The outcome is recorded after the deletion,
+// with the error if it failed.
+// Returns the error of the deletion and the error of writing the audit log separately.
+func (l *auditLog) remove(e auditEntry, remove func() error) (removeErr error, logErr error) {
+	e.Time = time.Now()
+	e.Status = auditDeleting
+	if err := l.write(e); err != nil {
+		return nil, errors.Wrapf(err, "Cannot write the audit log before deleting '%v'", e.Path)
+	}
+	removeErr = remove()
+	e.Time = time.Now()
+	e.Status = auditDeleted
+	if removeErr != nil {
+		e.Status = auditFailed
+		e.Error = removeErr.Error()
+		removeErr = errors.Wrapf(removeErr, "Failed to delete '%v'", e.Path)
+	}
+	if err := l.write(e); err != nil {
+		return removeErr, errors.Wrapf(err, "Cannot write the audit log after deleting '%v'", e.Path)
+	}
+	return removeErr, nil
+}
+
+// safeCopy checks if the file of the import folder can be deleted without losing anything.
+// The file is read again, it must be the same as in the import catalog. Then its checksum must be in the collection
+// with a copy that is not marked corrupted, or it must have been deleted from the collection before, or a file in the
+// staging folder with the same checksum is read and must have the same content. A hard link to the file of the import
+// folder, left by a run with hardlink staging mode, is not a copy.
+// Returns the reason the file can be deleted and the path of the verified copy in the staging folder, if it was used.
+func safeCopy(importFs afero.Fs, item catalog.Item, plan importPlan, stagingCatalog catalog.Catalog,
+	stagingFs afero.Fs) (reason string, copyPath string, err error) {
+	alg := plan.importCatalog.HashAlgorithm()
+	current, err := catalog.NewItemWithCache(importFs, item.Path, alg, nil)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "Cannot read the file")
+	}
+	if !current.SameContent(item) {
+		return "", "", errors.New("The file was modified since the import folder was scanned")
+	}
+
+	if plan.collectionCatalog.IsDeletedChecksum(item.Checksum) {
+		return skipDeleted, "", nil
+	}
+	inCollection, _ := plan.collectionCatalog.ItemsByChecksum(item.Checksum)
+	for _, c := range inCollection {
+		if !c.Corrupted {
+			return skipInCollection, "", nil
+		}
+	}
+	importInfo, err := importFs.Stat(item.Path)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "Cannot read the file")
+	}
+	staged, _ := stagingCatalog.ItemsByChecksum(item.Checksum)
+	for _, s := range staged {
+		if stagedInfo, err := stagingFs.Stat(s.Path); err != nil || os.SameFile(importInfo, stagedInfo) {
+			continue
+		}
+		stagedCopy, err := catalog.NewItemWithCache(stagingFs, s.Path, alg, nil)
+		if err == nil && stagedCopy.Checksum == item.Checksum && stagedCopy.Size == item.Size {
+			return removeStaged, s.Path, nil
+		}
+	}
+	return "", "", errors.New("No verified copy found in the staging folder or the collection")
+}
+
+// consumeImportFolder deletes the files of the import folder that are not needed anymore: the ones that have a copy
+// in the staging folder or in the collection, or were deleted from the collection before. Each file is checked by
+// safeCopy first, the files that fail the check are kept and reported with the reason.
+// Every file is recorded in the audit log of the staging folder before it's deleted, and its outcome after it (see
+// auditLog.remove), the deleted files are reported as FileRemoved events. If the audit log cannot be opened or written,
+// nothing more is deleted. The folders of the import folder that become empty are removed too, except the root.
+// The deleted files are removed from the import catalog, the caller has to save it.
+// Returns the number and the size of the deleted files, even if an error stopped the deletion.
+// Stops before the next file and returns the error of the context if it is cancelled.
+func consumeImportFolder(ctx context.Context, importFs afero.Fs, importName string, plan importPlan,
+	stagingCatalog catalog.Catalog, stagingFs afero.Fs, r scan.Reporter) (removed fileCount, err error) {
+	r.Report(scan.Event{Type: scan.PhaseStarted, Phase: "move", Message: "***************** Removing files from import folder ***************"})
+	defer func() {
+		e := scan.Event{Type: scan.PhaseFinished, Phase: "move", Files: removed.files}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		r.Report(e)
+	}()
+	audit, err := openAuditLog(stagingFs)
+	if err != nil {
+		return removed, err
+	}
+	defer audit.Close()
+
+	importCatalog := plan.importCatalog
+	var items []catalog.Item
+	for item := range importCatalog.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		items = append(items, item)
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+		reason, copyPath, err := safeCopy(importFs, item, plan, stagingCatalog, stagingFs)
+		if err != nil {
+			message(r, "Keeping %v: %v", item.Path, err)
+			continue
+		}
+		entry := auditEntry{
+			ImportFolder: importName,
+			Path:         item.Path,
+			Size:         item.Size,
+			Checksum:     item.Checksum,
+			Reason:       reason,
+			Copy:         copyPath,
+		}
+		removeErr, logErr := audit.remove(entry, func() error { return importFs.Remove(item.Path) })
+		if logErr != nil {
+			if _, err := importFs.Stat(item.Path); os.IsNotExist(err) {
+				// the file is deleted, only its outcome is missing from the audit log
+				importCatalog.ForgetPath(item.Path)
+				removed.add(item)
+			}
+			return removed, logErr
+		}
+		if removeErr != nil {
+			return removed, removeErr
+		}
+		importCatalog.ForgetPath(item.Path)
+		removed.add(item)
+		r.Report(scan.Event{Type: scan.FileRemoved, Phase: "move", Path: item.Path, Target: copyPath, Reason: reason,
+			Message: fmt.Sprintf("Removed %v from the import folder (%v)", item.Path, reason)})
+		if err := removeEmptyFolders(importFs, importName, path.Dir(item.Path), audit); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// removeEmptyFolders removes the folder if it's empty, then its parent folders that became empty, up to the root of
+// the file system, which is kept. Each folder is recorded in the audit log like the files.
+// Folders that cannot be removed are left in place, they hold nothing to lose. Returns an error if the audit log
+// cannot be written.
+func removeEmptyFolders(fs afero.Fs, importName string, folder string, audit *auditLog) error {
+	for folder != "." && folder != "/" {
+		if empty, err := afero.IsEmpty(fs, folder); err != nil || !empty {
+			return nil
+		}
+		entry := auditEntry{ImportFolder: importName, Path: folder, Reason: removeEmptyFolder}
+		removeErr, logErr := audit.remove(entry, func() error { return fs.Remove(folder) })
+		if logErr != nil {
+			return logErr
+		}
+		if removeErr != nil {
+			return nil
+		}
+		folder = path.Dir(folder)
+	}
+	return nil
+}
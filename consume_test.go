@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// readAuditLog reads the entries of the audit log of the staging folder
+func readAuditLog(t *testing.T, stagingFs afero.Fs) []auditEntry {
+	t.Helper()
+	f, err := stagingFs.Open(scan.AuditLogFileName)
+	th.Ok(t, err)
+	defer f.Close()
+	var ret []auditEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e auditEntry
+		th.Ok(t, json.Unmarshal(s.Bytes(), &e))
+		ret = append(ret, e)
+	}
+	th.Ok(t, s.Err())
+	return ret
+}
+
+// deletedFiles returns the entries of the audit log that record a deleted file, and checks that each of them was
+// recorded before it was deleted
+func deletedFiles(t *testing.T, entries []auditEntry) []auditEntry {
+	t.Helper()
+	var ret []auditEntry
+	deleting := map[string]bool{}
+	for _, e := range entries {
+		switch e.Status {
+		case auditDeleting:
+			deleting[e.Path] = true
+		case auditDeleted:
+			th.Assert(t, deleting[e.Path], "%v was deleted before it was recorded", e.Path)
+			if e.Reason != removeEmptyFolder {
+				ret = append(ret, e)
+			}
+		}
+	}
+	return ret
+}
+
+func TestRunMove(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	importCatalog, err := scan.Scan(context.Background(), import1Fs, scan.WithProgress(scan.NoProgress()))
+	th.Ok(t, err)
+
+	var r eventRecorder
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove(), withReporter(&r)))
+	expectFolder1Contents(t, stagingFs, "1_folder1")
+	expectFileCount(t, import1Fs, 0)
+	th.Equals(t, 7, len(r.ofType(scan.FileRemoved)))
+	c, err := catalog.Read(import1Fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 0, c.Count())
+
+	entries := deletedFiles(t, readAuditLog(t, stagingFs))
+	th.Equals(t, 7, len(entries))
+	for _, e := range entries {
+		item, err := importCatalog.Item(e.Path)
+		th.Ok(t, err)
+		th.Equals(t, "folder1", e.ImportFolder)
+		th.Equals(t, item.Checksum, e.Checksum)
+		th.Equals(t, item.Size, e.Size)
+		th.Equals(t, removeStaged, e.Reason)
+		th.Equals(t, "1_folder1/"+e.Path, e.Copy)
+	}
+	report := readRunReport(t, stagingFs, "1_folder1")
+	th.Equals(t, report.Staged, report.Removed)
+	// the emptied folders are removed too
+	files, err := afero.ReadDir(import1Fs, ".")
+	th.Ok(t, err)
+	for _, f := range files {
+		th.Assert(t, !f.IsDir(), "the empty folder %v is left behind", f.Name())
+	}
+	folders := map[string]string{}
+	for _, e := range readAuditLog(t, stagingFs) {
+		if e.Reason == removeEmptyFolder {
+			folders[e.Path] = e.Status
+		}
+	}
+	th.Equals(t, map[string]string{"family": auditDeleted, "friends": auditDeleted}, folders)
+
+	// the audit log is not part of the staging folder
+	stagingCatalog, err := catalog.Read(stagingFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 7, stagingCatalog.Count())
+}
+
+func TestRunMoveSkippedFiles(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFolder1Contents(t, import1Fs, ".")
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove()))
+	expectFileCount(t, import1Fs, 0)
+	reasons := map[string]string{}
+	for _, e := range deletedFiles(t, readAuditLog(t, stagingFs)) {
+		reasons[e.Path] = e.Reason
+	}
+	th.Equals(t, 7, len(reasons))
+	th.Equals(t, skipInCollection, reasons["family/mom.jpg"])
+	th.Equals(t, removeStaged, reasons["friends/kara.jpg"])
+	th.Equals(t, skipDeleted, reasons["funny.png"])
+	c, err := catalog.Read(import1Fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 0, c.Count())
+	// the friends folder is still waiting in the staging folder
+	th.Equals(t, catalog.Copied, c.State())
+}
+
+func TestRunMoveKeepsFoldersOfKeptFiles(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	filter, err := scan.GlobFilter("friends/kara.jpg")
+	th.Ok(t, err)
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove(),
+		withScanOptions(scan.WithFilter(scan.Not(filter)))))
+	expectFileCount(t, import1Fs, 1)
+	expectFileMissing(t, import1Fs, "family")
+	_, err = import1Fs.Stat("friends/kara.jpg")
+	th.Ok(t, err)
+}
+
+func TestRunMoveHardlinkMode(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove(), withStagingMode(fsh.HardlinkMode))
+	th.NokPrefix(t, err, "Files cannot be removed from the import folder if they are staged as hard links")
+	expectFolder1Contents(t, import1Fs, ".")
+	expectFileCount(t, stagingFs, 0)
+
+	// hard links staged by an earlier run are not copies either
+	dir, err := ioutil.TempDir("", "coback")
+	th.Ok(t, err)
+	defer os.RemoveAll(dir)
+	osFs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	th.Ok(t, copyTestData("folder1", osFs))
+	import1Fs, stagingFs, collectionFs, err = initializeFolders(osFs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withStagingMode(fsh.HardlinkMode)))
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove()))
+	expectFolder1Contents(t, import1Fs, ".")
+	th.Equals(t, 0, len(readAuditLog(t, stagingFs)))
+}
+
+// failingAuditFs is a file system where the audit log cannot be written
+type failingAuditFs struct {
+	afero.Fs
+}
+
+func (fs failingAuditFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil || name != scan.AuditLogFileName {
+		return f, err
+	}
+	return failingFile{f}, nil
+}
+
+type failingFile struct {
+	afero.File
+}
+
+func (failingFile) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestConsumeImportFolderAuditFails(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	plan, err := planImport(context.Background(), import1Fs, "folder1", stagingFs, collectionFs,
+		newRunOptions([]runOption{withProgress(scan.NoProgress())}))
+	th.Ok(t, err)
+
+	var r eventRecorder
+	removed, err := consumeImportFolder(context.Background(), import1Fs, "folder1", plan, plan.stagingCatalog,
+		failingAuditFs{stagingFs}, &r)
+	th.NokPrefix(t, err, "Cannot write the audit log before deleting")
+	th.Equals(t, fileCount{}, removed)
+	expectFolder1Contents(t, import1Fs, ".")
+	th.Equals(t, 0, len(r.ofType(scan.FileRemoved)))
+}
+
+func TestRunMoveDryRun(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withMove()))
+	expectFolder1Contents(t, import1Fs, ".")
+	expectFileMissing(t, stagingFs, scan.AuditLogFileName)
+}
+
+func TestSafeCopy(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	plan, err := planImport(context.Background(), import1Fs, "folder1", stagingFs, collectionFs,
+		newRunOptions([]runOption{withDryRun(), withProgress(scan.NoProgress())}))
+	th.Ok(t, err)
+	item := func(path string) catalog.Item {
+		ret, err := plan.importCatalog.Item(path)
+		th.Ok(t, err)
+		return ret
+	}
+
+	reason, copyPath, err := safeCopy(import1Fs, item("friends/kara.jpg"), plan, plan.stagingCatalog, stagingFs)
+	th.Ok(t, err)
+	th.Equals(t, removeStaged, reason)
+	th.Equals(t, "1_folder1/friends/kara.jpg", copyPath)
+	reason, copyPath, err = safeCopy(import1Fs, item("family/mom.jpg"), plan, plan.stagingCatalog, stagingFs)
+	th.Ok(t, err)
+	th.Equals(t, skipInCollection, reason)
+	th.Equals(t, "", copyPath)
+
+	// the staged copy changed since the staging folder was scanned
+	th.Ok(t, afero.WriteFile(stagingFs, "1_folder1/friends/kara.jpg", []byte("not kara"), 0644))
+	_, _, err = safeCopy(import1Fs, item("friends/kara.jpg"), plan, plan.stagingCatalog, stagingFs)
+	th.NokPrefix(t, err, "No verified copy found")
+
+	// the only copy in the collection is corrupted
+	inCollection, err := plan.collectionCatalog.Item("family/mom.jpg")
+	th.Ok(t, err)
+	inCollection.Corrupted = true
+	th.Ok(t, plan.collectionCatalog.Set(inCollection))
+	_, _, err = safeCopy(import1Fs, item("family/mom.jpg"), plan, plan.stagingCatalog, stagingFs)
+	th.NokPrefix(t, err, "No verified copy found")
+
+	// the file of the import folder changed since it was scanned
+	th.Ok(t, afero.WriteFile(import1Fs, "friends/conor.jpg", []byte("not conor"), 0644))
+	_, _, err = safeCopy(import1Fs, item("friends/conor.jpg"), plan, plan.stagingCatalog, stagingFs)
+	th.NokPrefix(t, err, "The file was modified")
+	_, _, err = safeCopy(import1Fs, catalog.Item{Path: "no_such_file.jpg"}, plan, plan.stagingCatalog, stagingFs)
+	th.NokPrefix(t, err, "Cannot read the file")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// sortedKeys returns the paths of the set in alphabetical order
+func sortedKeys(paths map[string]bool) []string {
+	ret := make([]string, 0, len(paths))
+	for path := range paths {
+		ret = append(ret, path)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// printDiff prints the changes of a folder in a human readable form
+func printDiff(diff scan.FileSystemDiff) {
+	printFileList("Added:", sortedKeys(diff.Add))
+	printFileList("Modified:", sortedKeys(diff.Update))
+	printFileList("Removed:", sortedKeys(diff.Delete))
+	moved := make([]string, 0, len(diff.Moved))
+	for newPath, oldPath := range diff.Moved {
+		moved = append(moved, fmt.Sprintf("%v --> %v", oldPath, newPath))
+	}
+	sort.Strings(moved)
+	printFileList("Moved:", moved)
+	printFileList("Ignored:", sortedKeys(diff.Ignored))
+	fmt.Printf("%v added, %v modified, %v removed, %v moved, %v ignored\n",
+		len(diff.Add), len(diff.Update), len(diff.Delete), len(diff.Moved), len(diff.Ignored))
+}
+
+// diffFolder compares the folder to its catalog without changing anything.
+// If deepCheck is true, the checksums of the files are compared too.
+func diffFolder(ctx context.Context, fs afero.Fs, deepCheck bool, opts ...scan.Option) (scan.FileSystemDiff, error) {
+	c, err := readCatalog(fs)
+	if err != nil {
+		return scan.FileSystemDiff{}, errors.Wrapf(err, "Cannot read the catalog of the folder")
+	}
+	return scan.DiffFiltered(ctx, fs, c, scan.IgnoreFilter(fs), deepCheck, opts...)
+}
+
+// diffCommand runs the diff subcommand with the given command line arguments (without the subcommand itself)
+func diffCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	deep := flags.Bool("deep", false, "read the files and compare their checksums too, not only their size and modification time")
+	useHashCache := flags.Bool("hash-cache", false, "with -deep, use the checksums in the user's cache directory for the files that haven't changed")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v diff [options] folder-path\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The folder must be specified")
+	}
+	fs, err := openFolder(afero.NewOsFs(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var opts []scan.Option
+	if *useHashCache {
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer saveHashCache(cache)
+		opts = append(opts, scan.WithHashCache(cache))
+	}
+	diff, err := diffFolder(ctx, fs, *deep, opts...)
+	if err != nil {
+		return err
+	}
+	printDiff(diff)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestDiffFolder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "c.txt", []byte("content c"), 0644))
+	_, err := diffFolder(context.Background(), fs, false)
+	th.NokPrefix(t, err, "Cannot read the catalog of the folder")
+	_, err = scanFolderCatalog(context.Background(), fs, false)
+	th.Ok(t, err)
+
+	th.Ok(t, afero.WriteFile(fs, "new.txt", []byte("new content"), 0644))
+	th.Ok(t, fs.Remove("b.txt"))
+	th.Ok(t, fs.Rename("c.txt", "moved.txt"))
+	rotFile(t, fs, "a.txt", "content x")
+
+	diff, err := diffFolder(context.Background(), fs, false)
+	th.Ok(t, err)
+	th.Equals(t, map[string]bool{"new.txt": true}, diff.Add)
+	th.Equals(t, map[string]bool{"b.txt": true}, diff.Delete)
+	th.Equals(t, map[string]string{"moved.txt": "c.txt"}, diff.Moved)
+	th.Equals(t, 0, len(diff.Update))
+
+	diff, err = diffFolder(context.Background(), fs, true)
+	th.Ok(t, err)
+	th.Equals(t, map[string]bool{"a.txt": true}, diff.Update)
+	printDiff(diff)
+
+	// nothing is changed by the diff
+	diff, err = diffFolder(context.Background(), fs, false)
+	th.Ok(t, err)
+	th.Equals(t, map[string]bool{"new.txt": true}, diff.Add)
+}
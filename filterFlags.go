@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+)
+
+// stringList is a flag that can be given multiple times, all values are kept
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// filterFlags are the command line flags that select the files to import
+type filterFlags struct {
+	minSize        *string
+	maxSize        *string
+	modifiedAfter  *string
+	modifiedBefore *string
+	match          *string
+	include        stringList
+	exclude        stringList
+}
+
+// addFilterFlags registers the file filter flags in the flag set
+func addFilterFlags(flags *flag.FlagSet) *filterFlags {
+	f := &filterFlags{
+		minSize:        flags.String("min-size", "", "only import files at least this big (e.g. 50KB, 2.5MB)"),
+		maxSize:        flags.String("max-size", "", "only import files at most this big (e.g. 4GB)"),
+		modifiedAfter:  flags.String("modified-after", "", "only import files modified on or after this date (YYYY-MM-DD)"),
+		modifiedBefore: flags.String("modified-before", "", "only import files modified before this date (YYYY-MM-DD)"),
+		match:          flags.String("match", "", "only import files whose path matches this regular expression"),
+	}
+	flags.Var(&f.include, "include", "only import files whose path matches this pattern (e.g. 'DCIM/**/*.jpg'), can be repeated")
+	flags.Var(&f.exclude, "exclude", "don't import files whose path matches this pattern (e.g. '**/*.tmp'), can be repeated")
+	return f
+}
+
+// sizeUnits are the multipliers of the size suffixes, longer suffixes first so they are matched before their endings
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a file size given on the command line. The size is in bytes, unless it has a
+// KB, MB, GB or TB suffix (powers of 1024, case insensitive).
+func parseSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, errors.Errorf("Invalid size: '%v'", value)
+	}
+	return int64(size * multiplier), nil
+}
+
+// formatSize returns the size in a human readable form, with the largest unit parseSize accepts that keeps the
+// number at least 1
+func formatSize(size int64) string {
+	if size < 1<<10 {
+		return fmt.Sprintf("%v B", size)
+	}
+	value := float64(size)
+	unit := "B"
+	for _, u := range []string{"KB", "MB", "GB", "TB"} {
+		if value < 1<<10 {
+			break
+		}
+		value /= 1 << 10
+		unit = u
+	}
+	return fmt.Sprintf("%.1f %v", value, unit)
+}
+
+// filter builds the filter selected by the flags. All conditions must be met by an imported file.
+// Returns nil if no filter flag was given.
+func (f *filterFlags) filter() (scan.FileFilter, error) {
+	var filters []scan.FileFilter
+	if *f.minSize != "" {
+		size, err := parseSize(*f.minSize)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, scan.MinSizeFilter(size))
+	}
+	if *f.maxSize != "" {
+		size, err := parseSize(*f.maxSize)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, scan.MaxSizeFilter(size))
+	}
+	if *f.modifiedAfter != "" {
+		t, err := parseDate(*f.modifiedAfter)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, scan.ModifiedAfterFilter(t))
+	}
+	if *f.modifiedBefore != "" {
+		t, err := parseDate(*f.modifiedBefore)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, scan.ModifiedBeforeFilter(t))
+	}
+	if *f.match != "" {
+		filter, err := scan.RegexFilter(*f.match)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if len(f.include) > 0 {
+		filter, err := scan.GlobFilter(f.include...)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if len(f.exclude) > 0 {
+		filter, err := scan.GlobFilter(f.exclude...)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, scan.Not(filter))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return scan.And(filters...), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	cth "github.com/mitro42/coback/catalogtesthelper"
+	th "github.com/mitro42/testhelper"
+)
+
+func TestParseSize(t *testing.T) {
+	size, err := parseSize("1500")
+	th.Ok(t, err)
+	th.Equals(t, int64(1500), size)
+	size, err = parseSize("50KB")
+	th.Ok(t, err)
+	th.Equals(t, int64(50*1024), size)
+	size, err = parseSize("2.5 mb")
+	th.Ok(t, err)
+	th.Equals(t, int64(2.5*1024*1024), size)
+	size, err = parseSize("4G")
+	th.Ok(t, err)
+	th.Equals(t, int64(4*1024*1024*1024), size)
+	size, err = parseSize("10B")
+	th.Ok(t, err)
+	th.Equals(t, int64(10), size)
+
+	_, err = parseSize("")
+	th.NokPrefix(t, err, "Invalid size: ''")
+	_, err = parseSize("-1KB")
+	th.NokPrefix(t, err, "Invalid size: '-1KB'")
+	_, err = parseSize("many")
+	th.NokPrefix(t, err, "Invalid size: 'many'")
+}
+
+func TestFormatSize(t *testing.T) {
+	th.Equals(t, "0 B", formatSize(0))
+	th.Equals(t, "1023 B", formatSize(1023))
+	th.Equals(t, "1.0 KB", formatSize(1024))
+	th.Equals(t, "2.5 MB", formatSize(5*1024*1024/2))
+	th.Equals(t, "300.0 GB", formatSize(300*1024*1024*1024))
+	th.Equals(t, "2048.0 TB", formatSize(2048*1024*1024*1024*1024))
+}
+
+func parseFilterFlags(t *testing.T, args ...string) *filterFlags {
+	t.Helper()
+	flags := flag.NewFlagSet("coback", flag.ContinueOnError)
+	f := addFilterFlags(flags)
+	th.Ok(t, flags.Parse(args))
+	return f
+}
+
+func TestFilterFlagsNone(t *testing.T) {
+	filter, err := parseFilterFlags(t).filter()
+	th.Ok(t, err)
+	th.Equals(t, nil, filter)
+}
+
+func TestFilterFlags(t *testing.T) {
+	f := parseFilterFlags(t, "-min-size", "1KB", "-max-size", "1MB", "-modified-after", "2018-01-01",
+		"-modified-before", "2019-01-01", "-include", "DCIM/**", "-include", "Camera/*", "-exclude", "**/*.thm")
+	filter, err := f.filter()
+	th.Ok(t, err)
+
+	fi := cth.FileInfo{FileSize: 2048, FileModTime: time.Date(2018, 6, 1, 12, 0, 0, 0, time.Local)}
+	th.Equals(t, true, filter.Include("DCIM/100CANON/a.jpg", fi))
+	th.Equals(t, true, filter.Include("Camera/a.jpg", fi))
+	th.Equals(t, false, filter.Include("DCIM/100CANON/a.thm", fi))
+	th.Equals(t, false, filter.Include("Other/a.jpg", fi))
+	th.Equals(t, false, filter.Include("DCIM/a.jpg", cth.FileInfo{FileSize: 10, FileModTime: fi.FileModTime}))
+	th.Equals(t, false, filter.Include("DCIM/a.jpg", cth.FileInfo{FileSize: 2 * 1024 * 1024, FileModTime: fi.FileModTime}))
+	th.Equals(t, false, filter.Include("DCIM/a.jpg", cth.FileInfo{FileSize: 2048, FileModTime: time.Date(2017, 6, 1, 0, 0, 0, 0, time.Local)}))
+	th.Equals(t, false, filter.Include("DCIM/a.jpg", cth.FileInfo{FileSize: 2048, FileModTime: time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local)}))
+}
+
+func TestFilterFlagsMatch(t *testing.T) {
+	filter, err := parseFilterFlags(t, "-match", `(?i)\.(jpe?g|cr2)$`).filter()
+	th.Ok(t, err)
+	th.Equals(t, true, filter.Include("a/b.JPG", cth.FileInfo{}))
+	th.Equals(t, true, filter.Include("b.cr2", cth.FileInfo{}))
+	th.Equals(t, false, filter.Include("b.png", cth.FileInfo{}))
+}
+
+func TestFilterFlagsInvalid(t *testing.T) {
+	_, err := parseFilterFlags(t, "-min-size", "big").filter()
+	th.NokPrefix(t, err, "Invalid size: 'big'")
+	_, err = parseFilterFlags(t, "-max-size", "1XB").filter()
+	th.NokPrefix(t, err, "Invalid size: '1XB'")
+	_, err = parseFilterFlags(t, "-modified-after", "yesterday").filter()
+	th.NokPrefix(t, err, "Invalid date: 'yesterday'")
+	_, err = parseFilterFlags(t, "-modified-before", "2018-13-01").filter()
+	th.NokPrefix(t, err, "Invalid date: '2018-13-01'")
+	_, err = parseFilterFlags(t, "-match", "(").filter()
+	th.NokPrefix(t, err, "Invalid regular expression: '('")
+	_, err = parseFilterFlags(t, "-include", "[a-").filter()
+	th.NokPrefix(t, err, "Invalid pattern: '[a-'")
+	_, err = parseFilterFlags(t, "-exclude", "[a-").filter()
+	th.NokPrefix(t, err, "Invalid pattern: '[a-'")
+}
@@ -1,8 +1,11 @@
 package fshelper
 
 import (
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
@@ -32,36 +35,154 @@ func EnsureDirectoryExist(fs afero.Fs, path string) error {
 	return nil
 }
 
-// copyFileContent copies the content (and only the content) of a file between file systems. The containing directories are automatically
-// created as necessary, the path relative to the root of the FS will be the same in the destination FS as it was in the source FS.
-//  Metadata of the file is not copied.
-func copyFileContent(sourceFs afero.Fs, sourcePath string, destinationFs afero.Fs, destinationPath string) (int64, error) {
+// PartialCopySuffix is appended to the name of a file while it's being copied. The copy is only renamed to its final
+// name once its content is verified, so an interrupted or failed copy never looks like a complete file.
+const PartialCopySuffix = ".coback-partial"
+
+// IsPartialCopy returns true if the name is the name of a file that is being copied, or was left behind by
+// an interrupted copy
+func IsPartialCopy(name string) bool {
+	return strings.HasSuffix(name, PartialCopySuffix)
+}
+
+// partialCopyPath returns the path the file is copied to before it's renamed to the destination path
+func partialCopyPath(destinationPath string) string {
+	folder, name := path.Split(destinationPath)
+	return path.Join(folder, "."+name+PartialCopySuffix)
+}
+
+// ExpectedContent is the content a copy must have to be accepted
+type ExpectedContent struct {
+	Size int64
+	// NewHash creates the hash function the digest was calculated with. If it's nil, only the size is checked.
+	NewHash func() (hash.Hash, error)
+	// Digest is the hex encoded checksum of the content
+	Digest string
+}
+
+// check returns an error if the size or the hash of the content written by an operation (copy, clone or link) is not
+// the expected one. The hash is nil if only the size is checked.
+func (e ExpectedContent) check(operation string, size int64, h hash.Hash) error {
+	if size != e.Size {
+		return errors.Errorf("Incorrect file size after %v: %v bytes instead of %v", operation, size, e.Size)
+	}
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != e.Digest {
+		return errors.Errorf("Incorrect checksum after %v: %v instead of %v", operation, hex.EncodeToString(h.Sum(nil)), e.Digest)
+	}
+	return nil
+}
+
+// verifyFile reads a file that was created by the operation (clone or link) and checks that it has the expected content
+func verifyFile(fs afero.Fs, name string, operation string, expected ExpectedContent) error {
+	var h hash.Hash
+	w := ioutil.Discard
+	if expected.NewHash != nil {
+		var err error
+		if h, err = expected.NewHash(); err != nil {
+			return err
+		}
+		w = h
+	}
+	f, err := fs.Open(name)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read file '%v' after %v", name, operation)
+	}
+	defer f.Close()
+	size, err := io.Copy(w, f)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot read file '%v' after %v", name, operation)
+	}
+	return expected.check(operation, size, h)
+}
+
+// CopyAttempts is the number of times a file is read again if its copy is incomplete or has a different checksum
+const CopyAttempts = 3
+
+// copyRetryDelay is the time to wait before a failed copy is attempted again, a flaky device may recover in the meantime
+var copyRetryDelay = time.Second
+
+// CopyError is returned when a file could not be copied correctly, it contains the error of each attempt
+type CopyError struct {
+	Path     string
+	Attempts []error
+}
+
+func (e *CopyError) Error() string {
+	if len(e.Attempts) == 1 {
+		return fmt.Sprintf("Failed to copy file '%v': %v", e.Path, e.Attempts[0])
+	}
+	attempts := make([]string, len(e.Attempts))
+	for i, err := range e.Attempts {
+		attempts[i] = fmt.Sprintf("attempt %v: %v", i+1, err)
+	}
+	return fmt.Sprintf("Failed to copy file '%v' in %v attempts: %v", e.Path, len(e.Attempts), strings.Join(attempts, "; "))
+}
+
+// copyFileContent copies the content (and only the content) of a file between file systems to a partial copy next to
+// the destination path, and checks that it has the expected content. The data is hashed while it's copied, the file is
+// not read again, but it's synced to the disk. Metadata of the file is not copied.
+// Returns the path of the partial copy, and whether the copy should be attempted again if it failed. The partial copy
+// is removed if the copy failed.
+func copyFileContent(sourceFs afero.Fs, sourcePath string, destinationFs afero.Fs, destinationPath string, expected ExpectedContent) (tempPath string, retry bool, err error) {
 	sourceFile, err := sourceFs.Open(sourcePath)
 	if err != nil {
-		return 0, err
+		return "", !os.IsNotExist(err), err
 	}
 	defer sourceFile.Close()
 
-	err = EnsureDirectoryExist(destinationFs, path.Dir(destinationPath))
-	if err != nil {
-		return 0, err
+	var w io.Writer
+	var h hash.Hash
+	if expected.NewHash != nil {
+		if h, err = expected.NewHash(); err != nil {
+			return "", false, err
+		}
 	}
-	destinationFile, err := destinationFs.Create(destinationPath)
+	tempPath = partialCopyPath(destinationPath)
+	destinationFile, err := destinationFs.Create(tempPath)
 	if err != nil {
-		return 0, errors.Wrapf(err, "Cannot create destination file '%v'", destinationPath)
+		return "", false, errors.Wrapf(err, "Cannot create destination file '%v'", destinationPath)
 	}
-	defer destinationFile.Close()
-	return io.Copy(destinationFile, sourceFile)
+	w = destinationFile
+	if h != nil {
+		w = io.MultiWriter(destinationFile, h)
+	}
+	size, err := io.Copy(w, sourceFile)
+	if err == nil {
+		// the copy must be on the disk before it's renamed to its final name
+		err = destinationFile.Sync()
+		if err != nil {
+			destinationFile.Close()
+			destinationFs.Remove(tempPath)
+			return "", false, errors.Wrapf(err, "Cannot write destination file '%v'", destinationPath)
+		}
+	}
+	if closeErr := destinationFile.Close(); err == nil && closeErr != nil {
+		destinationFs.Remove(tempPath)
+		return "", false, errors.Wrapf(closeErr, "Cannot write destination file '%v'", destinationPath)
+	}
+	if err == nil {
+		err = expected.check("copy", size, h)
+		if err == nil {
+			return tempPath, false, nil
+		}
+	}
+	destinationFs.Remove(tempPath)
+	return "", true, err
+}
+
+// parseTimestamp parses the modification time of a file in RFC3339Nano format
+func parseTimestamp(path, timestamp string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	return t, errors.Wrapf(err, "Cannot parse modification time of file '%v' ('%v')", path, timestamp)
 }
 
 // SetFileAttributes sets the modification and access times of a file in a file system
 func SetFileAttributes(fs afero.Fs, path, timestamp string) error {
-	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	t, err := parseTimestamp(path, timestamp)
 	if err != nil {
-		return errors.Wrapf(err, "Cannot parse modification time of file '%v' ('%v')", path, timestamp)
+		return err
 	}
-	fs.Chtimes(path, t, t)
-	return nil
+	return errors.Wrapf(fs.Chtimes(path, t, t), "Cannot set modification time of file '%v'", path)
 }
 
 // CopyFile copies a file between two file systems.
@@ -82,21 +203,52 @@ func CopyFileToFolder(sourceFs afero.Fs, sourcePath string, timestamp string, de
 
 // CopyFileTo copies a file between two file systems to a specified path that contains the file name too.
 // The destination path be different from the source file's path
-// The access and modification time stamps are set to the time specified in the item struct.
+// The access and modification time stamps are set to the timestamp specified in RFC3339Nano format.
+// The copy must have the size the source file had before the copy, see CopyFileVerified to check its checksum too.
 func CopyFileTo(sourceFs afero.Fs, sourcePath string, timestamp string, destinationFs afero.Fs, destinationPath string) error {
-	size, err := copyFileContent(sourceFs, sourcePath, destinationFs, destinationPath)
+	fiSource, err := sourceFs.Stat(sourcePath)
+	if err != nil {
+		return &CopyError{Path: sourcePath, Attempts: []error{err}}
+	}
+	return CopyFileVerified(sourceFs, sourcePath, timestamp, destinationFs, destinationPath, ExpectedContent{Size: fiSource.Size()})
+}
 
+// CopyFileVerified copies a file between two file systems to a specified path and checks that the copy has the
+// expected content. The file is copied to a partial copy next to the destination path first (see PartialCopySuffix),
+// and it's only renamed to the destination path, replacing the file there, once its size and checksum are verified
+// and its time stamps are set. If the content is not the expected one or the source cannot be read, the copy is
+// attempted again, up to CopyAttempts times. Returns a CopyError with the error of each attempt if all of them failed.
+// The access and modification time stamps are set to the timestamp specified in RFC3339Nano format.
+func CopyFileVerified(sourceFs afero.Fs, sourcePath string, timestamp string, destinationFs afero.Fs, destinationPath string, expected ExpectedContent) error {
+	t, err := parseTimestamp(destinationPath, timestamp)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to set file attributes '%v'", destinationPath)
+	}
+	err = EnsureDirectoryExist(destinationFs, path.Dir(destinationPath))
 	if err != nil {
 		return errors.Wrapf(err, "Failed to copy file '%v'", sourcePath)
 	}
 
-	fiSource, err := sourceFs.Stat(sourcePath)
-	if err != nil || fiSource.Size() != size {
-		return errors.Wrapf(err, "Incorrect file size after copy '%v'", sourcePath)
+	copyErr := &CopyError{Path: sourcePath}
+	for {
+		tempPath, retry, err := copyFileContent(sourceFs, sourcePath, destinationFs, destinationPath, expected)
+		if err == nil {
+			if err := destinationFs.Chtimes(tempPath, t, t); err != nil {
+				destinationFs.Remove(tempPath)
+				return errors.Wrapf(err, "Failed to set file attributes '%v'", destinationPath)
+			}
+			if err := destinationFs.Rename(tempPath, destinationPath); err != nil {
+				destinationFs.Remove(tempPath)
+				return errors.Wrapf(err, "Failed to copy file '%v'", sourcePath)
+			}
+			return nil
+		}
+		copyErr.Attempts = append(copyErr.Attempts, err)
+		if !retry || len(copyErr.Attempts) == CopyAttempts {
+			return copyErr
+		}
+		time.Sleep(copyRetryDelay)
 	}
-
-	err = SetFileAttributes(destinationFs, destinationPath, timestamp)
-	return errors.Wrapf(err, "Failed to set file attributes '%v'", destinationPath)
 }
 
 // NextUnusedFolder returns a smallest positive integer as a string that can be used as the prefix of the
@@ -120,6 +272,31 @@ func NextUnusedFolder(fs afero.Fs) string {
 	}
 }
 
+// LastUsedFolder returns the name of the folder in the root of the FS that has the largest numeric prefix
+// (as created by NextUnusedFolder) and ends with "_" followed by the specified suffix.
+// Returns false if there is no such folder.
+func LastUsedFolder(fs afero.Fs, suffix string) (string, bool) {
+	listing, _ := afero.ReadDir(fs, ".")
+	last := ""
+	lastPrefix := 0
+	for _, fi := range listing {
+		if !fi.IsDir() {
+			continue
+		}
+		parts := strings.SplitN(fi.Name(), "_", 2)
+		if len(parts) != 2 || parts[1] != suffix {
+			continue
+		}
+		prefix, err := strconv.Atoi(parts[0])
+		if err != nil || prefix <= lastPrefix {
+			continue
+		}
+		last = fi.Name()
+		lastPrefix = prefix
+	}
+	return last, last != ""
+}
+
 // CreateSafeFs creates a temporary memory file system layer on top of a real folder in the OS file system.
 // The returned fs can be safely modified, its changes won't change to OS file system.
 func CreateSafeFs(basePath string) afero.Fs {
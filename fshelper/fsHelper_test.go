@@ -7,9 +7,11 @@ import (
 	"path"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/mitro42/coback/catalog"
 	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
@@ -47,6 +49,28 @@ func TestNextUnusedFolder(t *testing.T) {
 	}
 }
 
+func TestLastUsedFolder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, found := LastUsedFolder(fs, "photos")
+	th.Equals(t, false, found)
+	fs.MkdirAll("1_photos", 0755)
+	fs.MkdirAll("2_other", 0755)
+	folder, found := LastUsedFolder(fs, "photos")
+	th.Equals(t, true, found)
+	th.Equals(t, "1_photos", folder)
+	fs.MkdirAll("10_photos", 0755)
+	fs.MkdirAll("9_photos", 0755)
+	fs.MkdirAll("11_photos_2", 0755)
+	fs.MkdirAll("x_photos", 0755)
+	f, err := fs.Create("12_photos")
+	th.Ok(t, err)
+	f.Close()
+	folder, found = LastUsedFolder(fs, "photos")
+	th.Equals(t, true, found)
+	th.Equals(t, "10_photos", folder)
+}
+
 func TestEnsureDirectoryExist(t *testing.T) {
 	fs := afero.NewMemMapFs()
 
@@ -170,7 +194,7 @@ func TestCopyFileToFolder(t *testing.T) {
 		destinationItem, err := catalog.NewItem(destinationFs, path.Join(destinationFolderPath, fileName))
 		th.Ok(t, err)
 		th.Equals(t, sourceItem.Size, destinationItem.Size)
-		th.Equals(t, sourceItem.Md5Sum, destinationItem.Md5Sum)
+		th.Equals(t, sourceItem.Checksum, destinationItem.Checksum)
 		th.Equals(t, sourceItem.ModificationTime, destinationItem.ModificationTime)
 	}
 
@@ -200,7 +224,7 @@ func TestCopyFileTo(t *testing.T) {
 		destinationItem, err := catalog.NewItem(destinationFs, destinationPath)
 		th.Ok(t, err)
 		th.Equals(t, sourceItem.Size, destinationItem.Size)
-		th.Equals(t, sourceItem.Md5Sum, destinationItem.Md5Sum)
+		th.Equals(t, sourceItem.Checksum, destinationItem.Checksum)
 		th.Equals(t, sourceItem.ModificationTime, destinationItem.ModificationTime)
 	}
 
@@ -212,3 +236,133 @@ func TestCopyFileTo(t *testing.T) {
 	rand.Read(buf)
 	testFile("folder/structure/test/big_file", buf, "nested/other/folder/bigFile")
 }
+
+// contentOf returns the content a copy of the file in the catalog item must have
+func contentOf(item *catalog.Item) ExpectedContent {
+	return ExpectedContent{Size: item.Size, NewHash: item.Checksum.Algorithm().New, Digest: item.Checksum.Digest()}
+}
+
+// flakyFs is a file system that returns corrupted data the first few times a file is read
+type flakyFs struct {
+	afero.Fs
+	failures int
+}
+
+func (fs *flakyFs) Open(name string) (afero.File, error) {
+	f, err := fs.Fs.Open(name)
+	if err != nil || fs.failures == 0 {
+		return f, err
+	}
+	fs.failures--
+	return flakyFile{f}, nil
+}
+
+// flakyFile flips the bits of the first byte of each read
+type flakyFile struct {
+	afero.File
+}
+
+func (f flakyFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if n > 0 {
+		p[0] = ^p[0]
+	}
+	return n, err
+}
+
+// noChtimesFs is a file system that cannot set the time stamps of the files
+type noChtimesFs struct {
+	afero.Fs
+}
+
+func (noChtimesFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return errors.New("operation not permitted")
+}
+
+// noSyncFs is a file system whose files cannot be synced to the disk
+type noSyncFs struct {
+	afero.Fs
+}
+
+func (fs noSyncFs) Create(name string) (afero.File, error) {
+	f, err := fs.Fs.Create(name)
+	return noSyncFile{f}, err
+}
+
+type noSyncFile struct {
+	afero.File
+}
+
+func (noSyncFile) Sync() error {
+	return errors.New("input/output error")
+}
+
+func TestCopyFileVerified(t *testing.T) {
+	defer func(delay time.Duration) { copyRetryDelay = delay }(copyRetryDelay)
+	copyRetryDelay = 0
+	sourceFs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(sourceFs, "photos/a.jpg", []byte("some content"), 0644))
+	sourceItem, err := catalog.NewItemWithAlgorithm(sourceFs, "photos/a.jpg", catalog.SHA256)
+	th.Ok(t, err)
+	expectCopy := func(fs afero.Fs, path string) {
+		t.Helper()
+		item, err := catalog.NewItemWithAlgorithm(fs, path, catalog.SHA256)
+		th.Ok(t, err)
+		item.Path = sourceItem.Path
+		th.Equals(t, sourceItem, item)
+		_, err = fs.Stat(partialCopyPath(path))
+		th.Assert(t, os.IsNotExist(err), "the partial copy of %v is left behind", path)
+	}
+
+	destinationFs := afero.NewMemMapFs()
+	th.Ok(t, CopyFileVerified(sourceFs, sourceItem.Path, sourceItem.ModificationTime, destinationFs, "1_import/a.jpg", contentOf(sourceItem)))
+	expectCopy(destinationFs, "1_import/a.jpg")
+
+	// the source is read again until the copy has the expected content
+	flaky := &flakyFs{Fs: sourceFs, failures: CopyAttempts - 1}
+	th.Ok(t, CopyFileVerified(flaky, sourceItem.Path, sourceItem.ModificationTime, destinationFs, "2_import/a.jpg", contentOf(sourceItem)))
+	expectCopy(destinationFs, "2_import/a.jpg")
+
+	// if all attempts fail, the file in the destination is not replaced
+	th.Ok(t, afero.WriteFile(destinationFs, "3_import/a.jpg", []byte("old content"), 0644))
+	flaky = &flakyFs{Fs: sourceFs, failures: CopyAttempts}
+	err = CopyFileVerified(flaky, sourceItem.Path, sourceItem.ModificationTime, destinationFs, "3_import/a.jpg", contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to copy file 'photos/a.jpg' in 3 attempts: attempt 1: Incorrect checksum after copy")
+	copyErr, ok := err.(*CopyError)
+	th.Assert(t, ok, "unexpected error type: %T", err)
+	th.Equals(t, CopyAttempts, len(copyErr.Attempts))
+	content, err := afero.ReadFile(destinationFs, "3_import/a.jpg")
+	th.Ok(t, err)
+	th.Equals(t, "old content", string(content))
+	_, err = destinationFs.Stat(partialCopyPath("3_import/a.jpg"))
+	th.Assert(t, os.IsNotExist(err), "the partial copy is left behind")
+
+	// the source changed since it was cataloged
+	expected := contentOf(sourceItem)
+	expected.Size++
+	err = CopyFileVerified(sourceFs, sourceItem.Path, sourceItem.ModificationTime, destinationFs, "4_import/a.jpg", expected)
+	th.NokPrefix(t, err, "Failed to copy file 'photos/a.jpg' in 3 attempts: attempt 1: Incorrect file size after copy: 12 bytes instead of 13")
+	_, err = destinationFs.Stat("4_import/a.jpg")
+	th.Assert(t, os.IsNotExist(err), "the failed copy is not removed")
+
+	// the time stamps must be set
+	err = CopyFileVerified(sourceFs, sourceItem.Path, sourceItem.ModificationTime, noChtimesFs{destinationFs}, "5_import/a.jpg", contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to set file attributes '5_import/a.jpg': operation not permitted")
+	_, err = destinationFs.Stat("5_import/a.jpg")
+	th.Assert(t, os.IsNotExist(err), "the copy without time stamps is not removed")
+
+	// the copy must be synced to the disk before it's renamed
+	err = CopyFileVerified(sourceFs, sourceItem.Path, sourceItem.ModificationTime, noSyncFs{destinationFs}, "6_import/a.jpg", contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to copy file 'photos/a.jpg': Cannot write destination file '6_import/a.jpg': input/output error")
+	_, err = destinationFs.Stat("6_import/a.jpg")
+	th.Assert(t, os.IsNotExist(err), "the copy that was not synced is renamed")
+	_, err = destinationFs.Stat(partialCopyPath("6_import/a.jpg"))
+	th.Assert(t, os.IsNotExist(err), "the partial copy is left behind")
+}
+
+func TestIsPartialCopy(t *testing.T) {
+	th.Equals(t, "folder/.a.jpg"+PartialCopySuffix, partialCopyPath("folder/a.jpg"))
+	th.Equals(t, ".a.jpg"+PartialCopySuffix, partialCopyPath("a.jpg"))
+	th.Equals(t, true, IsPartialCopy(partialCopyPath("folder/a.jpg")))
+	th.Equals(t, false, IsPartialCopy("folder/a.jpg"))
+}
@@ -0,0 +1,125 @@
+package fshelper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// StagingMode selects how the files are put into the staging folder
+type StagingMode string
+
+const (
+	// CopyMode copies the content of the files
+	CopyMode StagingMode = "copy"
+	// HardlinkMode creates hard links to the files instead of copying them. The staged file and the original file
+	// share their content and metadata, so changing one changes the other. Linking itself changes the link count and
+	// the change time of the original file.
+	HardlinkMode StagingMode = "hardlink"
+	// ReflinkMode clones the files, they share their data blocks until one of them is modified (copy-on-write).
+	// It's supported by some file systems, e.g. btrfs and XFS.
+	ReflinkMode StagingMode = "reflink"
+	// AutoMode clones the files if the file system supports it, otherwise copies them
+	AutoMode StagingMode = "auto"
+)
+
+// ParseStagingMode returns the staging mode with the given name, clone is accepted for reflink too
+func ParseStagingMode(name string) (StagingMode, error) {
+	switch mode := StagingMode(name); mode {
+	case CopyMode, HardlinkMode, ReflinkMode, AutoMode:
+		return mode, nil
+	case "clone":
+		return ReflinkMode, nil
+	}
+	return "", errors.Errorf("Unsupported staging mode: '%v'", name)
+}
+
+// osPath returns the path of an existing file of the fs in the file system of the operating system.
+// Returns false if the fs is not the OS file system or a BasePathFs on top of it.
+func osPath(fs afero.Fs, name string) (string, bool) {
+	var p string
+	switch base := fs.(type) {
+	case *afero.BasePathFs:
+		p = afero.FullBaseFsPath(base, name)
+	case *afero.OsFs:
+		p = name
+	default:
+		return "", false
+	}
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return "", false
+	}
+	// a BasePathFs can be on top of any other file system, it's only the OS file system if it's the same file
+	osFi, err := os.Stat(p)
+	if err != nil || !os.SameFile(fi, osFi) {
+		return "", false
+	}
+	return p, true
+}
+
+// StageFile puts a file of the source fs to the destination path in the destination fs with the given staging mode.
+// The containing directories are created as necessary. The source file is not modified, except in hardlink mode,
+// where linking changes its link count and change time.
+// Hard links and clones can only be created if both file systems are the file system of the operating system
+// (or a BasePathFs on top of it) and the source and the destination are on the same device. In auto mode the file is
+// copied if it cannot be cloned, in hardlink and reflink mode an error is returned.
+// The access and modification time stamps of copied and cloned files are set to the timestamp specified in
+// RFC3339Nano format, hard links keep the time stamps of the source file.
+// Copies are made by CopyFileVerified. Links and clones are created next to the destination path as a partial copy
+// (see PartialCopySuffix), they are read and renamed to the destination path only if they have the expected content.
+// In auto mode a clone that doesn't have the expected content is replaced by a copy.
+// Returns the mode that was actually used.
+func StageFile(sourceFs afero.Fs, sourcePath string, timestamp string, destinationFs afero.Fs, destinationPath string, mode StagingMode, expected ExpectedContent) (StagingMode, error) {
+	if mode == CopyMode {
+		return CopyMode, CopyFileVerified(sourceFs, sourcePath, timestamp, destinationFs, destinationPath, expected)
+	}
+	copyOrFail := func(err error) (StagingMode, error) {
+		if mode == AutoMode {
+			return CopyMode, CopyFileVerified(sourceFs, sourcePath, timestamp, destinationFs, destinationPath, expected)
+		}
+		return mode, err
+	}
+
+	folder := path.Dir(destinationPath)
+	if err := EnsureDirectoryExist(destinationFs, folder); err != nil {
+		return mode, err
+	}
+	source, sourceOk := osPath(sourceFs, sourcePath)
+	destinationFolder, destinationOk := osPath(destinationFs, folder)
+	if !sourceOk || !destinationOk || !sameDevice(source, destinationFolder) {
+		return copyOrFail(errors.Errorf("Cannot %v '%v', the source and the destination are not on the same file system", mode, sourcePath))
+	}
+	// the link or the clone is created under the name of a partial copy, it's renamed once it's verified
+	tempPath := partialCopyPath(destinationPath)
+	temp := filepath.Join(destinationFolder, path.Base(tempPath))
+	// a partial copy of an interrupted run may be in the way
+	os.Remove(temp)
+
+	if mode == HardlinkMode {
+		if err := os.Link(source, temp); err != nil {
+			return mode, errors.Wrapf(err, "Failed to link file '%v'", sourcePath)
+		}
+		if err := verifyFile(destinationFs, tempPath, "link", expected); err != nil {
+			os.Remove(temp)
+			return mode, errors.Wrapf(err, "Failed to link file '%v'", sourcePath)
+		}
+		// setting the time stamps of the link would change the source file
+		return HardlinkMode, errors.Wrapf(destinationFs.Rename(tempPath, destinationPath), "Failed to link file '%v'", sourcePath)
+	}
+	if err := cloneFile(source, temp); err != nil {
+		return copyOrFail(errors.Wrapf(err, "Failed to clone file '%v'", sourcePath))
+	}
+	if err := verifyFile(destinationFs, tempPath, "clone", expected); err != nil {
+		os.Remove(temp)
+		return copyOrFail(errors.Wrapf(err, "Failed to clone file '%v'", sourcePath))
+	}
+	if err := SetFileAttributes(destinationFs, tempPath, timestamp); err != nil {
+		os.Remove(temp)
+		return ReflinkMode, errors.Wrapf(err, "Failed to set file attributes '%v'", destinationPath)
+	}
+	return ReflinkMode, errors.Wrapf(destinationFs.Rename(tempPath, destinationPath), "Failed to clone file '%v'", sourcePath)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package fshelper
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the ioctl request that clones a file on Linux
+const ficlone = 0x40049409
+
+// sameDevice returns true if the two paths are on the same device
+func sameDevice(path1 string, path2 string) bool {
+	var st1, st2 syscall.Stat_t
+	if syscall.Stat(path1, &st1) != nil || syscall.Stat(path2, &st2) != nil {
+		return false
+	}
+	return st1.Dev == st2.Dev
+}
+
+// cloneFile creates a copy-on-write clone of the source file at the destination path.
+// The destination must not exist, it is removed if the clone fails.
+func cloneFile(source string, destination string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd()); errno != 0 {
+		dst.Close()
+		os.Remove(destination)
+		return errno
+	}
+	return dst.Close()
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package fshelper
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+// linkCountAndChangeTime returns the number of hard links and the change time of the file
+func linkCountAndChangeTime(t *testing.T, fs afero.Fs, path string) (uint64, int64) {
+	t.Helper()
+	fi, err := fs.Stat(path)
+	th.Ok(t, err)
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	th.Assert(t, ok, "no system metadata for %v", path)
+	return uint64(st.Nlink), st.Ctim.Nano()
+}
+
+func TestStageFileSourceMetadata(t *testing.T) {
+	for _, mode := range []StagingMode{CopyMode, AutoMode, HardlinkMode} {
+		importFs, stagingFs, cleanup := createOsTestFolders(t)
+		sourceItem, err := catalog.NewItem(importFs, "photos/a.jpg")
+		th.Ok(t, err)
+		links, changed := linkCountAndChangeTime(t, importFs, "photos/a.jpg")
+		th.Equals(t, uint64(1), links)
+		// make sure a change of the change time can be seen
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", mode, contentOf(sourceItem))
+		th.Ok(t, err)
+		links, changedAfter := linkCountAndChangeTime(t, importFs, "photos/a.jpg")
+		if mode == HardlinkMode {
+			// the import file is shared with the staging folder
+			th.Equals(t, uint64(2), links)
+			th.Assert(t, changedAfter > changed, "the change time of the source file is not updated by linking")
+		} else {
+			th.Equals(t, uint64(1), links)
+			th.Equals(t, changed, changedAfter)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package fshelper
+
+import "github.com/pkg/errors"
+
+// sameDevice cannot tell the device of the files on this operating system, so they are never linked or cloned
+func sameDevice(path1 string, path2 string) bool {
+	return false
+}
+
+// cloneFile is not supported on this operating system
+func cloneFile(source string, destination string) error {
+	return errors.New("Cloning files is not supported on this operating system")
+}
@@ -0,0 +1,151 @@
+package fshelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestParseStagingMode(t *testing.T) {
+	for _, name := range []string{"copy", "hardlink", "reflink", "auto"} {
+		mode, err := ParseStagingMode(name)
+		th.Ok(t, err)
+		th.Equals(t, StagingMode(name), mode)
+	}
+	mode, err := ParseStagingMode("clone")
+	th.Ok(t, err)
+	th.Equals(t, ReflinkMode, mode)
+	_, err = ParseStagingMode("symlink")
+	th.NokPrefix(t, err, "Unsupported staging mode: 'symlink'")
+}
+
+// createOsTestFolders creates an import and a staging folder in a temporary folder with a file in the import folder.
+// Returns the file systems of the folders and a function that removes them.
+func createOsTestFolders(t *testing.T) (importFs afero.Fs, stagingFs afero.Fs, cleanup func()) {
+	dir, err := ioutil.TempDir("", "fshelper")
+	th.Ok(t, err)
+	th.Ok(t, os.MkdirAll(filepath.Join(dir, "import", "photos"), 0755))
+	th.Ok(t, os.MkdirAll(filepath.Join(dir, "staging"), 0755))
+	th.Ok(t, ioutil.WriteFile(filepath.Join(dir, "import", "photos", "a.jpg"), []byte("some content"), 0644))
+	modTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	th.Ok(t, os.Chtimes(filepath.Join(dir, "import", "photos", "a.jpg"), modTime, modTime))
+	osFs := afero.NewOsFs()
+	return afero.NewBasePathFs(osFs, filepath.Join(dir, "import")), afero.NewBasePathFs(osFs, filepath.Join(dir, "staging")),
+		func() { os.RemoveAll(dir) }
+}
+
+func TestStageFileHardlink(t *testing.T) {
+	importFs, stagingFs, cleanup := createOsTestFolders(t)
+	defer cleanup()
+	sourceItem, err := catalog.NewItem(importFs, "photos/a.jpg")
+	th.Ok(t, err)
+	sourceInfo, err := importFs.Stat("photos/a.jpg")
+	th.Ok(t, err)
+
+	mode, err := StageFile(importFs, "photos/a.jpg", "2020-01-01T00:00:00Z", stagingFs, "1_import/photos/a.jpg", HardlinkMode, contentOf(sourceItem))
+	th.Ok(t, err)
+	th.Equals(t, HardlinkMode, mode)
+	stagedInfo, err := stagingFs.Stat("1_import/photos/a.jpg")
+	th.Ok(t, err)
+	th.Assert(t, os.SameFile(sourceInfo, stagedInfo), "the staged file is not a hard link")
+
+	// the content and the modification time of the source file are not changed, its link count and change time are
+	// checked by TestStageFileSourceMetadata
+	item, err := catalog.NewItem(importFs, "photos/a.jpg")
+	th.Ok(t, err)
+	th.Equals(t, sourceItem, item)
+}
+
+func TestStageFileVerifiesLinksAndClones(t *testing.T) {
+	defer func(delay time.Duration) { copyRetryDelay = delay }(copyRetryDelay)
+	copyRetryDelay = 0
+	importFs, stagingFs, cleanup := createOsTestFolders(t)
+	defer cleanup()
+	sourceItem, err := catalog.NewItem(importFs, "photos/a.jpg")
+	th.Ok(t, err)
+	// the file changed since it was cataloged
+	th.Ok(t, afero.WriteFile(importFs, "photos/a.jpg", []byte("other content"), 0644))
+
+	_, err = StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", HardlinkMode, contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to link file 'photos/a.jpg': Incorrect file size after link: 13 bytes instead of 12")
+	// if the file system cannot clone files, the error is about that
+	_, err = StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", ReflinkMode, contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to clone file 'photos/a.jpg'")
+	_, err = StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", AutoMode, contentOf(sourceItem))
+	th.NokPrefix(t, err, "Failed to copy file 'photos/a.jpg'")
+
+	files, err := afero.ReadDir(stagingFs, "1_import/photos")
+	th.Ok(t, err)
+	th.Equals(t, 0, len(files))
+}
+
+func TestStageFileAutoNeverLinks(t *testing.T) {
+	importFs, stagingFs, cleanup := createOsTestFolders(t)
+	defer cleanup()
+	sourceItem, err := catalog.NewItem(importFs, "photos/a.jpg")
+	th.Ok(t, err)
+	sourceInfo, err := importFs.Stat("photos/a.jpg")
+	th.Ok(t, err)
+
+	// the temporary folder may or may not support cloning, the file is cloned or copied
+	mode, err := StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", AutoMode, contentOf(sourceItem))
+	th.Ok(t, err)
+	th.Assert(t, mode == ReflinkMode || mode == CopyMode, "unexpected mode: %v", mode)
+	stagedInfo, err := stagingFs.Stat("1_import/photos/a.jpg")
+	th.Ok(t, err)
+	th.Assert(t, !os.SameFile(sourceInfo, stagedInfo), "the staged file is a hard link")
+	stagedItem, err := catalog.NewItem(stagingFs, "1_import/photos/a.jpg")
+	th.Ok(t, err)
+	stagedItem.Path = sourceItem.Path
+	th.Equals(t, sourceItem, stagedItem)
+}
+
+func TestStageFileReflink(t *testing.T) {
+	importFs, stagingFs, cleanup := createOsTestFolders(t)
+	defer cleanup()
+	sourceItem, err := catalog.NewItem(importFs, "photos/a.jpg")
+	th.Ok(t, err)
+
+	mode, err := StageFile(importFs, "photos/a.jpg", sourceItem.ModificationTime, stagingFs, "1_import/photos/a.jpg", ReflinkMode, contentOf(sourceItem))
+	if err != nil {
+		// the file system of the temporary folder cannot clone files, nothing is left behind
+		th.NokPrefix(t, err, "Failed to clone file 'photos/a.jpg'")
+		_, err = stagingFs.Stat("1_import/photos/a.jpg")
+		th.Assert(t, os.IsNotExist(err), "the failed clone is not removed")
+		return
+	}
+	th.Equals(t, ReflinkMode, mode)
+	stagedItem, err := catalog.NewItem(stagingFs, "1_import/photos/a.jpg")
+	th.Ok(t, err)
+	stagedItem.Path = sourceItem.Path
+	th.Equals(t, sourceItem, stagedItem)
+}
+
+func TestStageFileNotOnOsFs(t *testing.T) {
+	sourceFs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(sourceFs, "a.jpg", []byte("some content"), 0644))
+	sourceItem, err := catalog.NewItem(sourceFs, "a.jpg")
+	th.Ok(t, err)
+
+	for _, mode := range []StagingMode{HardlinkMode, ReflinkMode} {
+		_, err := StageFile(sourceFs, "a.jpg", sourceItem.ModificationTime, afero.NewMemMapFs(), "a.jpg", mode, contentOf(sourceItem))
+		th.NokPrefix(t, err, "Cannot "+string(mode)+" 'a.jpg', the source and the destination are not on the same file system")
+	}
+
+	for _, mode := range []StagingMode{CopyMode, AutoMode} {
+		destinationFs := afero.NewBasePathFs(afero.NewMemMapFs(), "staging")
+		used, err := StageFile(sourceFs, "a.jpg", sourceItem.ModificationTime, destinationFs, "1_import/a.jpg", mode, contentOf(sourceItem))
+		th.Ok(t, err)
+		th.Equals(t, CopyMode, used)
+		stagedItem, err := catalog.NewItem(destinationFs, "1_import/a.jpg")
+		th.Ok(t, err)
+		stagedItem.Path = sourceItem.Path
+		th.Equals(t, sourceItem, stagedItem)
+	}
+}
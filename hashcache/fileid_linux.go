@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package hashcache
+
+import (
+	"os"
+	"syscall"
+)
+
+// identify returns the identity of the file from the metadata of the operating system
+func identify(fi os.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{
+		device:     uint64(st.Dev),
+		inode:      uint64(st.Ino),
+		size:       fi.Size(),
+		modTime:    fi.ModTime().UnixNano(),
+		changeTime: st.Ctim.Nano(),
+	}, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package hashcache
+
+import "os"
+
+// identify cannot identify files on this operating system, so nothing is cached
+func identify(fi os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,307 @@
+// Package hashcache stores the checksums of files across runs and folders, so the files that haven't changed since
+// they were last hashed don't have to be read again, even if their catalog is lost or they are imported again.
+// The files are identified by their device and inode, and a cached checksum is only used while the size, the
+// modification time and the change time of the file are the same as when it was hashed.
+package hashcache
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// FileName is the name of the cache file in the cache folder of CoBack
+const FileName = "hashcache.json"
+
+// cacheVersion is the version of the cache file format
+const cacheVersion = 1
+
+// DefaultPath returns the path of the cache file in the user's cache directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot find the cache directory")
+	}
+	return filepath.Join(dir, "coback", FileName), nil
+}
+
+// fileID identifies the content of a file as long as it is not modified
+type fileID struct {
+	device     uint64
+	inode      uint64
+	size       int64
+	modTime    int64
+	changeTime int64
+}
+
+// entryKey identifies a checksum in the cache, the same file can have checksums with different algorithms
+type entryKey struct {
+	device    uint64
+	inode     uint64
+	algorithm catalog.HashAlgorithm
+}
+
+// entry is a cached checksum with the metadata the file had when it was hashed
+type entry struct {
+	Device     uint64           `json:"device"`
+	Inode      uint64           `json:"inode"`
+	Size       int64            `json:"size"`
+	ModTime    int64            `json:"mtime"`
+	ChangeTime int64            `json:"ctime"`
+	Checksum   catalog.Checksum `json:"checksum"`
+	Path       string           `json:"path,omitempty"`
+	LastUsed   time.Time        `json:"last_used"`
+}
+
+func (e *entry) key() entryKey {
+	return entryKey{device: e.Device, inode: e.Inode, algorithm: e.Checksum.Algorithm()}
+}
+
+func (e *entry) matches(id fileID) bool {
+	return e.Device == id.device && e.Inode == id.inode && e.Size == id.size &&
+		e.ModTime == id.modTime && e.ChangeTime == id.changeTime
+}
+
+type cacheFile struct {
+	Version int      `json:"version"`
+	Entries []*entry `json:"entries"`
+}
+
+// Cache is a persistent catalog.HashCache. It is safe for concurrent use.
+type Cache struct {
+	fs      afero.Fs
+	path    string
+	mu      sync.Mutex
+	entries map[entryKey]*entry
+	changed bool
+	now     func() time.Time
+}
+
+// Open reads the cache file at the path. If the file doesn't exist yet, an empty cache is returned that will be
+// saved to the path. Returns error if the file cannot be read or parsed.
+func Open(fs afero.Fs, path string) (*Cache, error) {
+	c := &Cache{fs: fs, path: path, entries: make(map[entryKey]*entry), now: time.Now}
+	data, err := afero.ReadFile(fs, path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read hash cache '%v'", path)
+	}
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse hash cache '%v'", path)
+	}
+	if f.Version > cacheVersion {
+		return nil, errors.Errorf("Hash cache '%v' was written by a newer version of CoBack", path)
+	}
+	for _, e := range f.Entries {
+		c.entries[e.key()] = e
+	}
+	return c, nil
+}
+
+// Path returns the path of the cache file
+func (c *Cache) Path() string {
+	return c.path
+}
+
+// Count returns the number of checksums in the cache
+func (c *Cache) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Checksum returns the cached checksum of the file, if it was calculated with the algorithm and the file hasn't
+// changed since. Files whose device and inode are not known (e.g. in memory file systems) are never cached.
+func (c *Cache) Checksum(fs afero.Fs, path string, fi os.FileInfo, alg catalog.HashAlgorithm) (catalog.Checksum, bool) {
+	id, ok := identify(fi)
+	if !ok {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[entryKey{device: id.device, inode: id.inode, algorithm: alg}]
+	if !found || !e.matches(id) {
+		return "", false
+	}
+	e.LastUsed = c.now()
+	if realPath := fullPath(fs, path); realPath != "" {
+		e.Path = realPath
+	}
+	c.changed = true
+	return e.Checksum, true
+}
+
+// Add stores the checksum of the file, replacing the checksum of a previous version of the file
+func (c *Cache) Add(fs afero.Fs, path string, fi os.FileInfo, sum catalog.Checksum) {
+	id, ok := identify(fi)
+	if !ok {
+		return
+	}
+	e := &entry{
+		Device:     id.device,
+		Inode:      id.inode,
+		Size:       id.size,
+		ModTime:    id.modTime,
+		ChangeTime: id.changeTime,
+		Checksum:   sum,
+		Path:       fullPath(fs, path),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.LastUsed = c.now()
+	c.entries[e.key()] = e
+	c.changed = true
+}
+
+// fullPath returns the path of the file in the operating system's file system, or an empty string if it's not known
+func fullPath(fs afero.Fs, path string) string {
+	switch base := fs.(type) {
+	case *afero.BasePathFs:
+		if p, err := filepath.Abs(afero.FullBaseFsPath(base, path)); err == nil {
+			return p
+		}
+	case *afero.OsFs:
+		if p, err := filepath.Abs(path); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// Save writes the cache to its file if it was changed since it was opened. The folder of the file is created if
+// necessary. The file is written to a temporary file first, so an interrupted save doesn't destroy the cache.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	f := cacheFile{Version: cacheVersion, Entries: make([]*entry, 0, len(c.entries))}
+	for _, e := range c.entries {
+		f.Entries = append(f.Entries, e)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "Cannot serialize hash cache")
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return errors.Wrapf(err, "Cannot create the folder of the hash cache '%v'", c.path)
+	}
+	tempPath := c.path + ".tmp"
+	if err := writeSynced(c.fs, tempPath, data); err != nil {
+		c.fs.Remove(tempPath)
+		return errors.Wrapf(err, "Cannot write hash cache '%v'", c.path)
+	}
+	if err := c.fs.Rename(tempPath, c.path); err != nil {
+		return errors.Wrapf(err, "Cannot write hash cache '%v'", c.path)
+	}
+	c.changed = false
+	return nil
+}
+
+// writeSynced writes the data to the file and flushes it to the disk, so it can be renamed over the previous version
+// without the risk of leaving an empty or truncated file behind after a crash
+func writeSynced(fs afero.Fs, path string, data []byte) error {
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stale returns true if the file of the entry doesn't exist anymore or it was modified since it was hashed
+func (c *Cache) stale(e *entry) bool {
+	if e.Path == "" {
+		return false
+	}
+	fi, err := c.fs.Stat(e.Path)
+	if err != nil {
+		return true
+	}
+	id, ok := identify(fi)
+	return !ok || !e.matches(id)
+}
+
+// Prune removes the checksums of the files that were deleted or modified since they were hashed, and the checksums
+// that were not used for longer than maxAge. If maxAge is 0, the checksums are kept regardless of their age.
+// Returns the number of removed checksums.
+func (c *Cache) Prune(maxAge time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, e := range c.entries {
+		if (maxAge > 0 && c.now().Sub(e.LastUsed) > maxAge) || c.stale(e) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.changed = true
+	}
+	return removed
+}
+
+// VerifyResult summarizes the result of Verify
+type VerifyResult struct {
+	// Checked is the number of files that were read and matched their cached checksum
+	Checked int
+	// Mismatched lists the files whose content doesn't match their cached checksum, their checksums were removed
+	Mismatched []string
+	// Skipped is the number of checksums that couldn't be checked, because their file is unknown, was modified or cannot be read
+	Skipped int
+}
+
+// Verify reads the files of the cached checksums again and removes the checksums that don't match the content.
+// The checksums of files that were modified or deleted are skipped, they are removed by Prune.
+// If the context is cancelled, the result of the files checked so far is returned with the error of the context.
+func (c *Cache) Verify(ctx context.Context) (VerifyResult, error) {
+	c.mu.Lock()
+	entries := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	c.mu.Unlock()
+
+	var result VerifyResult
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if e.Path == "" || c.stale(e) {
+			result.Skipped++
+			continue
+		}
+		item, err := catalog.NewItemWithAlgorithm(c.fs, e.Path, e.Checksum.Algorithm())
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		if item.Checksum == e.Checksum {
+			result.Checked++
+			continue
+		}
+		result.Mismatched = append(result.Mismatched, e.Path)
+		c.mu.Lock()
+		delete(c.entries, e.key())
+		c.changed = true
+		c.mu.Unlock()
+	}
+	return result, nil
+}
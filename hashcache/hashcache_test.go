@@ -0,0 +1,222 @@
+package hashcache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// createTestFolder creates a temporary folder on the disk, the cache only works with real files
+func createTestFolder(t *testing.T) (dir string, fs afero.Fs, cleanup func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "hashcache")
+	th.Ok(t, err)
+	return dir, afero.NewBasePathFs(afero.NewOsFs(), dir), func() { os.RemoveAll(dir) }
+}
+
+func stat(t *testing.T, fs afero.Fs, path string) os.FileInfo {
+	t.Helper()
+	fi, err := fs.Stat(path)
+	th.Ok(t, err)
+	return fi
+}
+
+func TestOpenMissingCache(t *testing.T) {
+	dir, _, cleanup := createTestFolder(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cache", FileName)
+	c, err := Open(afero.NewOsFs(), path)
+	th.Ok(t, err)
+	th.Equals(t, 0, c.Count())
+	th.Equals(t, path, c.Path())
+
+	// an unchanged cache is not written
+	th.Ok(t, c.Save())
+	exists, _ := afero.Exists(afero.NewOsFs(), path)
+	th.Equals(t, false, exists)
+}
+
+func TestOpenInvalidCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "garbage.json", []byte("{not json"), 0644))
+	_, err := Open(fs, "garbage.json")
+	th.NokPrefix(t, err, "Cannot parse hash cache 'garbage.json'")
+
+	th.Ok(t, afero.WriteFile(fs, "newer.json", []byte(`{"version": 2, "entries": []}`), 0644))
+	_, err = Open(fs, "newer.json")
+	th.NokPrefix(t, err, "Hash cache 'newer.json' was written by a newer version of CoBack")
+}
+
+func TestCacheChecksums(t *testing.T) {
+	dir, fs, cleanup := createTestFolder(t)
+	defer cleanup()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content"), 0644))
+	cachePath := filepath.Join(dir, "cache", FileName)
+	c, err := Open(afero.NewOsFs(), cachePath)
+	th.Ok(t, err)
+
+	_, ok := c.Checksum(fs, "a.txt", stat(t, fs, "a.txt"), catalog.MD5)
+	th.Equals(t, false, ok)
+	item, err := catalog.NewItemWithCache(fs, "a.txt", catalog.MD5, c)
+	th.Ok(t, err)
+	th.Equals(t, 1, c.Count())
+	sum, ok := c.Checksum(fs, "a.txt", stat(t, fs, "a.txt"), catalog.MD5)
+	th.Equals(t, true, ok)
+	th.Equals(t, item.Checksum, sum)
+	_, ok = c.Checksum(fs, "a.txt", stat(t, fs, "a.txt"), catalog.SHA256)
+	th.Equals(t, false, ok)
+
+	// the checksums are kept between runs
+	th.Ok(t, c.Save())
+	c, err = Open(afero.NewOsFs(), cachePath)
+	th.Ok(t, err)
+	th.Equals(t, 1, c.Count())
+	sum, ok = c.Checksum(fs, "a.txt", stat(t, fs, "a.txt"), catalog.MD5)
+	th.Equals(t, true, ok)
+	th.Equals(t, item.Checksum, sum)
+	th.Equals(t, filepath.Join(dir, "a.txt"), c.entries[onlyKey(t, c)].Path)
+}
+
+// noSyncFs is a file system whose files cannot be flushed to the disk
+type noSyncFs struct {
+	afero.Fs
+}
+
+func (fs noSyncFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	return noSyncFile{f}, err
+}
+
+type noSyncFile struct {
+	afero.File
+}
+
+func (noSyncFile) Sync() error {
+	return errors.New("input/output error")
+}
+
+func TestSaveSyncsCache(t *testing.T) {
+	dir, fs, cleanup := createTestFolder(t)
+	defer cleanup()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content"), 0644))
+	cachePath := filepath.Join(dir, "cache", FileName)
+	c, err := Open(noSyncFs{afero.NewOsFs()}, cachePath)
+	th.Ok(t, err)
+	_, err = catalog.NewItemWithCache(fs, "a.txt", catalog.MD5, c)
+	th.Ok(t, err)
+
+	// the cache is not renamed to its final name unless it's on the disk
+	th.NokPrefix(t, c.Save(), "Cannot write hash cache '"+cachePath+"': input/output error")
+	for _, path := range []string{cachePath, cachePath + ".tmp"} {
+		exists, _ := afero.Exists(afero.NewOsFs(), path)
+		th.Assert(t, !exists, "%v is written", path)
+	}
+}
+
+// onlyKey returns the key of the only entry of the cache
+func onlyKey(t *testing.T, c *Cache) entryKey {
+	t.Helper()
+	th.Equals(t, 1, len(c.entries))
+	for key := range c.entries {
+		return key
+	}
+	return entryKey{}
+}
+
+func TestCacheIgnoresModifiedFiles(t *testing.T) {
+	_, fs, cleanup := createTestFolder(t)
+	defer cleanup()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content"), 0644))
+	c, err := Open(afero.NewMemMapFs(), FileName)
+	th.Ok(t, err)
+	_, err = catalog.NewItemWithCache(fs, "a.txt", catalog.MD5, c)
+	th.Ok(t, err)
+
+	ts := time.Date(2018, 10, 24, 23, 38, 47, 0, time.UTC)
+	th.Ok(t, fs.Chtimes("a.txt", ts, ts))
+	_, ok := c.Checksum(fs, "a.txt", stat(t, fs, "a.txt"), catalog.MD5)
+	th.Equals(t, false, ok)
+
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("new content"), 0644))
+	item, err := catalog.NewItemWithCache(fs, "a.txt", catalog.MD5, c)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Checksum("96c15c2bb2921193bf290df8cd85e2ba"), item.Checksum)
+	th.Equals(t, 1, c.Count())
+}
+
+func TestCacheIgnoresUnknownFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content"), 0644))
+	c, err := Open(fs, FileName)
+	th.Ok(t, err)
+	_, err = catalog.NewItemWithCache(fs, "a.txt", catalog.MD5, c)
+	th.Ok(t, err)
+	th.Equals(t, 0, c.Count())
+}
+
+func TestPrune(t *testing.T) {
+	_, fs, cleanup := createTestFolder(t)
+	defer cleanup()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "c.txt", []byte("content c"), 0644))
+	c, err := Open(afero.NewOsFs(), filepath.Join(os.TempDir(), "no_such_folder", FileName))
+	th.Ok(t, err)
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		_, err = catalog.NewItemWithCache(fs, path, catalog.MD5, c)
+		th.Ok(t, err)
+	}
+	th.Equals(t, 3, c.Count())
+
+	th.Ok(t, fs.Remove("a.txt"))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("modified content b"), 0644))
+	th.Equals(t, 2, c.Prune(0))
+	th.Equals(t, 1, c.Count())
+
+	c.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+	th.Equals(t, 0, c.Prune(72*time.Hour))
+	th.Equals(t, 1, c.Prune(24*time.Hour))
+	th.Equals(t, 0, c.Count())
+}
+
+func TestVerify(t *testing.T) {
+	_, fs, cleanup := createTestFolder(t)
+	defer cleanup()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "c.txt", []byte("content c"), 0644))
+	c, err := Open(afero.NewOsFs(), filepath.Join(os.TempDir(), "no_such_folder", FileName))
+	th.Ok(t, err)
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		_, err = catalog.NewItemWithCache(fs, path, catalog.MD5, c)
+		th.Ok(t, err)
+	}
+	th.Ok(t, fs.Remove("c.txt"))
+	var corrupted *entry
+	for _, e := range c.entries {
+		if filepath.Base(e.Path) == "b.txt" {
+			corrupted = e
+		}
+	}
+	corrupted.Checksum = "00000000000000000000000000000000"
+
+	result, err := c.Verify(context.Background())
+	th.Ok(t, err)
+	th.Equals(t, 1, result.Checked)
+	th.Equals(t, []string{corrupted.Path}, result.Mismatched)
+	th.Equals(t, 1, result.Skipped)
+	th.Equals(t, 2, c.Count())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Verify(ctx)
+	th.Equals(t, context.Canceled, err)
+}
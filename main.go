@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mitro42/coback/catalog"
 	fsh "github.com/mitro42/coback/fshelper"
@@ -14,24 +20,112 @@ import (
 
 const incompleteRunNoticeFileName = "!!!_COBACK_RUN_WAS_INTERRUPTED"
 
-// stageFiles creates a new numbered folder in the staging folder and copies all files
-// in the items channel from the import FS to this new folder in the staging FS.
-func stageFiles(importFs afero.Fs, importName string, items <-chan catalog.Item, stagingFs afero.Fs) error {
-	fmt.Println("***************** Copying files to staging folder *****************")
-	targetFolder := fsh.NextUnusedFolder(stagingFs) + "_" + importName
+// message reports an event that only informs the user
+func message(r scan.Reporter, format string, args ...interface{}) {
+	r.Report(scan.Event{Type: scan.MessageEvent, Message: fmt.Sprintf(format, args...)})
+}
+
+// expectedContent returns the content the copy of a file must have, as it is in the catalog
+func expectedContent(item catalog.Item) fsh.ExpectedContent {
+	return fsh.ExpectedContent{Size: item.Size, NewHash: item.Checksum.Algorithm().New, Digest: item.Checksum.Digest()}
+}
+
+// stageFiles copies all files of the catalog from the import FS to the target folder in the staging FS.
+// The target folder is created if necessary. The files are copied, linked or cloned as set by the staging mode of the
+// options, copies are verified against the checksums of the catalog. Each staged file is sent to the reporter of the
+// options, and the progress of the copying is shown by their progress sink.
+// Returns the number and the size of the files copied, even if an error stopped the copying.
+// Stops before the next file and returns the error of the context if it is cancelled.
+func stageFiles(ctx context.Context, importFs afero.Fs, targetFolder string, files catalog.Catalog, stagingFs afero.Fs,
+	o runOptions) (staged fileCount, err error) {
+	r := o.reporter
+	r.Report(scan.Event{Type: scan.PhaseStarted, Phase: "stage", Message: "***************** Copying files to staging folder *****************"})
+	var total fileCount
+	for item := range files.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		total.add(item)
+	}
+	pb := o.progress.NewProgressBar("stage")
+	pb.SetTotal(int64(total.files), total.bytes)
+	defer func() {
+		pb.Wait()
+		e := scan.Event{Type: scan.PhaseFinished, Phase: "stage", Files: staged.files}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		r.Report(e)
+	}()
 	fsh.EnsureDirectoryExist(stagingFs, targetFolder)
-	targetFs := afero.NewBasePathFs(stagingFs, targetFolder)
-	for item := range items {
+	for item := range files.AllItems() {
 		if item.Path == "" {
-			return nil
+			return staged, nil
+		}
+		if ctx.Err() != nil {
+			return staged, ctx.Err()
 		}
-		fmt.Printf("%s --> %s\n", item.Path, filepath.Join(targetFolder, item.Path))
-		err := fsh.CopyFile(importFs, item.Path, item.ModificationTime, targetFs)
+		target := filepath.Join(targetFolder, item.Path)
+		mode, err := fsh.StageFile(importFs, item.Path, item.ModificationTime, stagingFs, target, o.stagingMode, expectedContent(item))
 		if err != nil {
-			return err
+			return staged, err
 		}
+		staged.add(item)
+		pb.IncrBy(int(item.Size))
+		msg := fmt.Sprintf("%s --> %s", item.Path, target)
+		if mode != fsh.CopyMode {
+			msg += fmt.Sprintf(" (%v)", mode)
+		}
+		r.Report(scan.Event{Type: scan.FileStaged, Phase: "stage", Path: item.Path, Target: target, Mode: string(mode), Message: msg})
 	}
-	return nil
+	return staged, nil
+}
+
+// stagingTargetFolder returns the folder in the staging folder where the new files of the import folder are copied.
+// Normally this is a new numbered folder, but if the previous copy from the same import folder was interrupted,
+// the folder of that copy is reused. Files in it that were only partially copied are removed.
+func stagingTargetFolder(stagingFs afero.Fs, importName string, importCatalog catalog.Catalog, r scan.Reporter) string {
+	if importCatalog.State() == catalog.Copying {
+		if folder, found := fsh.LastUsedFolder(stagingFs, importName); found {
+			message(r, "Resuming interrupted copy to %v", folder)
+			removePartialCopies(afero.NewBasePathFs(stagingFs, folder), importCatalog, r)
+			return folder
+		}
+	}
+	return fsh.NextUnusedFolder(stagingFs) + "_" + importName
+}
+
+// removePartialCopies removes the files from a staging folder that have a different size than the
+// file with the same path in the import catalog, and the partial copies left behind by an interrupted copy.
+func removePartialCopies(targetFs afero.Fs, importCatalog catalog.Catalog, r scan.Reporter) {
+	afero.Walk(targetFs, ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if fsh.IsPartialCopy(path) {
+			targetFs.Remove(path)
+			return nil
+		}
+		item, err := importCatalog.Item(path)
+		if err == nil && item.Size != info.Size() {
+			message(r, "Removing partially copied file %v", path)
+			targetFs.Remove(path)
+		}
+		return nil
+	})
+}
+
+// withImportFolder sets the import folder of a tombstone from the staging catalog, if it's not set yet.
+// The paths in the staging catalog start with the folder the file was copied to by the import.
+func withImportFolder(tombstone catalog.Tombstone) catalog.Tombstone {
+	if tombstone.ImportFolder != "" || len(tombstone.Paths) == 0 {
+		return tombstone
+	}
+	parts := strings.SplitN(filepath.ToSlash(tombstone.Paths[0]), "/", 2)
+	if len(parts) == 2 {
+		tombstone.ImportFolder = parts[0]
+	}
+	return tombstone
 }
 
 // initializeFolders checks that the specified paths exist and they are not files.
@@ -57,12 +151,13 @@ func initializeFolders(baseFs afero.Fs, fromPath string, stagingPath string, toP
 }
 
 // Checks if staging folder can be used by coback.
-// It either must have a catalog exist (it was used previously), or the folder must be empty (it wasn't used yet)
+// It either must have a catalog exist (it was used previously), or the folder must be empty (it wasn't used yet).
+// Leftover backups of a removed catalog don't count as content.
 func checkUsableStagingFolder(stagingFs afero.Fs) error {
 	if stagingCatalogFI, err := stagingFs.Stat(catalog.CatalogFileName); err != nil {
 		fail := false
 		afero.Walk(stagingFs, ".", func(path string, info os.FileInfo, err error) error {
-			if path == "." {
+			if path == "." || catalog.IsCatalogFile(path) {
 				return nil
 			}
 			fail = true
@@ -79,47 +174,292 @@ func checkUsableStagingFolder(stagingFs afero.Fs) error {
 	return nil
 }
 
-func run(importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs) error {
-	err := checkUsableStagingFolder(stagingFs)
+// collectUnreadable adds the files of an UnreadableFilesError to the list and returns nil,
+// so the run can go on with the files that could be read. Any other error is returned as it is.
+func collectUnreadable(unreadable *[]scan.FileError, err error) error {
+	if e, ok := errors.Cause(err).(*scan.UnreadableFilesError); ok {
+		*unreadable = append(*unreadable, e.Files...)
+		return nil
+	}
+	return err
+}
+
+// unreadableError returns an UnreadableFilesError with the files in the list, or nil if the list is empty
+func unreadableError(unreadable []scan.FileError) error {
+	if len(unreadable) == 0 {
+		return nil
+	}
+	return &scan.UnreadableFilesError{Files: unreadable}
+}
+
+// importPlan is the state of the three folders before the new files of the import folder are staged
+type importPlan struct {
+	importCatalog     catalog.Catalog
+	stagingCatalog    catalog.Catalog
+	collectionCatalog catalog.Catalog
+	// notInCollection contains the files of the import folder that are not in the collection and were not deleted from it
+	notInCollection catalog.Catalog
+	// notInStaging contains the files of notInCollection that are not in the staging folder either, they have to be staged
+	notInStaging catalog.Catalog
+	// importComplete is false if some files of the import folder could not be read
+	importComplete bool
+	// targetFolder is the folder in the staging folder where the files are copied, empty in a dry run
+	targetFolder string
+	// unreadable lists the files of the three folders that could not be read
+	unreadable []scan.FileError
+}
+
+// planImport syncs the catalogs of the three folders and finds the files of the import folder that have to be staged.
+// The scan options are used when syncing the folders, the filter set with scan.WithFilter only affects the import folder.
+// The import and staging catalogs always use the same hash algorithm as the collection,
+// otherwise their checksums couldn't be compared.
+// The files deleted from the staging folder are recorded in the catalog of the collection, and the catalogs of the
+// collection and the import folder are saved. Files that cannot be read are left out and collected in the plan.
+// Unless it's a dry run, the target folder in the staging folder is selected (and cleaned up if an interrupted copy
+// is resumed) before the staging folder is synced. In a dry run the folders are synced through dryRunFs, so the
+// catalogs are not saved and the deleted files of the staging folder are only moved to the collection in memory.
+func planImport(ctx context.Context, importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs,
+	o runOptions) (importPlan, error) {
+	var plan importPlan
+	var err error
+	if o.dryRun {
+		importFs, stagingFs, collectionFs = dryRunFs(importFs), dryRunFs(stagingFs), dryRunFs(collectionFs)
+	}
+	opts := o.scanOptions
+	plan.collectionCatalog, err = scan.SyncCatalogWithCollectionFolder(ctx, collectionFs, opts...)
+	if err = collectUnreadable(&plan.unreadable, err); err != nil {
+		return plan, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+
+	unreadableCount := len(plan.unreadable)
+	importOpts := append(append([]scan.Option{}, opts...), scan.WithHashAlgorithm(plan.collectionCatalog.HashAlgorithm()))
+	plan.importCatalog, err = scan.SyncCatalogWithImportFolder(ctx, importFs, importOpts...)
+	if err = collectUnreadable(&plan.unreadable, err); err != nil {
+		return plan, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+	plan.importComplete = len(plan.unreadable) == unreadableCount
+	plan.importCatalog.Write(importFs)
+	if plan.importCatalog.State() == catalog.Done {
+		message(o.reporter, "The import folder was already completely processed")
+	}
+	if !o.dryRun {
+		plan.targetFolder = stagingTargetFolder(stagingFs, importName, plan.importCatalog, o.reporter)
+	}
+
+	plan.stagingCatalog, err = scan.SyncCatalogWithStagingFolder(ctx, stagingFs, plan.collectionCatalog, opts...)
+	if err = collectUnreadable(&plan.unreadable, err); err != nil {
+		return plan, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+
+	for deletedChecksum := range plan.stagingCatalog.DeletedChecksums() {
+		tombstone, _ := plan.stagingCatalog.Tombstone(deletedChecksum)
+		plan.collectionCatalog.AddTombstone(deletedChecksum, withImportFolder(tombstone))
+		plan.stagingCatalog.UnDeleteChecksum(deletedChecksum)
+	}
+	plan.collectionCatalog.Write(collectionFs)
+
+	plan.notInCollection = plan.importCatalog.FilterNew(plan.collectionCatalog)
+	plan.notInStaging = plan.notInCollection.FilterNew(plan.stagingCatalog)
+	return plan, nil
+}
+
+// dryRunFs returns a view of the folder for a dry run. Files are read from the folder, but everything written, like the
+// synced catalogs, only goes to memory, so the folder is left exactly as it was.
+func dryRunFs(fs afero.Fs) afero.Fs {
+	return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(fs), afero.NewMemMapFs())
+}
+
+// runOptions are the settings of an import
+type runOptions struct {
+	// scanOptions are used when syncing the folders
+	scanOptions []scan.Option
+	// dryRun stops the import before anything is copied, only a summary of what would be staged is reported
+	dryRun bool
+	// reporter receives the events of the import and of the syncs
+	reporter scan.Reporter
+	// progress shows the progress of the syncs and of copying the files
+	progress scan.ProgressSink
+	// stagingMode tells how the files are put into the staging folder
+	stagingMode fsh.StagingMode
+	// move deletes the files of the import folder once they are safely stored in the staging folder or the collection
+	move bool
+}
+
+// runOption changes a setting of an import
+type runOption func(*runOptions)
+
+func newRunOptions(opts []runOption) runOptions {
+	o := runOptions{
+		reporter:    scan.NewTextReporter(os.Stdout),
+		progress:    scan.NewTerminalProgress(os.Stdout),
+		stagingMode: fsh.AutoMode,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// check returns an error if the settings cannot be used together
+func (o runOptions) check() error {
+	if o.move && o.stagingMode == fsh.HardlinkMode {
+		return errors.New("Files cannot be removed from the import folder if they are staged as hard links, they have no separate copy")
+	}
+	return nil
+}
+
+// withScanOptions sets the options used when syncing the folders.
+// The filter set with scan.WithFilter only affects the import folder.
+func withScanOptions(opts ...scan.Option) runOption {
+	return func(o *runOptions) {
+		o.scanOptions = append(o.scanOptions, opts...)
+	}
+}
+
+// withDryRun makes the import only report what it would copy to the staging folder and what it would skip
+func withDryRun() runOption {
+	return func(o *runOptions) {
+		o.dryRun = true
+	}
+}
+
+// withReporter sets the Reporter that receives the events of the import and of the syncs.
+// By default their messages are printed to the standard output.
+func withReporter(r scan.Reporter) runOption {
+	return func(o *runOptions) {
+		o.reporter = r
+		o.scanOptions = append(o.scanOptions, scan.WithReporter(r))
+	}
+}
+
+// withProgress sets the ProgressSink that shows the progress of the syncs and of copying the files.
+// By default progress bars are drawn to the standard output.
+func withProgress(p scan.ProgressSink) runOption {
+	return func(o *runOptions) {
+		o.progress = p
+		o.scanOptions = append(o.scanOptions, scan.WithProgress(p))
+	}
+}
+
+// withStagingMode sets how the files are put into the staging folder, by default they are cloned if the file system
+// supports it, otherwise copied
+func withStagingMode(mode fsh.StagingMode) runOption {
+	return func(o *runOptions) {
+		o.stagingMode = mode
+	}
+}
+
+// withMove makes the import delete the files of the import folder that are not needed anymore after staging,
+// see consumeImportFolder
+func withMove() runOption {
+	return func(o *runOptions) {
+		o.move = true
+	}
+}
+
+// run imports the new files from the import folder to the staging folder.
+// The folders are synced by planImport, with the scan options set with withScanOptions.
+// The state of the import catalog follows the progress: it is copying while the files are staged, then copied,
+// or done if all of its files are already in the collection or were deleted from it.
+// The skipped and the staged files are reported to the reporter set with withReporter, the progress is shown by the
+// sink set with withProgress. Once the copying started,
+// a runReport is written into the folder the files are copied to, and sent to the reporter at the end.
+// In move mode, set with withMove, the files of the import folder that are safely stored are deleted after staging,
+// and removed from the import catalog. Move mode cannot be combined with hardlink staging mode, the staged files would
+// be the same files as the ones deleted.
+// run always stages the files, dry runs are sent to previewRun by runImport.
+// Files that cannot be read don't stop the run, they are left out and returned in an UnreadableFilesError at the end.
+// The import catalog is not marked done while it has unreadable files.
+func run(ctx context.Context, importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs, opts ...runOption) error {
+	o := newRunOptions(opts)
+	err := o.check()
 	if err != nil {
 		return err
 	}
-
-	importCatalog, err := scan.SyncCatalogWithImportFolder(importFs)
+	started := time.Now()
+	err = checkUsableStagingFolder(stagingFs)
 	if err != nil {
-		return errors.Wrapf(err, "Cannot sync folder contents")
+		return err
 	}
-	importCatalog.Write(importFs)
 
-	collectionCatalog, err := scan.SyncCatalogWithCollectionFolder(collectionFs)
+	plan, err := planImport(ctx, importFs, importName, stagingFs, collectionFs, o)
 	if err != nil {
-		return errors.Wrapf(err, "Cannot sync folder contents")
+		return err
 	}
+	summary := summarizeImport(plan)
+	reportSkippedFiles(plan, o.reporter)
+	report := newRunReport(importName, plan.targetFolder, summary.total, started)
+	// finish writes the report into the staging folder and reports it at the end of the run
+	finish := func(err error) error {
+		report.finish(plan.unreadable, err)
+		if err := writeRunReport(stagingFs, report); err != nil {
+			message(o.reporter, "Failed to write the report of the run: %v", err)
+		}
+		o.reporter.Report(scan.Event{Type: scan.RunFinished, Summary: report})
+		return err
+	}
+	importCatalog := plan.importCatalog
 
-	stagingCatalog, err := scan.SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	if plan.notInStaging.Count() > 0 {
+		importCatalog.SetState(catalog.Copying)
+		importCatalog.Write(importFs)
+	}
+	report.Staged, err = stageFiles(ctx, importFs, plan.targetFolder, plan.notInStaging, stagingFs, o)
 	if err != nil {
-		return errors.Wrapf(err, "Cannot sync folder contents")
+		return finish(errors.Wrapf(err, "Failed to copy files"))
 	}
 
-	for deletedChecksum := range stagingCatalog.DeletedChecksums() {
-		collectionCatalog.DeleteChecksum(deletedChecksum)
-		stagingCatalog.UnDeleteChecksum(deletedChecksum)
+	stagingCatalog, err := scan.SyncCatalogWithStagingFolder(ctx, stagingFs, plan.collectionCatalog, o.scanOptions...)
+	if err = collectUnreadable(&plan.unreadable, err); err != nil {
+		return finish(errors.Wrapf(err, "Cannot sync folder contents after staging"))
 	}
-	collectionCatalog.Write(collectionFs)
+	stagingCatalog.Write(stagingFs)
 
-	notInCollection := importCatalog.FilterNew(collectionCatalog)
-	notInStaging := notInCollection.FilterNew(stagingCatalog)
+	if o.move {
+		report.Removed, err = consumeImportFolder(ctx, importFs, importName, plan, stagingCatalog, stagingFs, o.reporter)
+		importCatalog.Write(importFs)
+		if err != nil {
+			return finish(errors.Wrapf(err, "Failed to remove files from the import folder"))
+		}
+	}
 
-	if err = stageFiles(importFs, importName, notInStaging.AllItems(), stagingFs); err != nil {
-		return errors.Wrapf(err, "Failed to copy files")
+	if plan.notInCollection.Count() == 0 && plan.importComplete {
+		importCatalog.SetState(catalog.Done)
+	} else {
+		importCatalog.SetState(catalog.Copied)
 	}
+	importCatalog.Write(importFs)
+	return finish(unreadableError(plan.unreadable))
+}
 
-	stagingCatalog, err = scan.SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+// previewRun syncs the catalogs of the folders like run does, without saving them, and reports what run would copy to the staging folder
+// and what it would skip, without copying anything. The skipped files are reported like in a run, and the summary of
+// the import is reported at the end in a runReport marked as a dry run, with the human readable summary as its message.
+// Nothing is written to the folders, their catalogs are left unchanged.
+// Files that cannot be read are returned in an UnreadableFilesError at the end.
+func previewRun(ctx context.Context, importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs, opts ...runOption) error {
+	o := newRunOptions(append(opts, withDryRun()))
+	err := o.check()
 	if err != nil {
-		return errors.Wrapf(err, "Cannot sync folder contents after staging")
+		return err
 	}
-	stagingCatalog.Write(stagingFs)
-	return nil
+	started := time.Now()
+	err = checkUsableStagingFolder(stagingFs)
+	if err != nil {
+		return err
+	}
+	plan, err := planImport(ctx, importFs, importName, stagingFs, collectionFs, o)
+	if err != nil {
+		return err
+	}
+	summary := summarizeImport(plan)
+	reportSkippedFiles(plan, o.reporter)
+	report := newRunReport(importName, plan.targetFolder, summary.total, started)
+	err = unreadableError(plan.unreadable)
+	report.DryRun = true
+	report.finish(plan.unreadable, err)
+	o.reporter.Report(scan.Event{Type: scan.RunFinished, Summary: report, Message: summary.String()})
+	return err
 }
 
 func createIncompleteRunNotice(fs afero.Fs) error {
@@ -138,29 +478,212 @@ func removeIncompleteRunNotice(fs afero.Fs) {
 	fs.Remove(incompleteRunNoticeFileName)
 }
 
-func main() {
-	if len(os.Args) != 4 {
-		fmt.Printf("Usage: %v import-from-path staging-path collection-path\n", os.Args[0])
+// interruptibleContext returns a context that is cancelled when the process receives SIGINT or SIGTERM.
+// The running operation can then save its progress and stop. A second signal terminates the process immediately.
+func interruptibleContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "\nInterrupted, saving progress... Press Ctrl-C again to quit immediately.")
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		<-signals
 		os.Exit(1)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
 	}
-	baseFs := afero.NewOsFs()
+}
+
+// reportedError is an error that was already reported as an event, so it is not printed again
+type reportedError struct {
+	error
+}
 
-	importFs, stagingFs, collectionFs, err := initializeFolders(baseFs, os.Args[1], os.Args[2], os.Args[3])
+// printError prints the error that stopped CoBack with a hint how to continue
+func printError(err error) {
+	if unreadable, ok := errors.Cause(err).(*scan.UnreadableFilesError); ok {
+		fmt.Println("The following files could not be read, they were skipped:")
+		for _, e := range unreadable.Files {
+			fmt.Printf("    %v\n", e)
+		}
+		fmt.Println("Run again to retry reading them")
+		return
+	}
+	switch errors.Cause(err) {
+	case context.Canceled:
+		fmt.Println("CoBack was interrupted, run it again to continue where it stopped")
+	case scan.ErrCorruptedCatalog:
+		fmt.Printf("Failed to copy files: %v\n", err)
+		fmt.Println("If a file of the staging folder is named above, move it to the collection or delete it first.")
+		fmt.Println("Run again with -repair to rebuild the corrupted catalog")
+	default:
+		fmt.Printf("Failed to copy files: %v\n", err)
+	}
+}
+
+// importCommand runs the import and stage subcommands with the given command line arguments (without the
+// subcommand itself): it copies the new files of the import folder to the staging folder.
+func importCommand(ctx context.Context, name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	syncFlags := addSyncFlags(flags)
+	filters := addFilterFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "only print how many files would be copied to the staging folder and how many would be skipped, don't copy them")
+	jsonOutput := flags.Bool("json", false, "print JSON events, one per line, instead of text")
+	move := flags.Bool("move", false, "delete the files of the import folder once their copy in the staging folder is verified, and the ones already in the collection or deleted from it, the deleted files are listed in "+scan.AuditLogFileName+" in the staging folder")
+	stagingFlags := addStagingFlags(flags)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v %v [options] import-from-path staging-path collection-path\n", os.Args[0], name)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		flags.Usage()
+		return errors.New("The import, staging and collection folders must be specified")
+	}
+	filter, err := filters.filter()
 	if err != nil {
-		fmt.Printf("Cannot initialize folder: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	opts, cache, err := syncFlags.options()
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		opts = append(opts, scan.WithFilter(filter))
+	}
+	stagingMode, err := stagingFlags.stagingMode()
+	if err != nil {
+		return err
+	}
+	var reporter scan.Reporter
+	if *jsonOutput {
+		reporter = scan.NewJSONReporter(os.Stdout)
+	}
+	progress, err := syncFlags.progressSink(reporter)
+	if err != nil {
+		return err
+	}
+	importFs, stagingFs, collectionFs, err := initializeFolders(afero.NewOsFs(), flags.Arg(0), flags.Arg(1), flags.Arg(2))
+	if err != nil {
+		return errors.Wrapf(err, "Cannot initialize folder")
+	}
+	_, importName := filepath.Split(filepath.Clean(flags.Arg(0)))
+	if cache != nil {
+		// the checksums calculated before an error or an interruption are kept too
+		defer saveHashCache(cache)
+	}
+	runOpts := []runOption{withScanOptions(opts...), withProgress(progress), withStagingMode(stagingMode)}
+	if reporter != nil {
+		runOpts = append(runOpts, withReporter(reporter))
+	}
+	if *dryRun {
+		runOpts = append(runOpts, withDryRun())
+	}
+	if *move {
+		runOpts = append(runOpts, withMove())
+	}
+	err = runImport(ctx, importFs, importName, stagingFs, collectionFs, runOpts...)
+	if err != nil && reporter != nil {
+		reporter.Report(scan.Event{Type: scan.ErrorEvent, Error: err.Error()})
+		return reportedError{err}
+	}
+	return err
+}
+
+// stagingFlags are the command line flags of the commands that put files into the staging folder
+type stagingFlags struct {
+	modeName *string
+	hardlink *bool
+}
+
+// addStagingFlags registers the flags of staging files in the flag set
+func addStagingFlags(flags *flag.FlagSet) *stagingFlags {
+	return &stagingFlags{
+		modeName: flags.String("staging-mode", string(fsh.AutoMode), "how the files are put into the staging folder: copy, reflink (or clone), or auto to clone them if the file system supports it and copy them otherwise"),
+		hardlink: flags.Bool("hardlink", false, "put hard links to the files of the import folder into the staging folder instead of copies. The files of the import folder become shared with the staging folder (and later the collection): editing a staged file changes the file in the import folder, and linking changes the link count and the change time of the import files"),
+	}
+}
+
+// stagingMode returns the staging mode selected by the flags
+func (f *stagingFlags) stagingMode() (fsh.StagingMode, error) {
+	return parseStagingMode(*f.modeName, *f.hardlink)
+}
+
+// parseStagingMode returns the staging mode selected by the -staging-mode and -hardlink flags.
+// Hard links share the files of the import folder with the staging folder, so they must be asked for with -hardlink,
+// they cannot be selected with -staging-mode.
+func parseStagingMode(name string, hardlink bool) (fsh.StagingMode, error) {
+	mode, err := fsh.ParseStagingMode(name)
+	if err != nil {
+		return "", err
+	}
+	if mode == fsh.HardlinkMode {
+		return "", errors.New("Hard links share the files of the import folder with the staging folder, use -hardlink to allow it")
+	}
+	if hardlink {
+		if mode != fsh.AutoMode {
+			return "", errors.Errorf("-hardlink cannot be used with -staging-mode %v", name)
+		}
+		return fsh.HardlinkMode, nil
+	}
+	return mode, nil
+}
+
+// runImport runs the import with run, or with previewRun if it's a dry run.
+// Unless it's a dry run, a notice is left in the staging folder until the run finishes.
+func runImport(ctx context.Context, importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs, opts ...runOption) error {
+	if newRunOptions(opts).dryRun {
+		return previewRun(ctx, importFs, importName, stagingFs, collectionFs, opts...)
 	}
-	_, importName := filepath.Split(filepath.Clean(os.Args[1]))
-	// fmt.Printf("-------------------- importName = %v|%v\n", a, importName)
 
+	// The notice is only removed if the run finished, so it stays in place if CoBack was interrupted
 	noticeFs := afero.NewBasePathFs(stagingFs, importName)
 	createIncompleteRunNotice(noticeFs)
-	defer removeIncompleteRunNotice(noticeFs)
+	err := run(ctx, importFs, importName, stagingFs, collectionFs, opts...)
+	if _, ok := errors.Cause(err).(*scan.UnreadableFilesError); ok || err == nil {
+		// the run was finished, only some files may have been left out
+		removeIncompleteRunNotice(noticeFs)
+	}
+	return err
+}
 
-	err = run(importFs, importName, stagingFs, collectionFs)
-	if err != nil {
-		fmt.Printf("Failed to copy files: %v\n", err)
+func main() {
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	args := os.Args[1:]
+	if len(args) == 0 {
+		printUsage()
 		os.Exit(1)
 	}
-
+	if isHelp(args[0]) {
+		printUsage()
+		return
+	}
+	cmd, found := findCommand(args[0])
+	if found {
+		args = args[1:]
+	} else {
+		// without a subcommand the arguments are the same as the arguments of the import
+		cmd, _ = findCommand("import")
+	}
+	err := cmd.run(ctx, args)
+	if err == nil || err == flag.ErrHelp {
+		return
+	}
+	if _, reported := err.(reportedError); !reported {
+		cmd.printError(err)
+	}
+	// os.Exit skips the deferred functions
+	stop()
+	os.Exit(1)
 }
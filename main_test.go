@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"strings"
+	"path/filepath"
 	"testing"
 	"time"
-	// "github.com/mitro42/coback/catalog"
 
+	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
 	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
 	th "github.com/mitro42/testhelper"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
@@ -16,7 +20,7 @@ import (
 
 // General notes:
 // These tests cover complex end to end use cases. All of them contain multiple rounds of imports.
-// During all tests the import folders must not change, and the collection folder cannot be changed by run(), only by steps emulating user actions.
+// During all tests the import folders must not change, and the collection folder cannot be changed by run(context.Background(), ), only by steps emulating user actions.
 // Unless otherwise stated, all scenarios start from an empty collection and empty staging folder.
 // At the end of each scenario all the import folders must be reimported and the staging must stay empty.
 // Then catalogs in the import folders must be deleted and before doing another reimport of the folders.
@@ -152,7 +156,7 @@ func prepareTestFs(t *testing.T, folders ...string) (afero.Fs, error) {
 }
 
 // Counts the number of files in a fs and fails the test is the actual number of
-// the files differ from the expected number. Ignores files called coback.catalog.
+// the files differ from the expected number. Ignores coback.catalog, its backups, the run reports and the audit log.
 func expectFileCount(t *testing.T, fs afero.Fs, expected int) {
 	t.Helper()
 	actual := 0
@@ -164,7 +168,7 @@ func expectFileCount(t *testing.T, fs afero.Fs, expected int) {
 		if info.IsDir() {
 			return nil
 		}
-		if strings.HasSuffix(path, "coback.catalog") {
+		if name := filepath.Base(path); catalog.IsCatalogFile(name) || name == scan.ReportFileName || name == scan.AuditLogFileName {
 			return nil
 		}
 		actual++
@@ -262,6 +266,25 @@ func TestCreateIncompleteRunNotice(t *testing.T) {
 	expectFileMissing(t, fs, incompleteRunNoticeFileName)
 }
 
+func TestRunImportDryRun(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	var r eventRecorder
+	th.Ok(t, runImport(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withReporter(&r), withDryRun()))
+	expectFileCount(t, stagingFs, 0)
+	expectFileMissing(t, stagingFs, "folder1/"+incompleteRunNoticeFileName)
+	finished := r.ofType(scan.RunFinished)
+	th.Equals(t, 1, len(finished))
+	th.Equals(t, true, finished[0].Summary.(runReport).DryRun)
+
+	// the options are checked in a dry run too
+	err = runImport(context.Background(), import1Fs, "folder1", stagingFs, collectionFs,
+		withDryRun(), withMove(), withStagingMode(fsh.HardlinkMode))
+	th.NokPrefix(t, err, "Files cannot be removed from the import folder if they are staged as hard links")
+}
+
 func TestScenario1(t *testing.T) {
 	// Simple use case, multiple rounds of import with reimporting already seen files.
 	// Each of the following cases are present:
@@ -282,7 +305,7 @@ func TestScenario1(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder1Contents(t, import1Fs, ".")
 	expectFolder1Contents(t, stagingFs, "1_folder1")
@@ -294,7 +317,7 @@ func TestScenario1(t *testing.T) {
 
 	// 3
 	import2Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder2", "staging", "collection")
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder1Contents(t, collectionFs, ".")
 	expectFolder2Contents(t, import2Fs, ".")
@@ -308,12 +331,12 @@ func TestScenario1(t *testing.T) {
 	expectFileCount(t, stagingFs, 0)
 
 	// 5
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 
 	// 6
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 }
@@ -333,7 +356,7 @@ func TestScenario1(t *testing.T) {
 // 	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
 // 	th.Ok(t, err)
 // 	// 1
-// 	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+// 	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFolder1Contents(t, import1Fs, ".")
 // 	expectFolder1Contents(t, stagingFs, "1")
@@ -345,7 +368,7 @@ func TestScenario1(t *testing.T) {
 
 // 	// 3
 // 	import2Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder2", "staging", "collection")
-// 	err = run(import2Fs, "folder2",stagingFs, collectionFs)
+// 	err = run(context.Background(), import2Fs, "folder2",stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFolder1Contents(t, collectionFs, ".")
 // 	expectFolder2Contents(t, import2Fs, "")
@@ -358,12 +381,12 @@ func TestScenario1(t *testing.T) {
 // 	expectFileCount(t, stagingFs, 9)
 
 // 	// 5
-// 	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+// 	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFileCount(t, stagingFs, 9)
 
 // 	// 6
-// 	err = run(import2Fs, "folder2",stagingFs, collectionFs)
+// 	err = run(context.Background(), import2Fs, "folder2",stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFileCount(t, stagingFs, 9)
 
@@ -372,12 +395,12 @@ func TestScenario1(t *testing.T) {
 // 	stagingFs.RemoveAll("2")
 
 // 	// 8 - test reimporting of folder1
-// 	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+// 	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFileCount(t, stagingFs, 0)
 
 // 	// 9 - test reimporting of folder2
-// 	err = run(import2Fs, "folder2",stagingFs, collectionFs)
+// 	err = run(context.Background(), import2Fs, "folder2",stagingFs, collectionFs)
 // 	th.Ok(t, err)
 // 	expectFileCount(t, stagingFs, 0)
 // }
@@ -396,7 +419,7 @@ func TestScenario2(t *testing.T) {
 	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1/", "staging", "collection")
 	th.Ok(t, err)
 	// 1
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder1Contents(t, import1Fs, ".")
 	expectFolder1Contents(t, stagingFs, "1_folder1")
@@ -407,7 +430,7 @@ func TestScenario2(t *testing.T) {
 
 	// 3
 	import2Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder2", "staging", "collection")
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder2Contents(t, import2Fs, ".")
 	expectFileCount(t, stagingFs, 2)
@@ -419,12 +442,12 @@ func TestScenario2(t *testing.T) {
 	expectFileCount(t, stagingFs, 0)
 
 	// 5
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 
 	// 6
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 }
@@ -445,7 +468,7 @@ func TestScenario3(t *testing.T) {
 	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
 	th.Ok(t, err)
 	// 1
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder1Contents(t, import1Fs, ".")
 	expectFolder1Contents(t, stagingFs, "1_folder1")
@@ -460,7 +483,7 @@ func TestScenario3(t *testing.T) {
 
 	// 3
 	import2Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder2", "staging", "collection")
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder2Contents(t, import2Fs, ".")
 	expectFileCount(t, stagingFs, 2)
@@ -478,18 +501,18 @@ func TestScenario3(t *testing.T) {
 	collectionFs.Remove("funny/tom.jpg")
 
 	// 6
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 
 	// 7
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 
 	// 8
 	import3Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder3", "staging", "collection")
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, stagingFs, 0)
 }
@@ -517,7 +540,7 @@ func TestScenario4(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder3Contents(t, stagingFs, "1_folder3")
 
@@ -533,7 +556,7 @@ func TestScenario4(t *testing.T) {
 	th.Ok(t, err)
 
 	// 4
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileMissing(t, stagingFs, "1_folder1/friends/kara.jpg")
 
@@ -542,7 +565,7 @@ func TestScenario4(t *testing.T) {
 	th.Ok(t, err)
 
 	// 6
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileMissing(t, stagingFs, "2_folder2/friends/tom.jpg")
 
@@ -551,7 +574,7 @@ func TestScenario4(t *testing.T) {
 	th.Ok(t, err)
 
 	// 8
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileMissing(t, stagingFs, "2_folder2/friends/tom.jpg")
 	expectFileMissing(t, stagingFs, "3_folder2/friends/tom.jpg")
@@ -581,7 +604,7 @@ func TestScenario4D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder3Contents(t, stagingFs, "1_folder3")
 
@@ -601,7 +624,7 @@ func TestScenario4D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 4
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder1"), 2)
 	expectFile(t, stagingFs, "1_folder1/friends/kara.jpg")
@@ -618,7 +641,7 @@ func TestScenario4D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 6
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder2"), 1)
 	expectFile(t, stagingFs, "2_folder2/friends/jerry.jpg")
@@ -628,7 +651,7 @@ func TestScenario4D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 8
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "3_folder2"), 0)
 }
@@ -657,7 +680,7 @@ func TestScenario5(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder3Contents(t, stagingFs, "1_folder3")
 
@@ -670,7 +693,7 @@ func TestScenario5(t *testing.T) {
 	th.Ok(t, err)
 
 	// 4
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFile(t, stagingFs, "2_folder2/friends/jerry.jpg")
 	expectFileMissing(t, stagingFs, "2_folder2/friends/tom.jpg")
@@ -685,7 +708,7 @@ func TestScenario5(t *testing.T) {
 	th.Ok(t, err)
 
 	// 7
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileMissing(t, stagingFs, "3_folder1/family/dad.jpg")
 }
@@ -717,7 +740,7 @@ func TestScenario5D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder3Contents(t, stagingFs, "1_folder3")
 
@@ -732,7 +755,7 @@ func TestScenario5D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 4
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileMissing(t, stagingFs, "2_folder2/friends/markus.jpg")
 	expectFileMissing(t, stagingFs, "2_folder2/family/mom.jpg")
@@ -748,7 +771,7 @@ func TestScenario5D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 6
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "3_folder2"), 0)
 
@@ -757,7 +780,7 @@ func TestScenario5D(t *testing.T) {
 	th.Ok(t, err)
 
 	// 8
-	err = run(import2Fs, "folder2", stagingFs, collectionFs)
+	err = run(context.Background(), import2Fs, "folder2", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "3_folder2"), 0)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "4_folder2"), 0)
@@ -781,12 +804,12 @@ func TestScenario6(t *testing.T) {
 	th.Ok(t, err)
 
 	// 1
-	err = run(import4Fs, "folder4", stagingFs, collectionFs)
+	err = run(context.Background(), import4Fs, "folder4", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder4Contents(t, stagingFs, "1_folder4")
 
 	// 2
-	err = run(import4Fs, "folder4", stagingFs, collectionFs)
+	err = run(context.Background(), import4Fs, "folder4", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder4"), 0)
 
@@ -802,7 +825,7 @@ func TestScenario6(t *testing.T) {
 	stagingFs.Remove("coback.catalog")
 
 	// 5
-	err = run(import4Fs, "folder4", stagingFs, collectionFs)
+	err = run(context.Background(), import4Fs, "folder4", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder4"), 2)
 	expectFile(t, stagingFs, "1_folder4/holiday/public/view2.jpg")
@@ -832,12 +855,12 @@ func TestScenario7(t *testing.T) {
 	expectFolder3Contents(t, collectionFs, ".")
 
 	// 2
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder3"), 0)
 
 	// 3
-	err = run(import1Fs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder1"), 2)
 	expectFile(t, stagingFs, "2_folder1/family/dad.jpg")
@@ -855,7 +878,7 @@ func TestScenario7(t *testing.T) {
 	expectFolder4Contents(t, collectionFs, ".")
 
 	// 6
-	err = run(import4Fs, "folder4", stagingFs, collectionFs)
+	err = run(context.Background(), import4Fs, "folder4", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder4"), 0)
 }
@@ -876,7 +899,7 @@ func TestScenario8(t *testing.T) {
 	th.Ok(t, err)
 
 	// 2
-	err = run(importFs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), importFs, "folder1", stagingFs, collectionFs)
 	th.NokPrefix(t, err, "Staging folder is not empty and doesn't have a catalog")
 
 	// 3
@@ -888,7 +911,7 @@ func TestScenario8(t *testing.T) {
 	th.Ok(t, err)
 
 	// 4
-	err = run(importFs, "folder1", stagingFs, collectionFs)
+	err = run(context.Background(), importFs, "folder1", stagingFs, collectionFs)
 	th.Nok(t, err, "coback.catalog is a folder")
 }
 
@@ -915,7 +938,7 @@ func TestScenario9(t *testing.T) {
 	import3Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder3", "staging", "collection")
 	th.Ok(t, err)
 
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFolder3Contents(t, stagingFs, "1_folder3")
 
@@ -924,7 +947,7 @@ func TestScenario9(t *testing.T) {
 	th.Ok(t, err)
 
 	// 3
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder3"), 1)
 	expectFile(t, stagingFs, "2_folder3/dad.jpg")
@@ -934,7 +957,7 @@ func TestScenario9(t *testing.T) {
 	th.Ok(t, err)
 
 	// 5
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "3_folder3"), 0)
 
@@ -945,7 +968,7 @@ func TestScenario9(t *testing.T) {
 	th.Ok(t, err)
 
 	// 7
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "4_folder3"), 1)
 	expectFile(t, stagingFs, "4_folder3/buddies/kara.jpg")
@@ -955,11 +978,176 @@ func TestScenario9(t *testing.T) {
 	th.Ok(t, err)
 
 	// 9
-	err = run(import3Fs, "folder3", stagingFs, collectionFs)
+	err = run(context.Background(), import3Fs, "folder3", stagingFs, collectionFs)
 	th.Ok(t, err)
 	expectFileCount(t, afero.NewBasePathFs(stagingFs, "5_folder3"), 0)
 }
 
+// Reads the catalog of a folder and fails the test if its state is not the expected one.
+func expectCatalogState(t *testing.T, fs afero.Fs, expected catalog.State) {
+	t.Helper()
+	c, err := catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, expected, c.State())
+}
+
+func TestImportCatalogStates(t *testing.T) {
+	// The import catalog follows the progress of the import.
+	// 1. Import folder1 - the catalog is copied
+	// 2. Import folder1 again - nothing is staged, but the files are not yet in the collection: still copied
+	// 3. Move all files from staging to collection (user action)
+	// 4. Import folder1 again - the catalog is done
+
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	// 1
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectCatalogState(t, import1Fs, catalog.Copied)
+	expectCatalogState(t, stagingFs, catalog.Initialized)
+	expectCatalogState(t, collectionFs, catalog.Initialized)
+
+	// 2
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectCatalogState(t, import1Fs, catalog.Copied)
+
+	// 3 (user action)
+	err = moveFolder(stagingFs, "1_folder1", collectionFs, ".")
+	th.Ok(t, err)
+
+	// 4
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectCatalogState(t, import1Fs, catalog.Done)
+	expectFileCount(t, stagingFs, 0)
+}
+
+func TestResumeInterruptedCopy(t *testing.T) {
+	// A previous import was interrupted while copying the files, one file is copied, two are only partially copied.
+	// The next import must continue in the same staging folder and replace the partial copies.
+
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	importCatalog, err := scan.SyncCatalogWithImportFolder(context.Background(), import1Fs)
+	th.Ok(t, err)
+	importCatalog.SetState(catalog.Copying)
+	th.Ok(t, importCatalog.Write(import1Fs))
+	th.Ok(t, catalog.NewCatalog().Write(stagingFs))
+	err = copyFileWithTimestampsTo(import1Fs, "family/mom.jpg", stagingFs, "1_folder1/family/mom.jpg")
+	th.Ok(t, err)
+	content, err := afero.ReadFile(import1Fs, "family/dad.jpg")
+	th.Ok(t, err)
+	err = afero.WriteFile(stagingFs, "1_folder1/family/dad.jpg", content[:len(content)/2], 0644)
+	th.Ok(t, err)
+	// the copy of an interrupted run may also be left behind under its temporary name
+	err = afero.WriteFile(stagingFs, "1_folder1/family/.sis.jpg"+fsh.PartialCopySuffix, content[:len(content)/2], 0644)
+	th.Ok(t, err)
+
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFolder1Contents(t, stagingFs, "1_folder1")
+	expectFileMissing(t, stagingFs, "2_folder1")
+	expectFileMissing(t, stagingFs, "1_folder1/family/.sis.jpg"+fsh.PartialCopySuffix)
+	staged, err := afero.ReadFile(stagingFs, "1_folder1/family/dad.jpg")
+	th.Ok(t, err)
+	th.Equals(t, content, staged)
+	expectCatalogState(t, import1Fs, catalog.Copied)
+}
+
+func TestDeletedFromStagingTombstone(t *testing.T) {
+	// Files deleted from the staging folder are recorded in the collection with the details of the deleted file
+	// 1. Import folder1
+	// 2. Delete family/mom.jpg from staging (user action)
+	// 3. Import folder1 again, check the tombstone in the collection catalog
+
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	// 1
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	item, err := catalog.NewItem(stagingFs, "1_folder1/family/mom.jpg")
+	th.Ok(t, err)
+
+	// 2 (user action)
+	err = stagingFs.Remove("1_folder1/family/mom.jpg")
+	th.Ok(t, err)
+
+	// 3
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder1"), 0)
+	collectionCatalog, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	tombstone, err := collectionCatalog.Tombstone(item.Checksum)
+	th.Ok(t, err)
+	th.Equals(t, []string{"1_folder1/family/mom.jpg"}, tombstone.Paths)
+	th.Equals(t, item.Size, tombstone.Size)
+	th.Equals(t, "1_folder1", tombstone.ImportFolder)
+	stagingCatalog, err := catalog.Read(stagingFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 0, stagingCatalog.DeletedCount())
+}
+
+func TestStagingConflictNeedsRepair(t *testing.T) {
+	// A staged file that is also in the collection makes the staging catalog corrupted
+	// 1. Import folder1
+	// 2. Delete funny.png from staging and copy the family folder to the collection instead of moving it (user action)
+	// 3. Import folder1 again, the staging catalog is marked as corrupted
+	// 4. Import folder1 again, the corrupted catalog is refused
+	// 5. Remove the copies from staging (user action) and import with -repair, the deleted file is not forgotten
+
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	// 1
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+
+	// 2 (user action)
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+	th.Ok(t, copyFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+
+	// 3
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.NokPrefix(t, err, "Cannot sync folder contents: File is already in the collection: 1_folder1/family/")
+	th.Equals(t, scan.ErrCorruptedCatalog, errors.Cause(err))
+	expectCatalogState(t, stagingFs, catalog.Corrupted)
+
+	// 4
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.NokPrefix(t, err, "Cannot sync folder contents: Cannot use the catalog of the staging folder")
+	th.Equals(t, scan.ErrCorruptedCatalog, errors.Cause(err))
+
+	// 5
+	th.Ok(t, stagingFs.RemoveAll("1_folder1/family"))
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withScanOptions(scan.WithRepair())))
+	expectCatalogState(t, stagingFs, catalog.Initialized)
+	expectFileCount(t, stagingFs, 3)
+	collectionCatalog, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	funny, err := catalog.NewItem(import1Fs, "funny.png")
+	th.Ok(t, err)
+	th.Equals(t, true, collectionCatalog.IsDeletedChecksum(funny.Checksum))
+}
+
+func TestWithImportFolder(t *testing.T) {
+	th.Equals(t, "", withImportFolder(catalog.Tombstone{}).ImportFolder)
+	th.Equals(t, "", withImportFolder(catalog.Tombstone{Paths: []string{"m.jpg"}}).ImportFolder)
+	th.Equals(t, "2_photos", withImportFolder(catalog.Tombstone{Paths: []string{"2_photos/a/m.jpg", "3_other/m.jpg"}}).ImportFolder)
+	th.Equals(t, "x", withImportFolder(catalog.Tombstone{Paths: []string{"2_photos/m.jpg"}, ImportFolder: "x"}).ImportFolder)
+}
+
 // File edited, to have new unique content while keeping the same name.
 // edited in collection
 // - file is overwritten with new content (white balance change, old image is discarded)
@@ -995,3 +1183,99 @@ func TestScenario9(t *testing.T) {
 // Quick scans
 
 // Forced deep scans (?)
+
+func TestStageFilesCancelled(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, _, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	importCatalog, err := scan.SyncCatalogWithImportFolder(context.Background(), import1Fs)
+	th.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	staged, err := stageFiles(ctx, import1Fs, "1_folder1", importCatalog, stagingFs, newRunOptions(nil))
+	th.Equals(t, context.Canceled, err)
+	th.Equals(t, fileCount{}, staged)
+	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder1"), 0)
+}
+
+func TestRunHardlinkMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coback")
+	th.Ok(t, err)
+	defer os.RemoveAll(dir)
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	th.Ok(t, copyTestData("folder1", fs))
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	importCatalog, err := scan.Scan(context.Background(), import1Fs, scan.WithProgress(scan.NoProgress()))
+	th.Ok(t, err)
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withStagingMode(fsh.HardlinkMode)))
+	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder1"), 7)
+	for item := range importCatalog.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		importInfo, err := import1Fs.Stat(item.Path)
+		th.Ok(t, err)
+		stagedInfo, err := stagingFs.Stat(filepath.Join("1_folder1", item.Path))
+		th.Ok(t, err)
+		th.Assert(t, os.SameFile(importInfo, stagedInfo), "%v is not linked", item.Path)
+		// the content and the modification time of the files of the import folder are not changed
+		current, err := catalog.NewItem(import1Fs, item.Path)
+		th.Ok(t, err)
+		th.Equals(t, item, *current)
+	}
+
+	// in memory the files cannot be linked
+	memFs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err = initializeFolders(memFs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withStagingMode(fsh.HardlinkMode))
+	th.NokPrefix(t, err, "Failed to copy files: Cannot hardlink")
+}
+
+func TestRunCancelled(t *testing.T) {
+	// An interrupted run leaves nothing staged, the next run does the whole import
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = run(ctx, import1Fs, "folder1", stagingFs, collectionFs)
+	th.Equals(t, context.Canceled, errors.Cause(err))
+	expectFileCount(t, stagingFs, 0)
+
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFolder1Contents(t, stagingFs, "1_folder1")
+	expectCatalogState(t, import1Fs, catalog.Copied)
+}
+
+func TestRunUnreadableImportFile(t *testing.T) {
+	// A file of the import folder cannot be read, the others are imported and the file is reported.
+	// The next run, when the file can be read again, imports it too.
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	err = run(context.Background(), cth.NewUnreadableFs(import1Fs, "family/mom.jpg"), "folder1", stagingFs, collectionFs)
+	unreadable, ok := errors.Cause(err).(*scan.UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, 1, len(unreadable.Files))
+	th.Equals(t, "import", unreadable.Files[0].Folder)
+	th.Equals(t, "family/mom.jpg", unreadable.Files[0].Path)
+	expectFileCount(t, afero.NewBasePathFs(stagingFs, "1_folder1"), 6)
+	expectFileMissing(t, stagingFs, "1_folder1/family/mom.jpg")
+	expectCatalogState(t, import1Fs, catalog.Copied)
+
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFile(t, stagingFs, "2_folder1/family/mom.jpg")
+	expectFileCount(t, afero.NewBasePathFs(stagingFs, "2_folder1"), 1)
+}
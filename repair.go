@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// importFolder is a folder that was imported to the collection earlier, with its catalog
+type importFolder struct {
+	path    string
+	fs      afero.Fs
+	catalog catalog.Catalog
+}
+
+// healthyCopy is a file in an import folder that has the original content of a corrupted file of the collection
+type healthyCopy struct {
+	corrupted catalog.Item
+	folder    importFolder
+	path      string
+}
+
+// readImportFolders reads the catalogs of the import folders
+func readImportFolders(baseFs afero.Fs, paths []string) ([]importFolder, error) {
+	folders := make([]importFolder, 0, len(paths))
+	for _, path := range paths {
+		fs := afero.NewBasePathFs(baseFs, path)
+		c, err := readCatalog(fs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot read the catalog of the import folder '%v'", path)
+		}
+		folders = append(folders, importFolder{path: path, fs: fs, catalog: c})
+	}
+	return folders, nil
+}
+
+// corruptedItems returns the items of the catalog that were found corrupted by the verification, in alphabetical order
+func corruptedItems(c catalog.Catalog) []catalog.Item {
+	ret := make([]catalog.Item, 0)
+	for item := range c.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		if item.Corrupted {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// findHealthyCopy searches the import folders for a file with the original checksum of the corrupted item.
+// The candidates are read again before they are accepted, because they could have rotted too since they were imported.
+// Import folders whose catalog uses a different hash algorithm are skipped.
+func findHealthyCopy(item catalog.Item, alg catalog.HashAlgorithm, folders []importFolder) (healthyCopy, bool) {
+	for _, folder := range folders {
+		if folder.catalog.HashAlgorithm() != alg {
+			continue
+		}
+		candidates, err := folder.catalog.ItemsByChecksum(item.Checksum)
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			current, err := catalog.NewItemWithAlgorithm(folder.fs, candidate.Path, alg)
+			if err == nil && current.Checksum == item.Checksum {
+				return healthyCopy{corrupted: item, folder: folder, path: candidate.Path}, true
+			}
+		}
+	}
+	return healthyCopy{}, false
+}
+
+// restoreCopy copies the healthy copy over the corrupted file, keeping the original modification time.
+// The corrupted file is only replaced once the copy has the original checksum, then the restored file is read
+// to make sure it has the original content.
+// Returns error without copying if the corrupted file was edited since the verification.
+func restoreCopy(collectionFs afero.Fs, alg catalog.HashAlgorithm, healthy healthyCopy) error {
+	item := healthy.corrupted
+	fi, err := collectionFs.Stat(item.Path)
+	if err != nil {
+		return errors.Wrap(err, "Cannot get file info")
+	}
+	if fi.Size() != item.Size || fi.ModTime().Format(time.RFC3339Nano) != item.ModificationTime {
+		return errors.New("The file was modified since the verification")
+	}
+	if err := fsh.CopyFileVerified(healthy.folder.fs, healthy.path, item.ModificationTime, collectionFs, item.Path, expectedContent(item)); err != nil {
+		return err
+	}
+	restored, err := catalog.NewItemWithAlgorithm(collectionFs, item.Path, alg)
+	if err != nil {
+		return err
+	}
+	if !restored.SameContent(item) {
+		return errors.Errorf("The restored file '%v' doesn't match the catalog", item.Path)
+	}
+	return nil
+}
+
+// noHealthyCopy is the reason reported for the corrupted files that cannot be restored
+const noHealthyCopy = "no_healthy_copy"
+
+// repairReport is the summary of a repair
+type repairReport struct {
+	DryRun   bool     `json:"dry_run,omitempty"`
+	Restored []string `json:"restored"`
+	Missing  []string `json:"missing"`
+}
+
+// repairCorrupted restores the files of the collection marked as corrupted from healthy copies in the import folders.
+// The planned restorations and the files that have no healthy copy are reported to r first. If dryRun is true,
+// nothing else is done. Otherwise the files are restored, reported to r, and they are marked as verified in the
+// catalog of the collection. A repairReport is reported at the end, unless the repair failed.
+// Stops before the next file and returns the error of the context if it is cancelled, the catalog is saved with the
+// files restored so far.
+// Returns the paths of the restored files and the paths of the corrupted files that have no healthy copy.
+func repairCorrupted(ctx context.Context, collectionFs afero.Fs, folders []importFolder, dryRun bool, r scan.Reporter) (restored []string, missing []string, err error) {
+	collectionCatalog, err := readCatalog(collectionFs)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Cannot read the catalog of the collection")
+	}
+	alg := collectionCatalog.HashAlgorithm()
+	var copies []healthyCopy
+	for _, item := range corruptedItems(collectionCatalog) {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		healthy, found := findHealthyCopy(item, alg, folders)
+		if !found {
+			r.Report(scan.Event{Type: scan.FileSkipped, Phase: "repair", Path: item.Path, Reason: noHealthyCopy,
+				Message: fmt.Sprintf("No healthy copy found: %v", item.Path)})
+			missing = append(missing, item.Path)
+			continue
+		}
+		source := filepath.Join(healthy.folder.path, healthy.path)
+		r.Report(scan.Event{Type: scan.RestorePlanned, Phase: "repair", Path: item.Path, Target: source,
+			Message: fmt.Sprintf("%v <-- %v", item.Path, source)})
+		copies = append(copies, healthy)
+	}
+	if dryRun {
+		reportRepair(r, repairReport{DryRun: true, Missing: missing})
+		return nil, missing, nil
+	}
+
+	for _, healthy := range copies {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		if err = restoreCopy(collectionFs, alg, healthy); err != nil {
+			err = errors.Wrapf(err, "Failed to restore file '%v'", healthy.corrupted.Path)
+			break
+		}
+		item := healthy.corrupted
+		item.Corrupted = false
+		item.VerificationTime = time.Now().Format(time.RFC3339Nano)
+		collectionCatalog.Set(item)
+		restored = append(restored, item.Path)
+		r.Report(scan.Event{Type: scan.FileRestored, Phase: "repair", Path: item.Path,
+			Target: filepath.Join(healthy.folder.path, healthy.path)})
+	}
+	if len(restored) > 0 {
+		if writeErr := collectionCatalog.Write(collectionFs); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err == nil {
+		reportRepair(r, repairReport{Restored: restored, Missing: missing})
+	}
+	return restored, missing, err
+}
+
+// reportRepair reports the summary of the repair with its human readable form as the message
+func reportRepair(r scan.Reporter, report repairReport) {
+	msg := fmt.Sprintf("%v file(s) restored, %v file(s) have no healthy copy", len(report.Restored), len(report.Missing))
+	if report.DryRun {
+		msg = fmt.Sprintf("Dry run, nothing was restored. %v file(s) have no healthy copy", len(report.Missing))
+	}
+	r.Report(scan.Event{Type: scan.RunFinished, Phase: "repair", Summary: report, Message: msg})
+}
+
+// repairCommand runs the repair subcommand with the given command line arguments (without the subcommand itself)
+func repairCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the files that would be restored, don't change anything")
+	jsonOutput := flags.Bool("json", false, "print JSON events, one per line, instead of text")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v repair [options] collection-path import-from-path...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return errors.New("The collection and at least one import folder must be specified")
+	}
+	baseFs := afero.NewOsFs()
+	collectionFs, err := openFolder(baseFs, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	folders, err := readImportFolders(baseFs, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	reporter := scan.NewTextReporter(os.Stdout)
+	if *jsonOutput {
+		reporter = scan.NewJSONReporter(os.Stdout)
+	}
+	_, missing, err := repairCorrupted(ctx, collectionFs, folders, *dryRun, reporter)
+	if err == nil && !*dryRun && len(missing) > 0 {
+		err = errors.Errorf("%v corrupted file(s) could not be restored", len(missing))
+	}
+	if err != nil && *jsonOutput {
+		reporter.Report(scan.Event{Type: scan.ErrorEvent, Error: err.Error()})
+		return reportedError{err}
+	}
+	return err
+}
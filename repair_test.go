@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+// rotFile overwrites the file with the content without changing its modification time, like bit rot does
+func rotFile(t *testing.T, fs afero.Fs, path string, content string) {
+	t.Helper()
+	fi, err := fs.Stat(path)
+	th.Ok(t, err)
+	modTime := fi.ModTime()
+	th.Ok(t, afero.WriteFile(fs, path, []byte(content), 0644))
+	th.Ok(t, fs.Chtimes(path, modTime, modTime))
+}
+
+func TestRepairCorrupted(t *testing.T) {
+	baseFs := afero.NewMemMapFs()
+	collectionFs := afero.NewBasePathFs(baseFs, "collection")
+	th.Ok(t, afero.WriteFile(collectionFs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(collectionFs, "b.txt", []byte("content b"), 0644))
+	th.Ok(t, afero.WriteFile(collectionFs, "c.txt", []byte("content c"), 0644))
+	_, err := scan.Scan(context.Background(), collectionFs)
+	th.Ok(t, err)
+
+	// the import folders have copies of a.txt and b.txt, the first copy of a.txt rotted too
+	importFs1 := afero.NewBasePathFs(baseFs, "import1")
+	th.Ok(t, fsh.CopyFileTo(collectionFs, "a.txt", "2019-03-10T12:00:00Z", importFs1, "photos/a.txt"))
+	_, err = scan.Scan(context.Background(), importFs1)
+	th.Ok(t, err)
+	rotFile(t, importFs1, "photos/a.txt", "content A")
+	importFs2 := afero.NewBasePathFs(baseFs, "import2")
+	th.Ok(t, fsh.CopyFileTo(collectionFs, "a.txt", "2019-03-10T12:00:00Z", importFs2, "a_copy.txt"))
+	th.Ok(t, fsh.CopyFileTo(collectionFs, "b.txt", "2019-03-10T12:00:00Z", importFs2, "b.txt"))
+	_, err = scan.Scan(context.Background(), importFs2)
+	th.Ok(t, err)
+
+	rotFile(t, collectionFs, "a.txt", "content x")
+	rotFile(t, collectionFs, "c.txt", "content y")
+	_, err = verify(context.Background(), collectionFs, 100)
+	th.NokPrefix(t, err, "2 file(s) may be corrupted")
+
+	folders, err := readImportFolders(baseFs, []string{"import1", "import2"})
+	th.Ok(t, err)
+	var r eventRecorder
+	restored, missing, err := repairCorrupted(context.Background(), collectionFs, folders, true, &r)
+	th.Ok(t, err)
+	th.Equals(t, []string(nil), restored)
+	th.Equals(t, []string{"c.txt"}, missing)
+	planned := r.ofType(scan.RestorePlanned)
+	th.Equals(t, 1, len(planned))
+	th.Equals(t, "a.txt", planned[0].Path)
+	th.Equals(t, "import2/a_copy.txt", planned[0].Target)
+	skipped := r.ofType(scan.FileSkipped)
+	th.Equals(t, 1, len(skipped))
+	th.Equals(t, "c.txt", skipped[0].Path)
+	th.Equals(t, noHealthyCopy, skipped[0].Reason)
+	th.Equals(t, 0, len(r.ofType(scan.FileRestored)))
+	finished := r.ofType(scan.RunFinished)
+	th.Equals(t, 1, len(finished))
+	th.Equals(t, repairReport{DryRun: true, Missing: []string{"c.txt"}}, finished[0].Summary)
+	content, err := afero.ReadFile(collectionFs, "a.txt")
+	th.Ok(t, err)
+	th.Equals(t, "content x", string(content))
+
+	r = eventRecorder{}
+	restored, missing, err = repairCorrupted(context.Background(), collectionFs, folders, false, &r)
+	th.Ok(t, err)
+	th.Equals(t, []string{"a.txt"}, restored)
+	th.Equals(t, []string{"c.txt"}, missing)
+	restoredEvents := r.ofType(scan.FileRestored)
+	th.Equals(t, 1, len(restoredEvents))
+	th.Equals(t, "a.txt", restoredEvents[0].Path)
+	th.Equals(t, "import2/a_copy.txt", restoredEvents[0].Target)
+	finished = r.ofType(scan.RunFinished)
+	th.Equals(t, 1, len(finished))
+	th.Equals(t, repairReport{Restored: []string{"a.txt"}, Missing: []string{"c.txt"}}, finished[0].Summary)
+	content, err = afero.ReadFile(collectionFs, "a.txt")
+	th.Ok(t, err)
+	th.Equals(t, "content a", string(content))
+
+	c, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	item, err := c.Item("a.txt")
+	th.Ok(t, err)
+	th.Equals(t, false, item.Corrupted)
+	item, err = c.Item("c.txt")
+	th.Ok(t, err)
+	th.Equals(t, true, item.Corrupted)
+
+	result, err := verify(context.Background(), collectionFs, 100)
+	th.NokPrefix(t, err, "1 file(s) may be corrupted")
+	th.Equals(t, []string{"a.txt", "b.txt"}, result.Ok)
+}
+
+func TestRepairSkipsModifiedFiles(t *testing.T) {
+	baseFs := afero.NewMemMapFs()
+	collectionFs := afero.NewBasePathFs(baseFs, "collection")
+	th.Ok(t, afero.WriteFile(collectionFs, "a.txt", []byte("content a"), 0644))
+	_, err := scan.Scan(context.Background(), collectionFs)
+	th.Ok(t, err)
+	importFs := afero.NewBasePathFs(baseFs, "import")
+	th.Ok(t, fsh.CopyFile(collectionFs, "a.txt", "2019-03-10T12:00:00Z", importFs))
+	_, err = scan.Scan(context.Background(), importFs)
+	th.Ok(t, err)
+	rotFile(t, collectionFs, "a.txt", "content x")
+	_, err = verify(context.Background(), collectionFs, 100)
+	th.NokPrefix(t, err, "1 file(s) may be corrupted")
+
+	th.Ok(t, afero.WriteFile(collectionFs, "a.txt", []byte("edited content"), 0644))
+	folders, err := readImportFolders(baseFs, []string{"import"})
+	th.Ok(t, err)
+	var r eventRecorder
+	_, _, err = repairCorrupted(context.Background(), collectionFs, folders, false, &r)
+	th.NokPrefix(t, err, "Failed to restore file 'a.txt': The file was modified since the verification")
+	th.Equals(t, 0, len(r.ofType(scan.FileRestored)))
+	th.Equals(t, 0, len(r.ofType(scan.RunFinished)))
+	content, err := afero.ReadFile(collectionFs, "a.txt")
+	th.Ok(t, err)
+	th.Equals(t, "edited content", string(content))
+}
+
+func TestRepairCommandArguments(t *testing.T) {
+	th.NokPrefix(t, repairCommand(context.Background(), []string{"collection"}), "The collection and at least one import folder must be specified")
+	_, err := readImportFolders(afero.NewMemMapFs(), []string{"no_such_folder"})
+	th.NokPrefix(t, err, "Cannot read the catalog of the import folder 'no_such_folder'")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/mitro42/coback/scan"
+	"github.com/spf13/afero"
+)
+
+// runReport is the summary of an import for scripts. It is written as JSON into the folder of the staging folder
+// the files were copied to, and sent to the reporter at the end of the run.
+type runReport struct {
+	ImportFolder string `json:"import_folder"`
+	// StagingFolder is the folder in the staging folder the files were copied to, empty in a dry run
+	StagingFolder string    `json:"staging_folder,omitempty"`
+	DryRun        bool      `json:"dry_run,omitempty"`
+	Started       time.Time `json:"started"`
+	Finished      time.Time `json:"finished"`
+	// ToStage are the new files of the import folder, Staged are the ones actually copied
+	ToStage      fileCount `json:"to_stage"`
+	Staged       fileCount `json:"staged"`
+	InCollection fileCount `json:"skipped_in_collection"`
+	InStaging    fileCount `json:"skipped_in_staging"`
+	Deleted      fileCount `json:"skipped_deleted"`
+	// Removed are the files deleted from the import folder in move mode
+	Removed fileCount `json:"removed_from_import"`
+	// Unreadable lists the files of the three folders that could not be read
+	Unreadable []string `json:"unreadable,omitempty"`
+	// Error is the error that stopped the run
+	Error string `json:"error,omitempty"`
+}
+
+// newRunReport returns the report of an import with the files counted by the staging summary
+func newRunReport(importName string, stagingFolder string, summary stagingSummary, started time.Time) runReport {
+	return runReport{
+		ImportFolder:  importName,
+		StagingFolder: stagingFolder,
+		Started:       started,
+		ToStage:       summary.toStage,
+		InCollection:  summary.inCollection,
+		InStaging:     summary.inStaging,
+		Deleted:       summary.rejected,
+	}
+}
+
+// finish sets the end of the run, the files that couldn't be read and the error the run returns
+func (r *runReport) finish(unreadable []scan.FileError, err error) {
+	r.Finished = time.Now()
+	r.Unreadable = nil
+	for _, e := range unreadable {
+		r.Unreadable = append(r.Unreadable, e.Error())
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// writeRunReport saves the report into the staging folder the files were copied to.
+// An earlier report of an interrupted copy to the same folder is overwritten.
+func writeRunReport(stagingFs afero.Fs, report runReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(stagingFs, filepath.Join(report.StagingFolder, scan.ReportFileName), data, 0644)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+// eventRecorder is a Reporter that keeps the events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []scan.Event
+}
+
+func (r *eventRecorder) Report(e scan.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// ofType returns the recorded events of the given type
+func (r *eventRecorder) ofType(t scan.EventType) []scan.Event {
+	var ret []scan.Event
+	for _, e := range r.events {
+		if e.Type == t {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// readRunReport reads the report of a run from the staging folder
+func readRunReport(t *testing.T, stagingFs afero.Fs, folder string) runReport {
+	t.Helper()
+	data, err := afero.ReadFile(stagingFs, folder+"/"+scan.ReportFileName)
+	th.Ok(t, err)
+	var ret runReport
+	th.Ok(t, json.Unmarshal(data, &ret))
+	return ret
+}
+
+func TestRunReportsEvents(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	var r eventRecorder
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withReporter(&r)))
+	staged := r.ofType(scan.FileStaged)
+	th.Equals(t, 7, len(staged))
+	for _, e := range staged {
+		th.Equals(t, "1_folder1/"+e.Path, e.Target)
+	}
+	th.Equals(t, 0, len(r.ofType(scan.FileSkipped)))
+	phases := []string{}
+	for _, e := range r.ofType(scan.PhaseStarted) {
+		phases = append(phases, e.Phase)
+	}
+	th.Equals(t, []string{"collection", "import", "staging", "stage", "staging"}, phases)
+	last := r.events[len(r.events)-1]
+	th.Equals(t, scan.RunFinished, last.Type)
+	report := last.Summary.(runReport)
+	th.Equals(t, 7, report.Staged.files)
+	th.Equals(t, report.ToStage, report.Staged)
+
+	// the files moved to the collection and the deleted ones are skipped by the next import
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+	r = eventRecorder{}
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withReporter(&r)))
+	th.Equals(t, 0, len(r.ofType(scan.FileStaged)))
+	reasons := map[string]string{}
+	for _, e := range r.ofType(scan.FileSkipped) {
+		reasons[e.Path] = e.Reason
+	}
+	th.Equals(t, 7, len(reasons))
+	th.Equals(t, skipInCollection, reasons["family/mom.jpg"])
+	th.Equals(t, skipInStaging, reasons["friends/kara.jpg"])
+	th.Equals(t, skipDeleted, reasons["funny.png"])
+}
+
+func TestRunWritesReport(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	report := readRunReport(t, stagingFs, "1_folder1")
+	th.Equals(t, "folder1", report.ImportFolder)
+	th.Equals(t, "1_folder1", report.StagingFolder)
+	th.Equals(t, 7, report.ToStage.files)
+	th.Equals(t, report.ToStage, report.Staged)
+	th.Equals(t, "", report.Error)
+	th.Assert(t, !report.Finished.Before(report.Started), "finished before started: %v", report)
+
+	// the report is not part of the staging folder
+	stagingCatalog, err := catalog.Read(stagingFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 7, stagingCatalog.Count())
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	stagingCatalog, err = catalog.Read(stagingFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 7, stagingCatalog.Count())
+	report = readRunReport(t, stagingFs, "2_folder1")
+	th.Equals(t, fileCount{}, report.Staged)
+	th.Equals(t, 7, report.InStaging.files)
+}
+
+func TestRunReportOfUnreadableFiles(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	var r eventRecorder
+	err = run(context.Background(), cth.NewUnreadableFs(import1Fs, "family/mom.jpg"), "folder1", stagingFs, collectionFs, withReporter(&r))
+	th.NokPrefix(t, err, "Cannot read 1 file(s)")
+	report := readRunReport(t, stagingFs, "1_folder1")
+	th.Equals(t, 6, report.Staged.files)
+	th.Equals(t, 1, len(report.Unreadable))
+	th.Equals(t, "Cannot read 1 file(s)", report.Error)
+	failed := r.ofType(scan.ErrorEvent)
+	th.Equals(t, 1, len(failed))
+	th.Equals(t, "family/mom.jpg", failed[0].Path)
+}
+
+func TestDryRunReportsSummary(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	var r eventRecorder
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withReporter(&r)))
+	th.Equals(t, 0, len(r.ofType(scan.FileStaged)))
+	finished := r.ofType(scan.RunFinished)
+	th.Equals(t, 1, len(finished))
+	report := finished[0].Summary.(runReport)
+	th.Equals(t, true, report.DryRun)
+	th.Equals(t, "", report.StagingFolder)
+	th.Equals(t, 7, report.ToStage.files)
+	th.Equals(t, fileCount{}, report.Staged)
+	expectFileCount(t, stagingFs, 0)
+}
+
+func TestStageFilesReportsProgress(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	var r eventRecorder
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, withProgress(scan.NewEventProgress(&r, time.Hour))))
+	var stage []scan.Event
+	for _, e := range r.ofType(scan.ProgressEvent) {
+		if e.Phase == "stage" {
+			stage = append(stage, e)
+		}
+	}
+	th.Equals(t, 1, len(stage))
+	th.Equals(t, true, stage[0].Finished)
+	th.Equals(t, 7, stage[0].Files)
+	th.Equals(t, int64(7), stage[0].TotalFiles)
+	th.Equals(t, stage[0].TotalBytes, stage[0].Bytes)
+	th.Equals(t, readRunReport(t, stagingFs, "1_folder1").Staged.bytes, stage[0].Bytes)
+	// the syncs report their progress to the same sink
+	th.Assert(t, len(r.ofType(scan.ProgressEvent)) > 1, "the progress of the syncs is not reported")
+}
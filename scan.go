@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// scanFolderCatalog syncs the catalog of the folder with its content and saves it. The folder is synced as an import
+// folder, or as a collection if isCollection is true, so the files deleted from it are remembered.
+// The files that cannot be read are left out and returned in an UnreadableFilesError along with the catalog.
+func scanFolderCatalog(ctx context.Context, fs afero.Fs, isCollection bool, opts ...scan.Option) (catalog.Catalog, error) {
+	var c catalog.Catalog
+	var err error
+	if isCollection {
+		c, err = scan.SyncCatalogWithCollectionFolder(ctx, fs, opts...)
+	} else {
+		c, err = scan.SyncCatalogWithImportFolder(ctx, fs, opts...)
+	}
+	var unreadable []scan.FileError
+	if err = collectUnreadable(&unreadable, err); err != nil {
+		return nil, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+	if err = c.Write(fs); err != nil {
+		return nil, err
+	}
+	return c, unreadableError(unreadable)
+}
+
+// scanCommand runs the scan subcommand with the given command line arguments (without the subcommand itself)
+func scanCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	syncFlags := addSyncFlags(flags)
+	filters := addFilterFlags(flags)
+	isCollection := flags.Bool("collection", false, "the folder is a collection, remember the files deleted from it (the filters are not used)")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v scan [options] folder-path\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The folder must be specified")
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+	opts, cache, err := syncFlags.options()
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		opts = append(opts, scan.WithFilter(filter))
+	}
+	progress, err := syncFlags.progressSink(nil)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, scan.WithProgress(progress))
+	fs, err := openFolder(afero.NewOsFs(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !isFlagSet(flags, "hash") {
+		// an existing catalog keeps its hash algorithm, otherwise the folder would be rescanned
+		if c, err := readCatalog(fs); err == nil {
+			opts = append(opts, scan.WithHashAlgorithm(c.HashAlgorithm()))
+		}
+	}
+	if cache != nil {
+		defer saveHashCache(cache)
+	}
+	c, err := scanFolderCatalog(ctx, fs, *isCollection, opts...)
+	if c != nil {
+		fmt.Printf("%v file(s) in the catalog, state: %v\n", c.Count(), c.State())
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package scan
 
 // ProgressBar is the minimal progress bar interface used in CoBack.
 import (
+	"io"
 	"math"
 	"sync"
 	"time"
@@ -13,6 +14,7 @@ import (
 // DoubleProgressBar contains two progress bars, one for file count and one for size
 type DoubleProgressBar interface {
 	SetTotal(count int64, size int64)
+	AddTotal(count int64, size int64)
 	IncrBy(n int)
 	CurrentCount() int64
 	CurrentSize() int64
@@ -40,6 +42,22 @@ func (dpb *doubleProgressBar) SetTotal(count int64, size int64) {
 	dpb.sizeTotal = size
 }
 
+// AddTotal increases the totals, so the progress can be shown while the files are still being counted.
+// It cannot be used together with SetTotal. The bars are kept one step before their totals, otherwise mpb would
+// complete them as soon as the processing catches up with the counting. Wait sets the final totals.
+func (dpb *doubleProgressBar) AddTotal(count int64, size int64) {
+	dpb.mux.Lock()
+	defer dpb.mux.Unlock()
+	if dpb.countTotal == -1 && dpb.sizeTotal == -1 {
+		dpb.countTotal = 0
+		dpb.sizeTotal = 0
+	}
+	dpb.countTotal += count
+	dpb.sizeTotal += size
+	dpb.count.SetTotal(dpb.countTotal+1, false)
+	dpb.size.SetTotal(dpb.sizeTotal+1, false)
+}
+
 func (dpb *doubleProgressBar) IncrBy(n int) {
 	dpb.mux.Lock()
 	defer dpb.mux.Unlock()
@@ -62,14 +80,20 @@ func (dpb *doubleProgressBar) CurrentSize() int64 {
 func (dpb *doubleProgressBar) Wait() {
 	dpb.mux.Lock()
 	defer dpb.mux.Unlock()
+	if dpb.countTotal == -1 && dpb.sizeTotal == -1 {
+		// no file was found
+		dpb.countTotal = 0
+		dpb.sizeTotal = 0
+	}
 	dpb.count.SetTotal(dpb.countTotal, true)
 	dpb.size.SetTotal(dpb.sizeTotal, true)
 	dpb.master.Wait()
 }
 
-func newDoubleProgressBar() DoubleProgressBar {
+func newDoubleProgressBar(output io.Writer) DoubleProgressBar {
 	p := mpb.New(
-		mpb.WithRefreshRate(100 * time.Millisecond),
+		mpb.WithRefreshRate(100*time.Millisecond),
+		mpb.WithOutput(output),
 	)
 	countName := "Number of Files"
 	countBar := p.AddBar(math.MaxInt64,
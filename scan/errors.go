@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFolderNotFound is the cause of the error returned by the scanning functions if the folder to scan doesn't exist
+var ErrFolderNotFound = errors.New("The folder doesn't exist")
+
+// FileError describes a file or folder that could not be read during a scan
+type FileError struct {
+	// Folder is the role of the folder the file is in (import, staging or collection), empty if not known
+	Folder string
+	// Path is the path of the file relative to the scanned folder
+	Path string
+	// Err is the reason the file could not be read
+	Err error
+}
+
+func (e FileError) Error() string {
+	if e.Folder == "" {
+		return fmt.Sprintf("'%v': %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("'%v' in the %v folder: %v", e.Path, e.Folder, e.Err)
+}
+
+// UnreadableFilesError is returned if some files or folders could not be read.
+// They are left out of the catalog, but everything else is processed, so the catalog is returned along with the error.
+// Files that are already in the catalog are kept as they are. The files are read again by the next sync.
+type UnreadableFilesError struct {
+	// Files contains the unreadable files in alphabetical order
+	Files []FileError
+}
+
+func (e *UnreadableFilesError) Error() string {
+	return fmt.Sprintf("Cannot read %v file(s)", len(e.Files))
+}
+
+// fileErrors collects the unreadable files found by the concurrent steps of a scan
+type fileErrors struct {
+	mu    sync.Mutex
+	files []FileError
+}
+
+// add records a file that could not be read
+func (f *fileErrors) add(path string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files = append(f.files, FileError{Path: path, Err: err})
+}
+
+// check stores the files of an UnreadableFilesError and returns nil, any other error is returned as it is
+func (f *fileErrors) check(err error) error {
+	unreadable, ok := errors.Cause(err).(*UnreadableFilesError)
+	if !ok {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files = append(f.files, unreadable.Files...)
+	return nil
+}
+
+// covers returns true if the file or one of its parent folders could not be read
+func (f *fileErrors) covers(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.files {
+		if e.Path == path || e.Path == "." || strings.HasPrefix(path, e.Path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns an UnreadableFilesError with the collected files in the given folder, or nil if all files were read
+func (f *fileErrors) err(folder string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.files) == 0 {
+		return nil
+	}
+	files := make([]FileError, len(f.files))
+	for i, e := range f.files {
+		if e.Folder == "" {
+			e.Folder = folder
+		}
+		files[i] = e
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return &UnreadableFilesError{Files: files}
+}
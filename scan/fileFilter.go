@@ -1,12 +1,51 @@
 package scan
 
-import "strings"
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
-// FileFilter is an interface for filters used in the catalog scanning pipeline
+	"github.com/pkg/errors"
+)
+
+// FileFilter is an interface for filters used in the catalog scanning pipeline.
+// Include gets the path of a file or folder relative to the scanned file system (the same path that is stored in
+// the catalog) and its metadata. The folders are recognized by fi.IsDir(). The folders excluded by the filter are not
+// walked, so the files in them are not read at all. Filters that only select files must include all folders.
 type FileFilter interface {
-	Include(path string) bool
+	Include(path string, fi os.FileInfo) bool
+}
+
+// includePath returns true if the filter includes the file and all of its parent folders, so the file would be
+// found by walking the file system with the filter.
+func includePath(filter FileFilter, path string, fi os.FileInfo) bool {
+	var parents []string
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator) && dir != ""; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if !filter.Include(parents[i], folderInfo{name: filepath.Base(parents[i])}) {
+			return false
+		}
+	}
+	return filter.Include(path, fi)
 }
 
+// folderInfo is the metadata of a parent folder passed to the filters when a path is checked without walking the
+// file system
+type folderInfo struct {
+	name string
+}
+
+func (f folderInfo) Name() string       { return f.name }
+func (f folderInfo) Size() int64        { return 0 }
+func (f folderInfo) Mode() os.FileMode  { return os.ModeDir }
+func (f folderInfo) ModTime() time.Time { return time.Time{} }
+func (f folderInfo) IsDir() bool        { return true }
+func (f folderInfo) Sys() interface{}   { return nil }
+
 // ExtensionFilter filters the files based on their extension. The listed extensions
 // will be excluded
 func ExtensionFilter(extensions ...string) FileFilter {
@@ -17,7 +56,7 @@ func ExtensionFilter(extensions ...string) FileFilter {
 
 type noFilter struct{}
 
-func (e noFilter) Include(path string) bool {
+func (e noFilter) Include(path string, fi os.FileInfo) bool {
 	return true
 }
 
@@ -25,7 +64,10 @@ type extensionFilter struct {
 	extensions []string
 }
 
-func (e extensionFilter) Include(path string) bool {
+func (e extensionFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
 	for _, ext := range e.extensions {
 		if strings.HasSuffix(path, "."+ext) {
 			return false
@@ -33,3 +75,167 @@ func (e extensionFilter) Include(path string) bool {
 	}
 	return true
 }
+
+// MinSizeFilter includes the files that are at least size bytes long
+func MinSizeFilter(size int64) FileFilter {
+	return sizeFilter{min: size, max: -1}
+}
+
+// MaxSizeFilter includes the files that are at most size bytes long
+func MaxSizeFilter(size int64) FileFilter {
+	return sizeFilter{min: -1, max: size}
+}
+
+type sizeFilter struct {
+	min int64
+	max int64
+}
+
+func (f sizeFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+	if f.min >= 0 && fi.Size() < f.min {
+		return false
+	}
+	if f.max >= 0 && fi.Size() > f.max {
+		return false
+	}
+	return true
+}
+
+// ModifiedAfterFilter includes the files modified at or after t
+func ModifiedAfterFilter(t time.Time) FileFilter {
+	return modificationTimeFilter{after: t}
+}
+
+// ModifiedBeforeFilter includes the files modified before t
+func ModifiedBeforeFilter(t time.Time) FileFilter {
+	return modificationTimeFilter{before: t}
+}
+
+type modificationTimeFilter struct {
+	after  time.Time
+	before time.Time
+}
+
+func (f modificationTimeFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+	if !f.after.IsZero() && fi.ModTime().Before(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && !fi.ModTime().Before(f.before) {
+		return false
+	}
+	return true
+}
+
+// GlobFilter includes the files whose relative path matches any of the patterns.
+// The patterns are matched against the whole path, with the syntax of filepath.Match, and "**" matches any number
+// of folders. E.g. "DCIM/**/*.jpg" matches all jpg files in the DCIM folder, "**/*.jpg" matches all jpg files.
+// Returns error if a pattern is malformed.
+func GlobFilter(patterns ...string) (FileFilter, error) {
+	f := globFilter{}
+	for _, pattern := range patterns {
+		segments := strings.Split(strings.Trim(filepath.ToSlash(pattern), "/"), "/")
+		for _, segment := range segments {
+			if _, err := filepath.Match(segment, ""); err != nil {
+				return nil, errors.Wrapf(err, "Invalid pattern: '%v'", pattern)
+			}
+		}
+		f.patterns = append(f.patterns, segments)
+	}
+	return f, nil
+}
+
+type globFilter struct {
+	patterns [][]string
+}
+
+func (f globFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+	parts := strings.Split(normalizePath(path), "/")
+	for _, pattern := range f.patterns {
+		if matchSegments(pattern, parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegexFilter includes the files whose relative path (with forward slashes) matches the regular expression.
+// Returns error if the expression cannot be compiled.
+func RegexFilter(expr string) (FileFilter, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid regular expression: '%v'", expr)
+	}
+	return regexFilter{re: re}, nil
+}
+
+type regexFilter struct {
+	re *regexp.Regexp
+}
+
+func (f regexFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+	return f.re.MatchString(normalizePath(path))
+}
+
+// And includes the files included by all of the filters. A folder is excluded if any of the filters excludes it.
+func And(filters ...FileFilter) FileFilter {
+	return andFilter{filters: filters}
+}
+
+type andFilter struct {
+	filters []FileFilter
+}
+
+func (f andFilter) Include(path string, fi os.FileInfo) bool {
+	for _, filter := range f.filters {
+		if !filter.Include(path, fi) {
+			return false
+		}
+	}
+	return true
+}
+
+// Or includes the files included by any of the filters. A folder is only excluded if all of the filters exclude it.
+func Or(filters ...FileFilter) FileFilter {
+	return orFilter{filters: filters}
+}
+
+type orFilter struct {
+	filters []FileFilter
+}
+
+func (f orFilter) Include(path string, fi os.FileInfo) bool {
+	for _, filter := range f.filters {
+		if filter.Include(path, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// Not includes the files excluded by the filter. It never excludes whole folders.
+func Not(filter FileFilter) FileFilter {
+	return notFilter{filter: filter}
+}
+
+type notFilter struct {
+	filter FileFilter
+}
+
+func (f notFilter) Include(path string, fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+	return !f.filter.Include(path, fi)
+}
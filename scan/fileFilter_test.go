@@ -2,43 +2,134 @@ package scan
 
 import (
 	"testing"
+	"time"
 
+	cth "github.com/mitro42/coback/catalogtesthelper"
 	th "github.com/mitro42/testhelper"
 )
 
 func TestNoFilter(t *testing.T) {
 	f := noFilter{}
-	th.Equals(t, true, f.Include(""))
-	th.Equals(t, true, f.Include("a"))
-	th.Equals(t, true, f.Include("a.jpg"))
-	th.Equals(t, true, f.Include("a.txt"))
-	th.Equals(t, true, f.Include("folder/another/file.txt"))
-	th.Equals(t, true, f.Include("folder/another/"))
+	th.Equals(t, true, f.Include("", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a.txt", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/another/file.txt", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/another/", cth.FileInfo{}))
 }
 
 func TestExtensionFilter(t *testing.T) {
 	f := ExtensionFilter("txt")
-	th.Equals(t, true, f.Include(""))
-	th.Equals(t, true, f.Include("a"))
-	th.Equals(t, true, f.Include("a.jpg"))
-	th.Equals(t, false, f.Include("a.txt"))
-	th.Equals(t, false, f.Include("folder/another/file.txt"))
-	th.Equals(t, true, f.Include("folder/another/"))
+	th.Equals(t, true, f.Include("", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("a.txt", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("folder/another/file.txt", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/another/", cth.FileInfo{}))
 }
 
 func TestExtensionFilterMulti(t *testing.T) {
 	f := ExtensionFilter("txt", "jpg")
-	th.Equals(t, true, f.Include(""))
-	th.Equals(t, true, f.Include("a"))
-	th.Equals(t, false, f.Include("a.jpg"))
-	th.Equals(t, false, f.Include("a.txt"))
-	th.Equals(t, false, f.Include("folder/another/file.txt"))
-	th.Equals(t, true, f.Include("include/this/txt/file.png"))
-	th.Equals(t, true, f.Include("include/this/too/filetxt"))
-	th.Equals(t, true, f.Include("include/this/too/filejpg"))
-	th.Equals(t, true, f.Include("include/this/too/fileJP"))
-	th.Equals(t, true, f.Include("include/this/too/file.JPG"))
-	th.Equals(t, true, f.Include("include/this/too/file.TXT"))
-	th.Equals(t, true, f.Include("include/this/too/file.TxT"))
-	th.Equals(t, true, f.Include("folder/another/"))
+	th.Equals(t, true, f.Include("", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("a.txt", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("folder/another/file.txt", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/txt/file.png", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/filetxt", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/filejpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/fileJP", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/file.JPG", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/file.TXT", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("include/this/too/file.TxT", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/another/", cth.FileInfo{}))
+}
+
+func TestSizeFilters(t *testing.T) {
+	min := MinSizeFilter(50 * 1024)
+	max := MaxSizeFilter(1000)
+	th.Equals(t, false, min.Include("a.jpg", cth.FileInfo{FileSize: 50*1024 - 1}))
+	th.Equals(t, true, min.Include("a.jpg", cth.FileInfo{FileSize: 50 * 1024}))
+	th.Equals(t, true, max.Include("a.jpg", cth.FileInfo{FileSize: 1000}))
+	th.Equals(t, false, max.Include("a.jpg", cth.FileInfo{FileSize: 1001}))
+}
+
+func TestModificationTimeFilters(t *testing.T) {
+	limit := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	after := ModifiedAfterFilter(limit)
+	before := ModifiedBeforeFilter(limit)
+	th.Equals(t, false, after.Include("a.jpg", cth.FileInfo{FileModTime: limit.Add(-time.Second)}))
+	th.Equals(t, true, after.Include("a.jpg", cth.FileInfo{FileModTime: limit}))
+	th.Equals(t, true, before.Include("a.jpg", cth.FileInfo{FileModTime: limit.Add(-time.Second)}))
+	th.Equals(t, false, before.Include("a.jpg", cth.FileInfo{FileModTime: limit}))
+}
+
+func TestGlobFilter(t *testing.T) {
+	f, err := GlobFilter("DCIM/**/*.jpg", "DCIM/**/*.cr2", "*.png")
+	th.Ok(t, err)
+	th.Equals(t, true, f.Include("DCIM/a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("DCIM/100CANON/a.cr2", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("DCIM/100CANON/a.thm", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("other/DCIM/a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("a.png", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("folder/a.png", cth.FileInfo{}))
+
+	_, err = GlobFilter("[a-")
+	th.NokPrefix(t, err, "Invalid pattern: '[a-'")
+}
+
+func TestRegexFilter(t *testing.T) {
+	f, err := RegexFilter(`^20[0-9]{2}/.*\.(jpg|jpeg)$`)
+	th.Ok(t, err)
+	th.Equals(t, true, f.Include("2018/holiday/a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("1999/a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("2018/a.png", cth.FileInfo{}))
+
+	_, err = RegexFilter("(")
+	th.NokPrefix(t, err, "Invalid regular expression: '('")
+}
+
+func TestCombinedFilters(t *testing.T) {
+	jpg, err := GlobFilter("**/*.jpg")
+	th.Ok(t, err)
+	big := MinSizeFilter(100)
+	small := cth.FileInfo{FileSize: 10}
+	large := cth.FileInfo{FileSize: 1000}
+
+	and := And(jpg, big)
+	th.Equals(t, true, and.Include("a/b.jpg", large))
+	th.Equals(t, false, and.Include("a/b.jpg", small))
+	th.Equals(t, false, and.Include("a/b.png", large))
+	th.Equals(t, true, And().Include("a/b.png", small))
+
+	or := Or(jpg, big)
+	th.Equals(t, true, or.Include("a/b.jpg", small))
+	th.Equals(t, true, or.Include("a/b.png", large))
+	th.Equals(t, false, or.Include("a/b.png", small))
+	th.Equals(t, false, Or().Include("a/b.png", small))
+
+	not := Not(jpg)
+	th.Equals(t, false, not.Include("a/b.jpg", small))
+	th.Equals(t, true, not.Include("a/b.png", small))
+}
+
+func TestCombinedFiltersExcludeFolders(t *testing.T) {
+	ignore := ignoreFilterWith(map[string]string{IgnoreFileName: "cache/\n"})
+	th.Equals(t, false, And(noFilter{}, ignore).Include("cache", cth.FileInfo{Dir: true}))
+	th.Equals(t, true, And(noFilter{}, ignore).Include("photos", cth.FileInfo{Dir: true}))
+	th.Equals(t, true, Or(noFilter{}, ignore).Include("cache", cth.FileInfo{Dir: true}))
+	th.Equals(t, false, Or(ignore, ignore).Include("cache", cth.FileInfo{Dir: true}))
+	th.Equals(t, true, Not(ignore).Include("cache", cth.FileInfo{Dir: true}))
+}
+
+func TestFileFiltersIncludeFolders(t *testing.T) {
+	glob, err := GlobFilter("**/*.jpg")
+	th.Ok(t, err)
+	regex, err := RegexFilter(`\.jpg$`)
+	th.Ok(t, err)
+	folder := cth.FileInfo{FileName: "photos.bin", Dir: true}
+	for _, f := range []FileFilter{ExtensionFilter("bin"), MinSizeFilter(100), MaxSizeFilter(0),
+		ModifiedAfterFilter(time.Now()), ModifiedBeforeFilter(time.Time{}.Add(time.Hour)), glob, regex, Not(noFilter{})} {
+		th.Equals(t, true, f.Include("photos.bin", folder))
+	}
 }
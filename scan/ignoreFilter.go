@@ -0,0 +1,202 @@
+package scan
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// IgnoreFileName is the name of the files that list the files and folders CoBack ignores.
+// They use the syntax of .gitignore files.
+const IgnoreFileName = ".cobackignore"
+
+// ignorePattern is a single line of an ignore file
+type ignorePattern struct {
+	// base is the folder of the ignore file, empty for the root folder
+	base string
+	// segments are the parts of the pattern between the slashes
+	segments []string
+	// anchored is true if the pattern is matched against the whole path relative to base, not just the name
+	anchored bool
+	// dirOnly is true if the pattern only matches folders (it ends with a slash)
+	dirOnly bool
+	// negate is true if the pattern includes files excluded by an earlier pattern (it starts with an exclamation mark)
+	negate bool
+}
+
+// parseIgnorePattern parses a line of the ignore file in the base folder.
+// Returns false if the line is not a pattern (empty line or comment).
+func parseIgnorePattern(base string, line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// matches returns true if the pattern matches the slash separated path
+func (p ignorePattern) matches(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+		relPath = relPath[len(p.base)+1:]
+	}
+	parts := strings.Split(relPath, "/")
+	if !p.anchored {
+		parts = parts[len(parts)-1:]
+	}
+	return matchSegments(p.segments, parts)
+}
+
+// matchSegments matches the path segments to the pattern segments. A "**" segment matches any number of
+// path segments, but a trailing "**" needs at least one.
+func matchSegments(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, err := path.Match(pattern[0], parts[0]); err != nil || !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// ignoreFilter excludes the files and folders listed in the ignore files of a folder.
+// The ignore files are read when they are first needed.
+type ignoreFilter struct {
+	fs       afero.Fs
+	mu       sync.Mutex
+	patterns map[string][]ignorePattern
+	folders  map[string]bool
+}
+
+// IgnoreFilter returns a filter that excludes the files and folders matched by the .cobackignore files in fs.
+// There can be an ignore file in any folder, its patterns are relative to its folder and take precedence over the
+// patterns of the ignore files in the parent folders. The ignore files themselves are excluded too.
+// The folders excluded by the filter are not walked at all.
+func IgnoreFilter(fs afero.Fs) FileFilter {
+	return &ignoreFilter{
+		fs:       fs,
+		patterns: make(map[string][]ignorePattern),
+		folders:  make(map[string]bool),
+	}
+}
+
+// Include returns false if the file or folder, or one of its parent folders is ignored
+func (f *ignoreFilter) Include(filePath string, fi os.FileInfo) bool {
+	relPath := normalizePath(filePath)
+	if !fi.IsDir() && path.Base(relPath) == IgnoreFileName {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.excluded(relPath, fi.IsDir())
+}
+
+// normalizePath converts a path to the slash separated form the patterns are matched against, "" is the root
+func normalizePath(p string) string {
+	p = filepath.ToSlash(filepath.Clean(p))
+	p = strings.TrimPrefix(p, "/")
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// excluded returns true if the path or one of its parent folders is ignored
+func (f *ignoreFilter) excluded(relPath string, isDir bool) bool {
+	if relPath == "" {
+		return false
+	}
+	if parent := path.Dir(relPath); parent != "." && f.folderExcluded(parent) {
+		return true
+	}
+	return f.ignored(relPath, isDir)
+}
+
+func (f *ignoreFilter) folderExcluded(relPath string) bool {
+	if excluded, ok := f.folders[relPath]; ok {
+		return excluded
+	}
+	excluded := f.excluded(relPath, true)
+	f.folders[relPath] = excluded
+	return excluded
+}
+
+// ignored applies the patterns of the ignore files in the parent folders of the path, the last matching pattern wins
+func (f *ignoreFilter) ignored(relPath string, isDir bool) bool {
+	folders := []string{""}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		folders = append(folders, strings.Join(parts[:i], "/"))
+	}
+	ignored := false
+	for _, folder := range folders {
+		for _, p := range f.folderPatterns(folder) {
+			if p.matches(relPath, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// folderPatterns returns the patterns of the ignore file in the folder, reading the file if needed.
+// Folders without a readable ignore file have no patterns.
+func (f *ignoreFilter) folderPatterns(folder string) []ignorePattern {
+	if patterns, ok := f.patterns[folder]; ok {
+		return patterns
+	}
+	var patterns []ignorePattern
+	file, err := f.fs.Open(filepath.Join(filepath.FromSlash(folder), IgnoreFileName))
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if p, ok := parseIgnorePattern(folder, scanner.Text()); ok {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	f.patterns[folder] = patterns
+	return patterns
+}
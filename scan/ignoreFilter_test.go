@@ -0,0 +1,164 @@
+package scan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func ignoreFilterWith(files map[string]string) FileFilter {
+	fs := afero.NewMemMapFs()
+	for path, content := range files {
+		afero.WriteFile(fs, path, []byte(content), 0644)
+	}
+	return IgnoreFilter(fs)
+}
+
+func TestParseIgnorePattern(t *testing.T) {
+	_, ok := parseIgnorePattern("", "")
+	th.Equals(t, false, ok)
+	_, ok = parseIgnorePattern("", "# comment")
+	th.Equals(t, false, ok)
+	_, ok = parseIgnorePattern("", "/")
+	th.Equals(t, false, ok)
+
+	p, ok := parseIgnorePattern("a", "!/DCIM/*.tmp/  ")
+	th.Equals(t, true, ok)
+	th.Equals(t, ignorePattern{base: "a", segments: []string{"DCIM", "*.tmp"}, anchored: true, dirOnly: true, negate: true}, p)
+	p, _ = parseIgnorePattern("", `\#file`)
+	th.Equals(t, ignorePattern{segments: []string{"#file"}}, p)
+}
+
+func TestIgnoreFilterNoIgnoreFile(t *testing.T) {
+	f := ignoreFilterWith(nil)
+	th.Equals(t, true, f.Include("a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/Thumbs.db", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder", cth.FileInfo{Dir: true}))
+}
+
+func TestIgnoreFilterNames(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName: "# system files\nThumbs.db\n.DS_Store\n*.tmp\n~*\n",
+	})
+	th.Equals(t, true, f.Include("a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("Thumbs.db", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("holiday/2018/Thumbs.db", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("holiday/.DS_Store", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("holiday/a.jpg.tmp", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("holiday/~a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("holiday/a~.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include(IgnoreFileName, cth.FileInfo{}))
+	th.Equals(t, false, f.Include("holiday/"+IgnoreFileName, cth.FileInfo{}))
+}
+
+func TestIgnoreFilterNegation(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName: "*.ini\n!important.ini\n",
+	})
+	th.Equals(t, false, f.Include(".picasa.ini", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("important.ini", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("folder/important.ini", cth.FileInfo{}))
+}
+
+func TestIgnoreFilterFolders(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName: "@eaDir/\ncache\n",
+	})
+	th.Equals(t, false, f.Include("@eaDir", cth.FileInfo{Dir: true}))
+	th.Equals(t, false, f.Include("photos/@eaDir", cth.FileInfo{Dir: true}))
+	th.Equals(t, false, f.Include("photos/@eaDir/a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("photos/@eaDir", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("photos/cache", cth.FileInfo{Dir: true}))
+	th.Equals(t, false, f.Include("photos/cache", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("photos/cache/a/b.jpg", cth.FileInfo{}))
+}
+
+func TestIgnoreFilterAnchored(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName: "/tmp\nDCIM/*.thm\nvideos/**/*.lrv\n**/backup/*.bak\nexports/**\n",
+	})
+	th.Equals(t, false, f.Include("tmp", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("photos/tmp", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("DCIM/a.thm", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("DCIM/100/a.thm", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("photos/DCIM/a.thm", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("videos/a.lrv", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("videos/2018/06/a.lrv", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("backup/a.bak", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("a/b/backup/a.bak", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("exports", cth.FileInfo{Dir: true}))
+	th.Equals(t, false, f.Include("exports/a.jpg", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("exports/web", cth.FileInfo{Dir: true}))
+}
+
+func TestIgnoreFilterNested(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName:                  "*.png\n",
+		"screenshots/" + IgnoreFileName: "!*.png\n/tmp/\n",
+	})
+	th.Equals(t, false, f.Include("a.png", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("other/a.png", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("screenshots/a.png", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("screenshots/2018/a.png", cth.FileInfo{}))
+	th.Equals(t, false, f.Include("screenshots/tmp/a.jpg", cth.FileInfo{}))
+	th.Equals(t, true, f.Include("tmp/a.jpg", cth.FileInfo{}))
+}
+
+func TestIgnoreFilterCannotReincludeInIgnoredFolder(t *testing.T) {
+	f := ignoreFilterWith(map[string]string{
+		IgnoreFileName: "cache/\n!cache/keep.jpg\n",
+	})
+	th.Equals(t, false, f.Include("cache/keep.jpg", cth.FileInfo{}))
+}
+
+func TestScanWithIgnoreFilterSkipsIgnoredFolders(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	th.Ok(t, afero.WriteFile(fs, IgnoreFileName, []byte("subfolder/\ntest2.txt\n"), 0644))
+	// the ignored folder cannot even be read, the scan must not try to walk it
+	unreadableFs := cth.NewUnreadableFs(fs, "subfolder")
+
+	c, err := ScanFolder(context.Background(), unreadableFs, "", IgnoreFilter(unreadableFs))
+	th.Ok(t, err)
+	th.Equals(t, 1, c.Count())
+	checkFilesInCatalog(t, c, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
+
+	count, size := walkTotals(unreadableFs, "", IgnoreFilter(unreadableFs))
+	th.Equals(t, int64(1), count)
+	th.Equals(t, int64(1160), size)
+}
+
+func TestDiffWithIgnoreFilter(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	th.Ok(t, afero.WriteFile(fs, IgnoreFileName, []byte("subfolder/\n"), 0644))
+
+	diff, err := DiffFiltered(context.Background(), fs, c, IgnoreFilter(fs), false)
+	th.Ok(t, err)
+	th.Equals(t, map[string]bool{"subfolder/file1.bin": true, "subfolder/file2.bin": true}, diff.Ignored)
+	th.Equals(t, 0, len(diff.Delete))
+	th.Equals(t, 0, len(diff.Add))
+	th.Equals(t, 2, len(diff.Ok))
+}
+
+func TestSyncCollectionForgetsIgnoredFiles(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	th.Ok(t, c.Write(collectionFs))
+	th.Ok(t, afero.WriteFile(collectionFs, IgnoreFileName, []byte("*.bin\n"), 0644))
+
+	c, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	th.Equals(t, 2, c.Count())
+	th.Equals(t, 0, c.DeletedCount())
+	th.Equals(t, catalog.Initialized, c.State())
+	_, err = c.Item(IgnoreFileName)
+	th.NokPrefix(t, err, "No such file")
+}
@@ -0,0 +1,95 @@
+package scan
+
+import (
+	"os"
+
+	"github.com/mitro42/coback/catalog"
+)
+
+// Option configures optional behaviour of the scanning and syncing functions
+type Option func(*options)
+
+type options struct {
+	hashAlgorithm catalog.HashAlgorithm
+	repair        bool
+	verifyMoves   bool
+	filter        FileFilter
+	hashCache     catalog.HashCache
+	reporter      Reporter
+	progress      ProgressSink
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		hashAlgorithm: catalog.DefaultHashAlgorithm,
+		filter:        noFilter{},
+		reporter:      NewTextReporter(os.Stdout),
+		progress:      NewTerminalProgress(os.Stdout),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithHashAlgorithm sets the hash algorithm used when a catalog has to be created from scratch.
+// Existing catalogs keep using the algorithm they were created with.
+func WithHashAlgorithm(alg catalog.HashAlgorithm) Option {
+	return func(o *options) {
+		o.hashAlgorithm = alg
+	}
+}
+
+// WithRepair allows the sync functions to rebuild a catalog that is marked as corrupted instead of returning an error.
+// The folder is rescanned, the deleted checksums stored in the old catalog are kept.
+func WithRepair() Option {
+	return func(o *options) {
+		o.repair = true
+	}
+}
+
+// WithVerifiedMoves makes the sync functions check the content of the files that seem to be moved in the folder.
+// Without it a file is treated as moved if a file with the same size and modification time disappeared from the folder.
+func WithVerifiedMoves() Option {
+	return func(o *options) {
+		o.verifyMoves = true
+	}
+}
+
+// WithFilter makes the import folder sync leave out the files excluded by the filter, in addition to the files in
+// the .cobackignore files. The collection and the staging folder are never filtered, otherwise the files excluded
+// from their catalogs would be imported again.
+func WithFilter(filter FileFilter) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
+}
+
+// WithHashCache makes the scans take the checksums of the files that haven't changed since they were last hashed
+// from the cache instead of reading them again, and add the new checksums to the cache.
+func WithHashCache(cache catalog.HashCache) Option {
+	return func(o *options) {
+		o.hashCache = cache
+	}
+}
+
+// WithReporter sets the Reporter that receives the events of the syncs, by default their messages are printed to the
+// standard output
+func WithReporter(r Reporter) Option {
+	return func(o *options) {
+		o.reporter = r
+	}
+}
+
+// WithProgress sets the ProgressSink that shows the progress of the scans, by default progress bars are drawn to the
+// standard output
+func WithProgress(p ProgressSink) Option {
+	return func(o *options) {
+		o.progress = p
+	}
+}
+
+// forward returns the options passed on from a sync to the scans it starts, with the given hash algorithm
+func (o options) forward(alg catalog.HashAlgorithm) []Option {
+	return []Option{WithHashAlgorithm(alg), WithHashCache(o.hashCache), WithReporter(o.reporter), WithProgress(o.progress)}
+}
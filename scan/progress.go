@@ -0,0 +1,186 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressSink shows the progress of the scans and of copying the files.
+// It creates a DoubleProgressBar for each task, the tasks of a sync run one after the other.
+type ProgressSink interface {
+	// NewProgressBar returns the progress bar of a new task, name tells what the task does (scan, diff, verify or stage)
+	NewProgressBar(name string) DoubleProgressBar
+}
+
+// terminalProgress draws mpb progress bars, it is meant for interactive use
+type terminalProgress struct {
+	w io.Writer
+}
+
+// NewTerminalProgress returns a ProgressSink that draws progress bars to w, updated in place with escape codes
+func NewTerminalProgress(w io.Writer) ProgressSink {
+	return terminalProgress{w}
+}
+
+func (p terminalProgress) NewProgressBar(name string) DoubleProgressBar {
+	return newDoubleProgressBar(p.w)
+}
+
+// logProgress writes the progress as plain text lines, it is meant for logs
+type logProgress struct {
+	w        io.Writer
+	interval time.Duration
+}
+
+// NewLogProgress returns a ProgressSink that writes a line with the progress of the running task to w
+// at each interval, and when the task is finished
+func NewLogProgress(w io.Writer, interval time.Duration) ProgressSink {
+	return logProgress{w, interval}
+}
+
+func (p logProgress) NewProgressBar(name string) DoubleProgressBar {
+	return newPeriodicProgressBar(p.interval, func(c progressCounts, finished bool) {
+		if finished {
+			fmt.Fprintf(p.w, "%v finished: %v\n", name, c)
+		} else {
+			fmt.Fprintf(p.w, "%v: %v\n", name, c)
+		}
+	})
+}
+
+// eventProgress sends the progress to a Reporter
+type eventProgress struct {
+	r        Reporter
+	interval time.Duration
+}
+
+// NewEventProgress returns a ProgressSink that reports a ProgressEvent with the counts of the running task
+// at each interval, and when the task is finished
+func NewEventProgress(r Reporter, interval time.Duration) ProgressSink {
+	return eventProgress{r, interval}
+}
+
+func (p eventProgress) NewProgressBar(name string) DoubleProgressBar {
+	return newPeriodicProgressBar(p.interval, func(c progressCounts, finished bool) {
+		p.r.Report(Event{Type: ProgressEvent, Phase: name, Files: int(c.count), TotalFiles: c.countTotal,
+			Bytes: c.size, TotalBytes: c.sizeTotal, Finished: finished})
+	})
+}
+
+// noProgress doesn't show the progress
+type noProgress struct{}
+
+// NoProgress returns a ProgressSink that doesn't show anything
+func NoProgress() ProgressSink {
+	return noProgress{}
+}
+
+func (noProgress) NewProgressBar(name string) DoubleProgressBar {
+	return &countingProgressBar{}
+}
+
+// progressCounts is the state of a task: the files and bytes processed so far and their totals
+type progressCounts struct {
+	count      int64
+	countTotal int64
+	size       int64
+	sizeTotal  int64
+}
+
+func (c progressCounts) String() string {
+	const mib = 1024 * 1024
+	percent := 100.0
+	if c.sizeTotal > 0 {
+		percent = 100 * float64(c.size) / float64(c.sizeTotal)
+	} else if c.countTotal > 0 {
+		percent = 100 * float64(c.count) / float64(c.countTotal)
+	}
+	return fmt.Sprintf("%v/%v files, %.1f/%.1f MiB (%.0f%%)",
+		c.count, c.countTotal, float64(c.size)/mib, float64(c.sizeTotal)/mib, percent)
+}
+
+// countingProgressBar keeps the counts of a task without showing them
+type countingProgressBar struct {
+	mu     sync.Mutex
+	counts progressCounts
+}
+
+func (b *countingProgressBar) SetTotal(count int64, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts.countTotal = count
+	b.counts.sizeTotal = size
+}
+
+func (b *countingProgressBar) AddTotal(count int64, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts.countTotal += count
+	b.counts.sizeTotal += size
+}
+
+func (b *countingProgressBar) IncrBy(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts.count++
+	b.counts.size += int64(n)
+}
+
+func (b *countingProgressBar) CurrentCount() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts.count
+}
+
+func (b *countingProgressBar) CurrentSize() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts.size
+}
+
+func (b *countingProgressBar) Wait() {}
+
+// snapshot returns the current counts
+func (b *countingProgressBar) snapshot() progressCounts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts
+}
+
+// periodicProgressBar shows the counts of a task at regular intervals, and once more when the task is finished
+type periodicProgressBar struct {
+	countingProgressBar
+	show     func(c progressCounts, finished bool)
+	stop     chan struct{}
+	stopped  chan struct{}
+	waitOnce sync.Once
+}
+
+func newPeriodicProgressBar(interval time.Duration, show func(c progressCounts, finished bool)) *periodicProgressBar {
+	b := &periodicProgressBar{show: show, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(b.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.show(b.snapshot(), false)
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// Wait stops the periodic updates and shows the final counts
+func (b *periodicProgressBar) Wait() {
+	b.waitOnce.Do(func() {
+		close(b.stop)
+		<-b.stopped
+		b.show(b.snapshot(), true)
+	})
+}
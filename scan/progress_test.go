@@ -0,0 +1,76 @@
+package scan
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	th "github.com/mitro42/testhelper"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the goroutines of the progress bars
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProgressCountsString(t *testing.T) {
+	th.Equals(t, "1/4 files, 1.0/4.0 MiB (25%)", progressCounts{1, 4, 1024 * 1024, 4 * 1024 * 1024}.String())
+	th.Equals(t, "1/2 files, 0.0/0.0 MiB (50%)", progressCounts{1, 2, 0, 0}.String())
+	th.Equals(t, "0/0 files, 0.0/0.0 MiB (100%)", progressCounts{}.String())
+}
+
+func TestLogProgress(t *testing.T) {
+	var out syncBuffer
+	pb := NewLogProgress(&out, time.Millisecond).NewProgressBar("scan")
+	pb.AddTotal(1, 1024*1024)
+	pb.AddTotal(1, 1024*1024)
+	pb.IncrBy(1024 * 1024)
+	time.Sleep(20 * time.Millisecond)
+	pb.IncrBy(1024 * 1024)
+	pb.Wait()
+	pb.Wait()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	th.Assert(t, len(lines) > 1, "the progress was not written periodically: %v", lines)
+	th.Assert(t, strings.Contains(out.String(), "scan: 1/2 files, 1.0/2.0 MiB (50%)\n"), "the progress is missing: %v", lines)
+	th.Equals(t, "scan finished: 2/2 files, 2.0/2.0 MiB (100%)", lines[len(lines)-1])
+	th.Equals(t, 1, strings.Count(out.String(), "finished"))
+}
+
+func TestEventProgress(t *testing.T) {
+	var r eventRecorder
+	pb := NewEventProgress(&r, time.Hour).NewProgressBar("diff")
+	pb.SetTotal(2, 3000)
+	pb.IncrBy(1000)
+	th.Equals(t, int64(1), pb.CurrentCount())
+	th.Equals(t, int64(1000), pb.CurrentSize())
+	pb.Wait()
+
+	th.Equals(t, 1, len(r.events))
+	th.Equals(t, Event{Type: ProgressEvent, Phase: "diff", Files: 1, TotalFiles: 2, Bytes: 1000, TotalBytes: 3000, Finished: true}, r.events[0])
+}
+
+func TestNoProgress(t *testing.T) {
+	pb := NoProgress().NewProgressBar("scan")
+	pb.AddTotal(2, 3000)
+	pb.IncrBy(1000)
+	pb.IncrBy(2000)
+	pb.Wait()
+	th.Equals(t, int64(2), pb.CurrentCount())
+	th.Equals(t, int64(3000), pb.CurrentSize())
+}
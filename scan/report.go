@@ -0,0 +1,178 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/pkg/errors"
+)
+
+// ReportFileName is the name of the run reports CoBack writes into the folders of the staging folder the files are
+// copied to. The staging folder syncs skip them like the catalog files.
+const ReportFileName = "coback.report.json"
+
+// AuditLogFileName is the name of the log in the root of the staging folder that lists the files CoBack deleted from
+// the import folders
+const AuditLogFileName = "coback.audit.log"
+
+// IsRunFile returns true if the path, relative to the root of the staging folder, is a run report or the audit log.
+// Only the places CoBack writes them count: the audit log in the root and the reports in the folders directly under
+// it. Files with the same name anywhere else, or in another folder, are ordinary files.
+func IsRunFile(path string) bool {
+	dir, name := filepath.Split(filepath.Clean(path))
+	dir = filepath.Clean(dir)
+	switch name {
+	case AuditLogFileName:
+		return dir == "."
+	case ReportFileName:
+		return dir != "." && filepath.Dir(dir) == "."
+	}
+	return false
+}
+
+// runFileFilter excludes the run reports and the audit log from the staging folder, see IsRunFile
+type runFileFilter struct{}
+
+func (runFileFilter) Include(path string, fi os.FileInfo) bool {
+	return fi.IsDir() || !IsRunFile(path)
+}
+
+// EventType tells what an Event is about
+type EventType string
+
+const (
+	// PhaseStarted is reported when the processing of a folder or the copying of the files starts
+	PhaseStarted EventType = "phase_start"
+	// PhaseFinished is reported when a phase ends, with the error that stopped it, if any
+	PhaseFinished EventType = "phase_end"
+	// MessageEvent carries a message that only informs the user, like the steps of a sync
+	MessageEvent EventType = "message"
+	// FileStaged is reported when a file is copied to the staging folder
+	FileStaged EventType = "file_staged"
+	// FileSkipped is reported for the files of the import folder that are not copied to the staging folder, and for
+	// the corrupted files of the collection that have no healthy copy to be restored from
+	FileSkipped EventType = "file_skipped"
+	// ErrorEvent is reported for the files that could not be read, and for the error that stopped a run
+	ErrorEvent EventType = "error"
+	// RunFinished is reported at the end of an import or a repair with its summary
+	RunFinished EventType = "summary"
+	// ProgressEvent is reported periodically by the ProgressSink returned by NewEventProgress
+	ProgressEvent EventType = "progress"
+	// FileRemoved is reported when a file is deleted from the import folder in move mode
+	FileRemoved EventType = "file_removed"
+	// RestorePlanned is reported for each corrupted file of the collection that has a healthy copy, before any of
+	// them is restored
+	RestorePlanned EventType = "restore_planned"
+	// FileRestored is reported when a corrupted file of the collection is restored from its healthy copy
+	FileRestored EventType = "file_restored"
+)
+
+// Event is something that happened while CoBack was processing the folders
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Phase is the folder being processed (import, staging or collection), or stage while the files are copied.
+	// In a ProgressEvent it's the task of the progress bar.
+	Phase string `json:"phase,omitempty"`
+	// Path is the path of the file relative to the folder of the phase
+	Path string `json:"path,omitempty"`
+	// Target is the path a staged file was copied to, relative to the staging folder.
+	// For a file removed from the import folder it's the verified copy in the staging folder, if there is one.
+	// For a restored file of the collection it's the healthy copy, with the path of its import folder.
+	Target string `json:"target,omitempty"`
+	// Mode tells how a staged file was put into the staging folder: copy, hardlink or reflink
+	Mode string `json:"mode,omitempty"`
+	// Reason tells why a file was skipped, or why it could be removed from the import folder
+	Reason string `json:"reason,omitempty"`
+	// Files is the number of files in the catalog at the end of a phase, or the files processed in a ProgressEvent
+	Files int `json:"files,omitempty"`
+	// Bytes, TotalFiles and TotalBytes are the rest of the counts of a ProgressEvent
+	Bytes      int64 `json:"bytes,omitempty"`
+	TotalFiles int64 `json:"total_files,omitempty"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+	// Finished is true in the last ProgressEvent of a task
+	Finished bool   `json:"finished,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Message is the human readable form of the event, only the events that have one are shown in text mode
+	Message string `json:"message,omitempty"`
+	// Summary is the report of the whole run in a RunFinished event
+	Summary interface{} `json:"summary,omitempty"`
+}
+
+// Reporter receives the events of the sync functions and the import. It must be safe for concurrent use.
+type Reporter interface {
+	Report(e Event)
+}
+
+// textReporter prints the messages of the events for humans, the events without a message are not shown
+type textReporter struct {
+	w io.Writer
+}
+
+// NewTextReporter returns a Reporter that writes the messages of the events to w, one per line
+func NewTextReporter(w io.Writer) Reporter {
+	return textReporter{w}
+}
+
+func (r textReporter) Report(e Event) {
+	if e.Message != "" {
+		fmt.Fprintln(r.w, e.Message)
+	}
+}
+
+// jsonReporter writes each event as a JSON object on its own line
+type jsonReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONReporter returns a Reporter that writes the events to w as JSON objects, one per line.
+// The time of the events is set when they are reported, if it's not set yet.
+func NewJSONReporter(w io.Writer) Reporter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonReporter{enc: enc}
+}
+
+func (r *jsonReporter) Report(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(e)
+}
+
+// message reports a MessageEvent
+func (o options) message(format string, args ...interface{}) {
+	o.reporter.Report(Event{Type: MessageEvent, Message: fmt.Sprintf(format, args...)})
+}
+
+// startPhase reports the start of syncing a folder, title is shown in text mode
+func (o options) startPhase(phase string, title string) {
+	o.reporter.Report(Event{Type: PhaseStarted, Phase: phase, Message: title})
+}
+
+// finishPhase reports the files of the folder that could not be read and the end of the phase with the result
+// of the sync
+func (o options) finishPhase(phase string, c catalog.Catalog, err error) {
+	if unreadable, ok := errors.Cause(err).(*UnreadableFilesError); ok {
+		for _, f := range unreadable.Files {
+			o.reporter.Report(Event{Type: ErrorEvent, Phase: phase, Path: f.Path, Error: f.Err.Error()})
+		}
+	}
+	e := Event{Type: PhaseFinished, Phase: phase}
+	if c != nil {
+		e.Files = c.Count()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	o.reporter.Report(e)
+}
@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+// eventRecorder is a Reporter that keeps the events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// ofType returns the recorded events of the given type
+func (r *eventRecorder) ofType(t EventType) []Event {
+	var ret []Event
+	for _, e := range r.events {
+		if e.Type == t {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func TestTextReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf)
+	r.Report(Event{Type: PhaseStarted, Phase: "import", Message: "Processing import folder"})
+	r.Report(Event{Type: FileSkipped, Path: "a.jpg", Reason: "deleted"})
+	r.Report(Event{Type: MessageEvent, Message: "Reading catalog"})
+	th.Equals(t, "Processing import folder\nReading catalog\n", buf.String())
+}
+
+func TestJSONReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewJSONReporter(&buf)
+	r.Report(Event{Type: PhaseStarted, Phase: "import", Message: "Processing import folder"})
+	r.Report(Event{Type: FileSkipped, Path: "a.jpg", Reason: "deleted"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	th.Equals(t, 2, len(lines))
+	var e Event
+	th.Ok(t, json.Unmarshal([]byte(lines[1]), &e))
+	th.Equals(t, FileSkipped, e.Type)
+	th.Equals(t, "a.jpg", e.Path)
+	th.Equals(t, "deleted", e.Reason)
+	th.Assert(t, !e.Time.IsZero(), "the time of the event is not set")
+	th.Assert(t, !strings.Contains(lines[1], "message"), "empty fields are written: %v", lines[1])
+}
+
+func TestSyncReportsEvents(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+
+	var r eventRecorder
+	c, err := SyncCatalogWithImportFolder(context.Background(), cth.NewUnreadableFs(importFs, "subfolder/file2.bin"), WithReporter(&r))
+	_, ok := err.(*UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, PhaseStarted, r.events[0].Type)
+	th.Equals(t, "import", r.events[0].Phase)
+	th.Equals(t, "***************** Processing import folder ***************", r.events[0].Message)
+	th.Assert(t, len(r.ofType(MessageEvent)) > 0, "the steps of the sync are not reported")
+
+	failed := r.ofType(ErrorEvent)
+	th.Equals(t, 1, len(failed))
+	th.Equals(t, "subfolder/file2.bin", failed[0].Path)
+	th.Equals(t, "import", failed[0].Phase)
+
+	last := r.events[len(r.events)-1]
+	th.Equals(t, PhaseFinished, last.Type)
+	th.Equals(t, "import", last.Phase)
+	th.Equals(t, c.Count(), last.Files)
+	th.Equals(t, "Cannot read 1 file(s)", last.Error)
+}
+
+func TestIsRunFile(t *testing.T) {
+	th.Equals(t, true, IsRunFile(AuditLogFileName))
+	th.Equals(t, true, IsRunFile("1_import/"+ReportFileName))
+	th.Equals(t, false, IsRunFile(ReportFileName))
+	th.Equals(t, false, IsRunFile("1_import/"+AuditLogFileName))
+	th.Equals(t, false, IsRunFile("1_import/photos/"+ReportFileName))
+	th.Equals(t, false, IsRunFile("1_import/photo.jpg"))
+}
+
+func TestScanKeepsFilesNamedLikeRunFiles(t *testing.T) {
+	// outside the staging folder these are ordinary files of the user
+	fs := createMemFsTestData()
+	th.Ok(t, afero.WriteFile(fs, "test_data/subfolder/"+ReportFileName, []byte("{}"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "test_data/"+AuditLogFileName, []byte("{}\n"), 0644))
+	c, err := Scan(context.Background(), afero.NewBasePathFs(fs, "test_data"))
+	th.Ok(t, err)
+	th.Equals(t, 6, c.Count())
+	c, err = SyncCatalogWithImportFolder(context.Background(), afero.NewBasePathFs(fs, "test_data"))
+	th.Ok(t, err)
+	th.Equals(t, 6, c.Count())
+}
+
+func TestSyncStagingSkipsRunFiles(t *testing.T) {
+	fs := createMemFsTestData()
+	th.Ok(t, afero.WriteFile(fs, "test_data/subfolder/"+ReportFileName, []byte("{}"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "test_data/subfolder/deeper/"+ReportFileName, []byte("{}"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "test_data/"+AuditLogFileName, []byte("{}\n"), 0644))
+	stagingFs := afero.NewBasePathFs(fs, "test_data")
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, catalog.NewCatalog())
+	th.Ok(t, err)
+	th.Equals(t, 5, c.Count())
+	_, err = c.Item("subfolder/" + ReportFileName)
+	th.Assert(t, err != nil, "the report is in the catalog")
+	_, err = c.Item(AuditLogFileName)
+	th.Assert(t, err != nil, "the audit log is in the catalog")
+	_, err = c.Item("subfolder/deeper/" + ReportFileName)
+	th.Ok(t, err)
+
+	// the files added later are skipped too
+	th.Ok(t, afero.WriteFile(fs, "test_data/subfolder/"+ReportFileName, []byte("{\"changed\": true}"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "test_data/"+AuditLogFileName, []byte("{}\n{}\n"), 0644))
+	c, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, catalog.NewCatalog())
+	th.Ok(t, err)
+	th.Equals(t, 5, c.Count())
+}
@@ -1,14 +1,16 @@
 package scan
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
@@ -19,66 +21,92 @@ import (
 // Delete (set of paths): these files are present in the catalog but not present in the FS anymore, have to be deleted
 // Update (set of paths): these files have different size, modification time or content in the catalog and the FS.
 //                          Probably a full re-scan should be done
+// Moved (new path to old path): these files are not in the catalog with their current path, but they are the same as
+//                          a file that is missing from the FS. Their content doesn't have to be read again.
+//                          These files are not in Add and Delete.
+// Ignored (set of paths): these files are in the catalog but they are excluded by the filter, they have to be forgotten
 type FileSystemDiff struct {
-	Ok     map[string]bool
-	Add    map[string]bool
-	Delete map[string]bool
-	Update map[string]bool
+	Ok      map[string]bool
+	Add     map[string]bool
+	Delete  map[string]bool
+	Update  map[string]bool
+	Moved   map[string]string
+	Ignored map[string]bool
 }
 
 // NewFileSystemDiff creates a new FileSystemDiff struct
 func NewFileSystemDiff() FileSystemDiff {
 	return FileSystemDiff{
-		Ok:     make(map[string]bool),
-		Add:    make(map[string]bool),
-		Delete: make(map[string]bool),
-		Update: make(map[string]bool),
+		Ok:      make(map[string]bool),
+		Add:     make(map[string]bool),
+		Delete:  make(map[string]bool),
+		Update:  make(map[string]bool),
+		Moved:   make(map[string]string),
+		Ignored: make(map[string]bool),
 	}
 }
 
-// Asynchronously enumerates all files in a folder, returns a channel that will
-// contain all the relative paths.
-// When the enumeration is finished an empty string is sent to the channel as the last item.
-func walkFolder(fs afero.Fs, root string, wg *sync.WaitGroup) <-chan string {
-	files := make(chan string, 100000)
+// checkFolder returns an error if the root folder of a scan doesn't exist
+func checkFolder(fs afero.Fs, root string) error {
 	if exist, err := afero.DirExists(fs, root); err != nil || !exist {
-		log.Fatalf("The folder '%v' doesn't exist", root)
+		return errors.Wrapf(ErrFolderNotFound, "Cannot scan '%v'", root)
 	}
-	go func() {
-		defer wg.Done()
-		afero.Walk(fs, root, func(path string, fi os.FileInfo, err error) error {
-			if !fi.IsDir() && fi.Name() != catalog.CatalogFileName {
-				files <- path
+	return nil
+}
+
+// walkFiltered walks the root folder and calls fn with the path and the metadata of each file that passes the filter.
+// The folders are passed to the filter too, the excluded ones are not walked. The catalog files, the run reports and the partial copies are skipped.
+// The files and folders that cannot be read are passed to fn with the error. The walk stops if fn returns an error.
+func walkFiltered(fs afero.Fs, root string, filter FileFilter, fn func(path string, fi os.FileInfo, err error) error) error {
+	return afero.Walk(fs, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fn(path, fi, err)
+		}
+		if fi.IsDir() {
+			if path != root && !filter.Include(path, fi) {
+				return filepath.SkipDir
 			}
 			return nil
-		})
-		files <- ""
-	}()
-	return files
+		}
+		if catalog.IsCatalogFile(fi.Name()) || fsh.IsPartialCopy(fi.Name()) || !filter.Include(path, fi) {
+			return nil
+		}
+		return fn(path, fi, nil)
+	})
 }
 
-// filters the paths read from the files channel and the one that pass the filter will be sent to the returned channel
-func filterFiles(files <-chan string, filter FileFilter, wg *sync.WaitGroup) chan string {
-	filtered := make(chan string, 10000)
-
+// Asynchronously enumerates the files in a folder that pass the filter, returns a channel that will
+// contain their relative paths. The folder is traversed only once, the totals of the progress bar are increased
+// as the files are found, so they can be processed while the walk is still running.
+// The files and folders that cannot be read are added to errs.
+// When the enumeration is finished or the context is cancelled an empty string is sent to the channel as the last item.
+func walkFolder(ctx context.Context, fs afero.Fs, root string, filter FileFilter, pb DoubleProgressBar, errs *fileErrors, wg *sync.WaitGroup) chan string {
+	files := make(chan string, 100000)
 	go func() {
 		defer wg.Done()
-		for file := range files {
-			if file == "" {
-				break
-			} else if filter.Include(file) {
-				filtered <- file
+		walkFiltered(fs, root, filter, func(path string, fi os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-		}
-		filtered <- ""
+			if err != nil {
+				errs.add(path, err)
+				return nil
+			}
+			pb.AddTotal(1, fi.Size())
+			files <- path
+			return nil
+		})
+		files <- ""
 	}()
-	return filtered
+	return files
 }
 
-func catalogFile(fs afero.Fs, path string, out chan catalog.Item, pb DoubleProgressBar) {
-	item, err := catalog.NewItem(fs, path)
+// catalogFile reads a file and sends its item to the out channel. If the file cannot be read it is added to errs.
+// The file is not read if its checksum is in the cache, the cache can be nil.
+func catalogFile(fs afero.Fs, path string, alg catalog.HashAlgorithm, cache catalog.HashCache, out chan catalog.Item, pb DoubleProgressBar, errs *fileErrors) {
+	item, err := catalog.NewItemWithCache(fs, path, alg, cache)
 	if err != nil {
-		log.Printf("Cannot read file '%v'", path)
+		errs.add(path, err)
 	} else {
 		pb.IncrBy(int(item.Size))
 		out <- *item
@@ -86,10 +114,11 @@ func catalogFile(fs afero.Fs, path string, out chan catalog.Item, pb DoubleProgr
 }
 
 // checkCatalogFile checks a given file (metadata and content) against a catalog
+// The checksum is calculated with the hash algorithm of the catalog, unless it is in the cache (the cache can be nil).
 // The file's path is sent to the ok if everything matches the catalog and to the changed channel otherwise.
 // Returns error if cannot read the file or it's not in the catalog.
-func checkCatalogFile(fs afero.Fs, path string, c catalog.Catalog, pb DoubleProgressBar, ok chan<- string, changed chan<- string) error {
-	item, err := catalog.NewItem(fs, path)
+func checkCatalogFile(fs afero.Fs, path string, c catalog.Catalog, cache catalog.HashCache, pb DoubleProgressBar, ok chan<- string, changed chan<- string) error {
+	item, err := catalog.NewItemWithCache(fs, path, c.HashAlgorithm(), cache)
 	if err != nil {
 		return errors.Errorf("Cannot read file '%v'", path)
 	}
@@ -101,7 +130,7 @@ func checkCatalogFile(fs afero.Fs, path string, c catalog.Catalog, pb DoubleProg
 		return errors.Errorf("Cannot find file in catalog '%v'", path)
 	}
 
-	if *item == itemInCatalog {
+	if item.SameContent(itemInCatalog) {
 		ok <- path
 	} else {
 		changed <- path
@@ -140,13 +169,18 @@ func quickCheckCatalogFile(fs afero.Fs, path string, c catalog.Catalog, pb Doubl
 	return nil
 }
 
-// readCatalogItems creates the CatalogItems for the incoming paths
-// Can be interrupted with a message sent to the done channel,
-// The processing can be interrupted by a message sent to the done channel.
+// readCatalogItems creates the CatalogItems for the incoming paths, the checksums are calculated with the given algorithm
+// or taken from the cache if it's not nil.
+// The files that cannot be read are added to errs.
+// The processing can be interrupted by cancelling the context, the remaining paths are dropped.
 // The paths channel must be buffered.
-func readCatalogItems(fs afero.Fs,
+func readCatalogItems(ctx context.Context,
+	fs afero.Fs,
 	paths chan string,
+	alg catalog.HashAlgorithm,
+	cache catalog.HashCache,
 	pb DoubleProgressBar,
+	errs *fileErrors,
 	globalWg *sync.WaitGroup) <-chan catalog.Item {
 
 	out := make(chan catalog.Item, 10)
@@ -162,7 +196,10 @@ func readCatalogItems(fs afero.Fs,
 						paths <- "" // make one of the siblings stop
 						break
 					}
-					catalogFile(fs, path, out, pb)
+					if ctx.Err() != nil {
+						continue
+					}
+					catalogFile(fs, path, alg, cache, out, pb, errs)
 				}
 				wg.Done()
 			}()
@@ -175,18 +212,21 @@ func readCatalogItems(fs afero.Fs,
 }
 
 // checkExistingItems checks the incoming files against a catalog
-// Processes the files in the paths channel, and calls checkCatalogFile on each of them.
-// At the first error sends a message on failed channel but carry on may processing the input until interrupted.
+// Processes the files in the paths channel, and calls checkCatalogFile (or quickCheckCatalogFile if deepCheck is false) on each of them.
+// The files that cannot be read are added to errs, they are not sent to any of the channels.
 // At each steps updated the progress bars with the number and size of processed files.
-// Can be interrupted at any time by sending a message to the done channel.
+// Can be interrupted at any time by cancelling the context, the remaining paths are dropped.
 // The paths channel must be buffered.
-func checkExistingItems(fs afero.Fs,
+func checkExistingItems(ctx context.Context,
+	fs afero.Fs,
 	deepCheck bool,
 	paths chan string,
 	c catalog.Catalog,
+	cache catalog.HashCache,
 	pb DoubleProgressBar,
 	ok chan<- string,
 	changed chan<- string,
+	errs *fileErrors,
 	globalWg *sync.WaitGroup) {
 
 	var wg sync.WaitGroup
@@ -202,15 +242,17 @@ func checkExistingItems(fs afero.Fs,
 						paths <- "" // make one of the siblings stop
 						break
 					}
+					if ctx.Err() != nil {
+						continue
+					}
+					var err error
 					if deepCheck {
-						if err := checkCatalogFile(fs, path, c, pb, ok, changed); err != nil {
-							log.Println(err)
-						}
+						err = checkCatalogFile(fs, path, c, cache, pb, ok, changed)
 					} else {
-						if err := quickCheckCatalogFile(fs, path, c, pb, ok, changed); err != nil {
-							log.Println(err)
-						}
-
+						err = quickCheckCatalogFile(fs, path, c, pb, ok, changed)
+					}
+					if err != nil {
+						errs.add(path, err)
 					}
 				}
 			}()
@@ -222,20 +264,39 @@ func checkExistingItems(fs afero.Fs,
 
 }
 
-func updateAndSaveCatalog(fs afero.Fs, c catalog.Catalog, catalogPath string, items <-chan catalog.Item,
-	result chan<- catalog.Catalog, wg *sync.WaitGroup) {
+// itemHandler puts an item read from the folder into the catalog
+type itemHandler func(c catalog.Catalog, item catalog.Item) error
+
+// addItem is the itemHandler of the full scans, it simply adds the item to the catalog
+func addItem(c catalog.Catalog, item catalog.Item) error {
+	return c.Add(item)
+}
+
+// updateAndSaveCatalog puts the incoming items into a copy of the catalog using the handler, and saves it periodically
+// if save is true. When all items are added the state of the catalog is changed to initialized and it is saved again.
+// If the handler returns an error, the rest of the items are dropped and the catalog is not saved again.
+// If the context is cancelled, the items received so far are saved even if save is false, and the state is not changed,
+// so the catalog shows that it is not complete.
+func updateAndSaveCatalog(ctx context.Context, fs afero.Fs, c catalog.Catalog, catalogPath string, items <-chan catalog.Item,
+	handle itemHandler, save bool, result chan<- catalog.Catalog, wg *sync.WaitGroup) {
 	defer wg.Done()
 	ret := c.Clone()
 	lastSave := time.Now()
+	failed := false
 	for item := range items {
 		if (item == catalog.Item{}) {
 			break
 		}
-		err := ret.Add(item)
+		if failed {
+			continue
+		}
+		err := handle(ret, item)
 		if err != nil {
 			log.Printf("Cannot save catalog: %v", err)
+			failed = true
+			continue
 		}
-		if time.Since(lastSave).Seconds() > 5.0 {
+		if save && time.Since(lastSave).Seconds() > 5.0 {
 			lastSave = time.Now()
 			err := ret.Write(fs)
 			if err != nil {
@@ -244,71 +305,75 @@ func updateAndSaveCatalog(fs afero.Fs, c catalog.Catalog, catalogPath string, it
 		}
 	}
 
-	err := ret.Write(fs)
-	if err != nil {
-		log.Printf("Failed to update catalog: %v", err)
+	interrupted := ctx.Err() != nil
+	if !failed && !interrupted {
+		ret.SetState(catalog.Initialized)
+	}
+	if !failed && (save || interrupted) {
+		err := ret.Write(fs)
+		if err != nil {
+			log.Printf("Failed to update catalog: %v", err)
+		}
 	}
 	result <- ret
 }
 
-func saveCatalog(fs afero.Fs, catalogPath string, items <-chan catalog.Item,
+// saveCatalog builds a new catalog from the incoming items with updateAndSaveCatalog. The empty catalog is saved in
+// initializing state before the first item is added, so if the scan doesn't finish, the next sync knows that the catalog
+// is not complete and continues the scan.
+func saveCatalog(ctx context.Context, fs afero.Fs, catalogPath string, alg catalog.HashAlgorithm, items <-chan catalog.Item,
 	result chan<- catalog.Catalog, wg *sync.WaitGroup) {
-	c := catalog.NewCatalog()
-	updateAndSaveCatalog(fs, c, catalogPath, items, result, wg)
-}
-
-// Counts the files and sums their sizes in a folder. Only files that pass the filter are counted.
-func fileStats(fs afero.Fs, root string, filter FileFilter) (count int64, size int64) {
-	if exist, err := afero.DirExists(fs, root); err != nil || !exist {
-		log.Fatalf("The folder '%v' doesn't exist", root)
+	c := catalog.NewCatalogWithAlgorithm(alg)
+	c.SetState(catalog.Initializing)
+	if err := c.Write(fs); err != nil {
+		log.Printf("Failed to update catalog: %v", err)
 	}
-	afero.Walk(fs, root, func(path string, fi os.FileInfo, err error) error {
-		if !fi.IsDir() && fi.Name() != catalog.CatalogFileName && filter.Include(fi.Name()) {
-			count++
-			size += fi.Size()
-		}
-		return nil
-	})
-	return
+	updateAndSaveCatalog(ctx, fs, c, catalogPath, items, addItem, true, result, wg)
 }
 
-// ScanFolder recursively scans the root folder and adds all files to the catalog
-func ScanFolder(fs afero.Fs, root string, filter FileFilter) catalog.Catalog {
-	fileCount, totalSize := fileStats(fs, root, filter)
-	pb := newDoubleProgressBar()
-	pb.SetTotal(fileCount, totalSize)
+// ScanFolder recursively scans the root folder and adds all files to the catalog.
+// The catalog is in initializing state until all files are added.
+// Returns an error caused by ErrFolderNotFound if the root folder doesn't exist.
+// If some files cannot be read, the catalog of the other files is returned with an UnreadableFilesError.
+// If the context is cancelled, the catalog of the files processed so far is saved and returned in initializing state
+// with the error of the context.
+func ScanFolder(ctx context.Context, fs afero.Fs, root string, filter FileFilter, opts ...Option) (catalog.Catalog, error) {
+	if err := checkFolder(fs, root); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	pb := o.progress.NewProgressBar("scan")
 
 	var wg sync.WaitGroup
-	wg.Add(4)
-	files := walkFolder(fs, root, &wg)
-	filteredFiles := filterFiles(files, filter, &wg)
-	items := readCatalogItems(fs, filteredFiles, pb, &wg)
+	wg.Add(3)
+	var errs fileErrors
+	files := walkFolder(ctx, fs, root, filter, pb, &errs, &wg)
+	items := readCatalogItems(ctx, fs, files, o.hashAlgorithm, o.hashCache, pb, &errs, &wg)
 	result := make(chan catalog.Catalog, 1)
 	catalogFilePath := filepath.Join(root, catalog.CatalogFileName)
-	go saveCatalog(fs, catalogFilePath, items, result, &wg)
+	go saveCatalog(ctx, fs, catalogFilePath, o.hashAlgorithm, items, result, &wg)
 	wg.Wait()
 	ret := <-result
 
 	pb.Wait()
-	return ret
+	if ctx.Err() != nil {
+		return ret, ctx.Err()
+	}
+	return ret, errs.err("")
 }
 
 // Scan recursively scans the whole file system
-func Scan(fs afero.Fs) catalog.Catalog {
-	return ScanFolder(fs, ".", noFilter{})
+func Scan(ctx context.Context, fs afero.Fs, opts ...Option) (catalog.Catalog, error) {
+	return ScanFolder(ctx, fs, ".", noFilter{}, opts...)
 }
 
 // walkDiff gets a set of file paths (as returned by Diff) and return their paths
-// the same way as walkFolder
+// the same way as walkFolder. The files are not checked, the ones that cannot be read are reported by readCatalogItems.
 func walkDiff(fs afero.Fs, paths map[string]bool, wg *sync.WaitGroup) chan string {
 	files := make(chan string, 100000)
 	go func() {
 		defer wg.Done()
 		for path := range paths {
-			_, err := fs.Stat(path)
-			if err != nil {
-				log.Printf("Cannot read file '%v'", path)
-			}
 			files <- path
 		}
 		files <- ""
@@ -317,45 +382,159 @@ func walkDiff(fs afero.Fs, paths map[string]bool, wg *sync.WaitGroup) chan strin
 }
 
 // fileStatsFromDiff gets a set of file paths (as returned by Diff) and return the
-// count of files and the sum of their sizes, the same way as fileStats
+// count of files and the sum of their sizes, the same way as walkFolder counts them
 func fileStatsFromDiff(fs afero.Fs, paths map[string]bool) (count int64, size int64) {
 	for path := range paths {
 		fi, err := fs.Stat(path)
 		if err != nil {
-			log.Printf("Cannot read file '%v'", path)
+			continue
 		}
+		count++
 		size += fi.Size()
 	}
-	return int64(len(paths)), size
+	return
 }
 
-// ScanAdd performs a scan on a folder and checks the contents against a catalog.
-// If new files are missing from the catalog they are added and a modified catalog is returned.
-func ScanAdd(fs afero.Fs, c catalog.Catalog, diff FileSystemDiff) catalog.Catalog {
+// filterPaths returns the paths of the set that pass the filter the same way as if the folder was walked with it.
+// The files that cannot be read are kept, they are reported when they are processed.
+func filterPaths(fs afero.Fs, paths map[string]bool, filter FileFilter) map[string]bool {
+	ret := make(map[string]bool, len(paths))
+	for path := range paths {
+		fi, err := fs.Stat(path)
+		if err != nil || includePath(filter, path, fi) {
+			ret[path] = true
+		}
+	}
+	return ret
+}
+
+// changeHandler decides how the changes found in a folder are applied to its catalog.
+// The folders of different roles (import, staging, collection) treat the same changes differently.
+type changeHandler struct {
+	// deleted is called for each file that is in the catalog but not in the folder anymore
+	deleted func(c catalog.Catalog, path string) error
+	// changed is called with the new item of each added or updated file
+	changed itemHandler
+	// save is true if the catalog is saved while the changes are applied, otherwise the caller saves it
+	save bool
+}
+
+// forgetChanges is the changeHandler of the folders that don't have to remember the deleted files
+var forgetChanges = changeHandler{
+	deleted: func(c catalog.Catalog, path string) error {
+		c.ForgetPath(path)
+		return nil
+	},
+	changed: func(c catalog.Catalog, item catalog.Item) error {
+		return c.Set(item)
+	},
+	save: true,
+}
+
+// hasContentChanges returns true if files were added, deleted, modified or ignored in the folder.
+// Moving files around doesn't change the content of the folder.
+func (d FileSystemDiff) hasContentChanges() bool {
+	return len(d.Add) > 0 || len(d.Delete) > 0 || len(d.Update) > 0 || len(d.Ignored) > 0
+}
+
+// scanChanges applies the differences between a folder and a catalog to a copy of the catalog.
+// Moved files are updated in the catalog without reading them, ignored files are forgotten,
+// deleted files are passed to the handler, and only
+// the added and updated files are read and hashed with the algorithm of the catalog, unless their checksums are in
+// the hash cache of the options. The new items are passed to
+// the handler. The catalog is in incomplete state until all changes are applied, then it is initialized.
+// If the handler returns an error, the error is returned and the catalog is not saved again.
+// If some files cannot be read, they are not changed in the catalog, and the catalog is returned with an UnreadableFilesError.
+// If files were only moved, the state of the catalog is not changed.
+// If the context is cancelled, the changes applied so far are saved in incomplete state and the error of the context is returned.
+func scanChanges(ctx context.Context, fs afero.Fs, c catalog.Catalog, diff FileSystemDiff, h changeHandler, o options) (catalog.Catalog, error) {
+	if !diff.hasContentChanges() {
+		if len(diff.Moved) == 0 {
+			return c, nil
+		}
+		moved := c.Clone()
+		return moved, applyMoves(moved, diff)
+	}
+	incomplete := c.Clone()
+	incomplete.SetState(catalog.Incomplete)
+	if err := applyMoves(incomplete, diff); err != nil {
+		return nil, err
+	}
+	for path := range diff.Ignored {
+		incomplete.ForgetPath(path)
+	}
+	for _, path := range sortedPaths(diff.Delete) {
+		if err := h.deleted(incomplete, path); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := make(map[string]bool, len(diff.Add)+len(diff.Update))
+	for path := range diff.Add {
+		changed[path] = true
+	}
+	for path := range diff.Update {
+		changed[path] = true
+	}
+	var handlerErr error
+	handle := func(c catalog.Catalog, item catalog.Item) error {
+		handlerErr = h.changed(c, item)
+		return handlerErr
+	}
+
 	var wg sync.WaitGroup
-	fileCount, totalSize := fileStatsFromDiff(fs, diff.Add)
-	pb := newDoubleProgressBar()
+	fileCount, totalSize := fileStatsFromDiff(fs, changed)
+	pb := o.progress.NewProgressBar("scan")
 	pb.SetTotal(fileCount, totalSize)
 
 	wg.Add(3)
 	const root = "."
-	files := walkDiff(fs, diff.Add, &wg)
-	items := readCatalogItems(fs, files, pb, &wg)
+	var errs fileErrors
+	files := walkDiff(fs, changed, &wg)
+	items := readCatalogItems(ctx, fs, files, c.HashAlgorithm(), o.hashCache, pb, &errs, &wg)
 
 	result := make(chan catalog.Catalog, 1)
 	catalogFilePath := filepath.Join(root, catalog.CatalogFileName)
-	go updateAndSaveCatalog(fs, c, catalogFilePath, items, result, &wg)
+	go updateAndSaveCatalog(ctx, fs, incomplete, catalogFilePath, items, handle, h.save, result, &wg)
 	wg.Wait()
 	ret := <-result
 	pb.Wait()
-	return ret
+	if handlerErr != nil {
+		return nil, handlerErr
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return ret, errs.err("")
+}
+
+// applyMoves updates the paths of the moved files in the catalog
+func applyMoves(c catalog.Catalog, diff FileSystemDiff) error {
+	for newPath, oldPath := range diff.Moved {
+		if err := c.Move(oldPath, newPath); err != nil {
+			return errors.Wrap(err, "Failed to move file")
+		}
+	}
+	return nil
+}
+
+// ScanAdd performs a scan on a folder and checks the contents against a catalog.
+// If new files are missing from the catalog they are added and a modified catalog is returned.
+// The checksums of the new files are calculated with the hash algorithm of the catalog.
+// The catalog is in incomplete state until all files are added.
+// Only the files that pass the filter given by WithFilter are added.
+// If some files cannot be read, the catalog with the other files is returned with an UnreadableFilesError.
+func ScanAdd(ctx context.Context, fs afero.Fs, c catalog.Catalog, diff FileSystemDiff, opts ...Option) (catalog.Catalog, error) {
+	o := newOptions(opts)
+	added := NewFileSystemDiff()
+	added.Add = filterPaths(fs, diff.Add, o.filter)
+	return scanChanges(ctx, fs, c, added, forgetChanges, o)
 }
 
 // filterByCatalog separate the incoming files (typically contents of the file system)
 // to two channels based on whether they are present in the catalog or not.
 // If an file read from the files channel is in the catalog (only the path is checked, no metadata, no contents)
 // it is put to known otherwise to unknown.
-// The processing can be interrupted by a message sent to the done channel.
 func filterByCatalog(files <-chan string, c catalog.Catalog, wg *sync.WaitGroup) (known chan string, unknown chan string) {
 	known = make(chan string, 100)
 	unknown = make(chan string, 100)
@@ -387,7 +566,7 @@ func collectFiles(c <-chan string, m map[string]bool, wg *sync.WaitGroup, label
 	}
 }
 
-func collectUnknownFiles(fs afero.Fs, c <-chan string, m map[string]bool, pb DoubleProgressBar, wg *sync.WaitGroup) {
+func collectUnknownFiles(fs afero.Fs, c <-chan string, m map[string]bool, pb DoubleProgressBar, errs *fileErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for file := range c {
 		if file == "" {
@@ -395,8 +574,8 @@ func collectUnknownFiles(fs afero.Fs, c <-chan string, m map[string]bool, pb Dou
 		}
 		fi, err := fs.Stat(file)
 		if err != nil {
-			fmt.Printf("Cannot get file size: %v\n", err)
-			return
+			errs.add(file, err)
+			continue
 		}
 		pb.IncrBy(int(fi.Size()))
 		m[file] = true
@@ -405,28 +584,40 @@ func collectUnknownFiles(fs afero.Fs, c <-chan string, m map[string]bool, pb Dou
 
 // DiffFiltered scans a folder and compares its contents to the contents of the catalog.
 // It performs a full scan and returns the file paths separated into multiple lists based on the file status.
-func DiffFiltered(fs afero.Fs, c catalog.Catalog, filter FileFilter, deepCheck bool) FileSystemDiff {
+// Returns an error caused by ErrFolderNotFound if the folder doesn't exist.
+// The files that cannot be read are left out of the diff, even if they are in the catalog, so they are not treated
+// as deleted. The files of the catalog that are excluded by the filter (or are in an excluded folder) are not deleted
+// either, they are ignored. The diff of the other files is returned with an UnreadableFilesError.
+// If deepCheck is true, the checksums of the files are compared too, the hash cache set with WithHashCache is used
+// for the files that haven't changed since they were last hashed.
+// If the context is cancelled, the returned diff is incomplete and the error of the context is returned.
+func DiffFiltered(ctx context.Context, fs afero.Fs, c catalog.Catalog, filter FileFilter, deepCheck bool, opts ...Option) (FileSystemDiff, error) {
+	o := newOptions(opts)
+	if err := checkFolder(fs, "."); err != nil {
+		return FileSystemDiff{}, err
+	}
 	okFiles := make(chan string, 100)
 	changedFiles := make(chan string, 100)
 	var wg sync.WaitGroup
-	wg.Add(7)
+	wg.Add(6)
 
-	count, size := fileStats(fs, ".", filter)
-	pb := newDoubleProgressBar()
-	pb.SetTotal(count, size)
+	pb := o.progress.NewProgressBar("diff")
 
-	files := walkFolder(fs, ".", &wg)
-	filteredFiles := filterFiles(files, filter, &wg)
-	knownFiles, unknownFiles := filterByCatalog(filteredFiles, c, &wg)
-	checkExistingItems(fs, deepCheck, knownFiles, c, pb, okFiles, changedFiles, &wg)
+	var errs fileErrors
+	files := walkFolder(ctx, fs, ".", filter, pb, &errs, &wg)
+	knownFiles, unknownFiles := filterByCatalog(files, c, &wg)
+	checkExistingItems(ctx, fs, deepCheck, knownFiles, c, o.hashCache, pb, okFiles, changedFiles, &errs, &wg)
 	ret := NewFileSystemDiff()
 
 	go collectFiles(okFiles, ret.Ok, &wg, "ok")
 	go collectFiles(changedFiles, ret.Update, &wg, "changed")
-	go collectUnknownFiles(fs, unknownFiles, ret.Add, pb, &wg)
+	go collectUnknownFiles(fs, unknownFiles, ret.Add, pb, &errs, &wg)
 	wg.Wait()
 
 	pb.Wait()
+	if ctx.Err() != nil {
+		return ret, ctx.Err()
+	}
 
 	for item := range c.AllItems() {
 		if item.Path == "" {
@@ -438,12 +629,128 @@ func DiffFiltered(fs afero.Fs, c catalog.Catalog, filter FileFilter, deepCheck b
 		if _, ok := ret.Update[item.Path]; ok {
 			continue
 		}
+		if errs.covers(item.Path) {
+			continue
+		}
+		if !includePath(filter, item.Path, itemFileInfo{item}) {
+			ret.Ignored[item.Path] = true
+			continue
+		}
 		ret.Delete[item.Path] = true
 	}
+	detectMoves(fs, c, ret, deepCheck, o.hashCache)
+	return ret, errs.err("")
+}
+
+// itemFileInfo provides the metadata of a catalog item for the filters, the file may not exist anymore
+type itemFileInfo struct {
+	item catalog.Item
+}
+
+func (i itemFileInfo) Name() string      { return filepath.Base(i.item.Path) }
+func (i itemFileInfo) Size() int64       { return i.item.Size }
+func (i itemFileInfo) Mode() os.FileMode { return 0 }
+func (i itemFileInfo) IsDir() bool       { return false }
+func (i itemFileInfo) Sys() interface{}  { return nil }
+func (i itemFileInfo) ModTime() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, i.item.ModificationTime)
+	return t
+}
+
+// moveKey contains the properties of a file that are compared to find the files that were moved
+type moveKey struct {
+	size             int64
+	modificationTime string
+}
+
+// sortedPaths returns the paths in a set in alphabetical order
+func sortedPaths(paths map[string]bool) []string {
+	ret := make([]string, 0, len(paths))
+	for path := range paths {
+		ret = append(ret, path)
+	}
+	sort.Strings(ret)
 	return ret
 }
 
+// detectMoves finds the added files that are the same as a deleted one, and moves them from Add and Delete to Moved.
+// The files are matched by their size and modification time. If there are more deleted files matching an added one,
+// the one with the same name is preferred. If deepCheck is true, the match is only accepted if the content of the
+// added file has the same checksum as the deleted item.
+func detectMoves(fs afero.Fs, c catalog.Catalog, diff FileSystemDiff, deepCheck bool, cache catalog.HashCache) {
+	candidates := make(map[moveKey][]string)
+	for _, path := range sortedPaths(diff.Delete) {
+		item, err := c.Item(path)
+		if err != nil {
+			continue
+		}
+		key := moveKey{size: item.Size, modificationTime: item.ModificationTime}
+		candidates[key] = append(candidates[key], path)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	for _, path := range sortedPaths(diff.Add) {
+		fi, err := fs.Stat(path)
+		if err != nil {
+			continue
+		}
+		key := moveKey{size: fi.Size(), modificationTime: fi.ModTime().Format(time.RFC3339Nano)}
+		oldPaths := preferSameName(candidates[key], filepath.Base(path))
+		for idx, oldPath := range oldPaths {
+			if deepCheck && !isSameContent(fs, c, path, oldPath, cache) {
+				continue
+			}
+			candidates[key] = append(oldPaths[:idx:idx], oldPaths[idx+1:]...)
+			delete(diff.Add, path)
+			delete(diff.Delete, oldPath)
+			diff.Moved[path] = oldPath
+			break
+		}
+	}
+}
+
+// preferSameName reorders the paths so the ones with the given file name come first
+func preferSameName(paths []string, name string) []string {
+	ret := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if filepath.Base(path) == name {
+			ret = append(ret, path)
+		}
+	}
+	for _, path := range paths {
+		if filepath.Base(path) != name {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+// isSameContent returns true if the checksum of the file in the FS equals the checksum of the item in the catalog.
+// The cache can be nil.
+func isSameContent(fs afero.Fs, c catalog.Catalog, path string, catalogPath string, cache catalog.HashCache) bool {
+	itemInCatalog, err := c.Item(catalogPath)
+	if err != nil {
+		return false
+	}
+	item, err := catalog.NewItemWithCache(fs, path, c.HashAlgorithm(), cache)
+	return err == nil && item.Checksum == itemInCatalog.Checksum
+}
+
+// verifyMoves checks the content of the moved files in the diff, and turns the ones that were not confirmed
+// back to an added and a deleted file.
+func verifyMoves(fs afero.Fs, c catalog.Catalog, diff FileSystemDiff, cache catalog.HashCache) {
+	for newPath, oldPath := range diff.Moved {
+		if !isSameContent(fs, c, newPath, oldPath, cache) {
+			delete(diff.Moved, newPath)
+			diff.Add[newPath] = true
+			diff.Delete[oldPath] = true
+		}
+	}
+}
+
 // Diff scans a folder and compares it to the catalog the same way as DiffFiltered does but without filtering out any files
-func Diff(fs afero.Fs, c catalog.Catalog, deepCheck bool) FileSystemDiff {
-	return DiffFiltered(fs, c, noFilter{}, deepCheck)
+func Diff(ctx context.Context, fs afero.Fs, c catalog.Catalog, deepCheck bool, opts ...Option) (FileSystemDiff, error) {
+	return DiffFiltered(ctx, fs, c, noFilter{}, deepCheck, opts...)
 }
@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -21,7 +22,7 @@ func TestWalkEmptyFolder(t *testing.T) {
 	fs.Mkdir("root", 0755)
 	var wg sync.WaitGroup
 	wg.Add(1)
-	files := walkFolder(fs, "root", &wg)
+	files := walkFolder(context.Background(), fs, "root", noFilter{}, newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
 	fileFound := false
 	select {
@@ -37,7 +38,7 @@ func TestWalkFolderOneLevel(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data/subfolder")
 	var wg sync.WaitGroup
 	wg.Add(1)
-	files := walkFolder(fs, "", &wg)
+	files := walkFolder(context.Background(), fs, "", noFilter{}, newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
 
 	expectedFiles := []string{"file1.bin", "file2.bin"}
@@ -49,7 +50,7 @@ func TestWalkFolderRecursive(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data")
 	var wg sync.WaitGroup
 	wg.Add(1)
-	files := walkFolder(fs, "", &wg)
+	files := walkFolder(context.Background(), fs, "", noFilter{}, newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
 
 	expectedFiles := []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}
@@ -62,7 +63,7 @@ func TestWalkFolderIgnoreCatalog(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	fs.Create(catalog.CatalogFileName)
-	files := walkFolder(fs, "", &wg)
+	files := walkFolder(context.Background(), fs, "", noFilter{}, newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
 
 	expectedFiles := []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}
@@ -70,17 +71,41 @@ func TestWalkFolderIgnoreCatalog(t *testing.T) {
 	th.Equals(t, expectedFiles, actualFiles)
 }
 
+func TestWalkFolderIgnoreCatalogBackup(t *testing.T) {
+	fs := fsh.CreateSafeFs("../test_data")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	fs.Create(catalog.CatalogFileName + ".v0.bak")
+	files := walkFolder(context.Background(), fs, "", noFilter{}, newMockDoubleProgressBar(), &fileErrors{}, &wg)
+	wg.Wait()
+
+	expectedFiles := []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}
+	actualFiles := cth.ReadStringChannel(files)
+	th.Equals(t, expectedFiles, actualFiles)
+}
+
+// walkTotals walks the folder and returns the totals it reported to the progress bar
+func walkTotals(fs afero.Fs, root string, filter FileFilter) (count int64, size int64) {
+	pb := newMockDoubleProgressBar()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	files := walkFolder(context.Background(), fs, root, filter, pb, &fileErrors{}, &wg)
+	wg.Wait()
+	cth.ReadStringChannel(files)
+	return pb.countTotal, pb.sizeTotal
+}
+
 func TestFileStatsEmptyFolder(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	fs.Mkdir("root", 0755)
-	count, size := fileStats(fs, "root", noFilter{})
+	count, size := walkTotals(fs, "root", noFilter{})
 	th.Equals(t, int64(0), count)
 	th.Equals(t, int64(0), size)
 }
 
 func TestFileStatsOneLevel(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data/subfolder")
-	count, size := fileStats(fs, "", noFilter{})
+	count, size := walkTotals(fs, "", noFilter{})
 
 	th.Equals(t, int64(2), count)
 	th.Equals(t, int64(1024+1500), size)
@@ -88,7 +113,7 @@ func TestFileStatsOneLevel(t *testing.T) {
 
 func TestFileStatsRecursive(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data")
-	count, size := fileStats(fs, "", noFilter{})
+	count, size := walkTotals(fs, "", noFilter{})
 
 	th.Equals(t, int64(4), count)
 	th.Equals(t, int64(1024+1500+1160+1304), size)
@@ -97,7 +122,7 @@ func TestFileStatsRecursive(t *testing.T) {
 func TestFileStatsIgnoreCatalog(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data")
 	fs.Create(catalog.CatalogFileName)
-	count, size := fileStats(fs, "", noFilter{})
+	count, size := walkTotals(fs, "", noFilter{})
 
 	th.Equals(t, int64(4), count)
 	th.Equals(t, int64(1024+1500+1160+1304), size)
@@ -106,57 +131,56 @@ func TestFileStatsIgnoreCatalog(t *testing.T) {
 func TestFileStatsFilter(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data")
 	fs.Create(catalog.CatalogFileName)
-	count, size := fileStats(fs, "", ExtensionFilter("txt"))
+	count, size := walkTotals(fs, "", ExtensionFilter("txt"))
 
 	th.Equals(t, int64(2), count)
 	th.Equals(t, int64(1024+1500), size)
 }
 
-func TestFilterEmptyChannel(t *testing.T) {
-	input := make(chan string)
+func TestWalkFolderFilter(t *testing.T) {
+	fs := fsh.CreateSafeFs("../test_data")
 	var wg sync.WaitGroup
 	wg.Add(1)
-	output := filterFiles(input, noFilter{}, &wg)
-	close(input)
+	files := walkFolder(context.Background(), fs, "", ExtensionFilter("bin", "jpg"), newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
-	itemFound := false
-	select {
-	case item := <-output:
-		itemFound = item != ""
-	default:
-	}
-	th.Equals(t, false, itemFound)
+
+	expectedFiles := []string{"test1.txt", "test2.txt"}
+	actualFiles := cth.ReadStringChannel(files)
+	th.Equals(t, expectedFiles, actualFiles)
 }
 
-func TestFilterNoFilter(t *testing.T) {
-	expected := []string{"orange", "pear", "apple", "melon"}
-	input := make(chan string)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	output := filterFiles(input, noFilter{}, &wg)
-	for _, item := range expected {
-		input <- item
-	}
-	close(input)
-	wg.Wait()
-	actual := cth.ReadStringChannel(output)
-	th.Equals(t, expected, actual)
+// pathFilter records the paths passed to it and excludes the listed ones
+type pathFilter struct {
+	excluded map[string]bool
+	seen     map[string]bool
 }
 
-func TestFilterExtension(t *testing.T) {
-	inputFiles := []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}
-	expected := []string{"test1.txt", "test2.txt"}
-	input := make(chan string)
+func (f pathFilter) Include(path string, fi os.FileInfo) bool {
+	f.seen[path] = fi.IsDir()
+	return !f.excluded[path]
+}
+
+func TestWalkFolderFilterGetsPaths(t *testing.T) {
+	fs := fsh.CreateSafeFs("../test_data")
+	filter := pathFilter{excluded: map[string]bool{"subfolder": true}, seen: make(map[string]bool)}
 	var wg sync.WaitGroup
 	wg.Add(1)
-	output := filterFiles(input, ExtensionFilter("bin", "jpg"), &wg)
-	for _, item := range inputFiles {
-		input <- item
-	}
-	close(input)
+	files := walkFolder(context.Background(), fs, "", filter, newMockDoubleProgressBar(), &fileErrors{}, &wg)
 	wg.Wait()
-	actual := cth.ReadStringChannel(output)
-	th.Equals(t, expected, actual)
+
+	th.Equals(t, []string{"test1.txt", "test2.txt"}, cth.ReadStringChannel(files))
+	th.Equals(t, map[string]bool{"subfolder": true, "test1.txt": false, "test2.txt": false}, filter.seen)
+}
+
+func TestFileStatsFilterGetsPaths(t *testing.T) {
+	fs := fsh.CreateSafeFs("../test_data")
+	filter := pathFilter{excluded: map[string]bool{"subfolder/file2.bin": true}, seen: make(map[string]bool)}
+	count, size := walkTotals(fs, "", filter)
+
+	th.Equals(t, int64(3), count)
+	th.Equals(t, int64(1024+1160+1304), size)
+	th.Equals(t, map[string]bool{"subfolder": true, "subfolder/file1.bin": false, "subfolder/file2.bin": false,
+		"test1.txt": false, "test2.txt": false}, filter.seen)
 }
 
 func TestCheckCatalogFileMissing(t *testing.T) {
@@ -165,7 +189,7 @@ func TestCheckCatalogFileMissing(t *testing.T) {
 	c := catalog.NewCatalog()
 	okFiles := make(chan string)
 	changedFiles := make(chan string)
-	th.Nok(t, checkCatalogFile(fs, "no_such_file", c, pb, okFiles, changedFiles), "Cannot read file 'no_such_file'")
+	th.Nok(t, checkCatalogFile(fs, "no_such_file", c, nil, pb, okFiles, changedFiles), "Cannot read file 'no_such_file'")
 	th.Equals(t, 0, pb.incrByCount)
 	th.Equals(t, 0, len(okFiles))
 	th.Equals(t, 0, len(changedFiles))
@@ -177,10 +201,11 @@ func TestCheckCatalogFileNotInCatalog(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("txt")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 	okFiles := make(chan string, 1)
 	changedFiles := make(chan string, 1)
-	th.Nok(t, checkCatalogFile(fs, "test1.txt", c, pb, okFiles, changedFiles), "Cannot find file in catalog 'test1.txt'")
+	th.Nok(t, checkCatalogFile(fs, "test1.txt", c, nil, pb, okFiles, changedFiles), "Cannot find file in catalog 'test1.txt'")
 	th.Equals(t, 1, pb.incrByCount)
 	th.Equals(t, int64(1), pb.CurrentCount())
 	th.Equals(t, int64(1160), pb.CurrentSize())
@@ -193,10 +218,11 @@ func TestCheckCatalogFileSuccess(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	okFiles := make(chan string, 1)
 	changedFiles := make(chan string, 1)
-	th.Ok(t, checkCatalogFile(fs, "test1.txt", c, pb, okFiles, changedFiles))
+	th.Ok(t, checkCatalogFile(fs, "test1.txt", c, nil, pb, okFiles, changedFiles))
 	th.Equals(t, 1, pb.incrByCount)
 	th.Equals(t, int64(1), pb.CurrentCount())
 	th.Equals(t, int64(1160), pb.CurrentSize())
@@ -209,12 +235,13 @@ func TestCheckCatalogFileMismatch(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := filepath.Join(filepath.Dir(basePath), "test_data")
 	fs := fsh.CreateSafeFs(path)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	modifiedFile := "test1.txt"
 	changeFileContent(fs, modifiedFile)
 	okFiles := make(chan string, 1)
 	changedFiles := make(chan string, 1)
-	th.Ok(t, checkCatalogFile(fs, modifiedFile, c, pb, okFiles, changedFiles))
+	th.Ok(t, checkCatalogFile(fs, modifiedFile, c, nil, pb, okFiles, changedFiles))
 	th.Equals(t, 1, pb.incrByCount)
 	th.Equals(t, int64(1), pb.CurrentCount())
 	th.Equals(t, int64(1175), pb.CurrentSize())
@@ -240,7 +267,8 @@ func TestQuickCheckCatalogFileNotInCatalog(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("txt")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 	okFiles := make(chan string, 1)
 	changedFiles := make(chan string, 1)
 	th.Nok(t, quickCheckCatalogFile(fs, "test1.txt", c, pb, okFiles, changedFiles), "Cannot find file in catalog 'test1.txt'")
@@ -256,7 +284,8 @@ func TestQuickCheckCatalogFileSuccess(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	okFiles := make(chan string, 1)
 	changedFiles := make(chan string, 1)
 	th.Ok(t, quickCheckCatalogFile(fs, "test1.txt", c, pb, okFiles, changedFiles))
@@ -272,7 +301,8 @@ func TestQuickCheckCatalogFileMismatch(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := filepath.Join(filepath.Dir(basePath), "test_data")
 	fs := fsh.CreateSafeFs(path)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	modifiedFile := "test1.txt"
 	changeFileContent(fs, modifiedFile)
 	okFiles := make(chan string, 1)
@@ -293,7 +323,8 @@ func TestQuickCheckCatalogFileContentMismatch(t *testing.T) {
 	timestamp := time.Now().Format(time.RFC3339Nano)
 	dummy0 := dummies[0]
 	createDummyFileWithTimestamp(fs, dummy0, timestamp)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	dummy0.Content = strings.ToUpper(dummy0.Content)
 	fs.Remove(dummy0.Path)
 	createDummyFileWithTimestamp(fs, dummy0, timestamp)
@@ -315,7 +346,8 @@ func TestQuickCheckCatalogFileSizeMismatch(t *testing.T) {
 	timestamp := time.Now().Format(time.RFC3339Nano)
 	dummy0 := dummies[0]
 	createDummyFileWithTimestamp(fs, dummy0, timestamp)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	dummy0.Content += "Some other text"
 	fs.Remove(dummy0.Path)
 	createDummyFileWithTimestamp(fs, dummy0, timestamp)
@@ -336,7 +368,8 @@ func TestQuickCheckCatalogFileModificationTimeMismatch(t *testing.T) {
 	fs := fsh.CreateSafeFs(path)
 	dummy0 := dummies[0]
 	createDummyFileWithTimestamp(fs, dummy0, time.Now().Format(time.RFC3339Nano))
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	fs.Remove(dummy0.Path)
 	createDummyFileWithTimestamp(fs, dummy0, time.Now().Format(time.RFC3339Nano))
 	okFiles := make(chan string, 1)
@@ -365,14 +398,15 @@ func TestCheckExistingItemsSuccess(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := filepath.Join(filepath.Dir(basePath), "test_data")
 	fs := fsh.CreateSafeFs(path)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	inputFiles := make(chan string, 4)
 	okFiles := make(chan string, 4)
 	changedFiles := make(chan string, 4)
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	checkExistingItems(fs, true, inputFiles, c, pb, okFiles, changedFiles, &wg)
+	checkExistingItems(context.Background(), fs, true, inputFiles, c, nil, pb, okFiles, changedFiles, &fileErrors{}, &wg)
 	inputFiles <- "test1.txt"
 	inputFiles <- "subfolder/file1.bin"
 	inputFiles <- "test2.txt"
@@ -390,14 +424,15 @@ func TestCheckExistingItemsMismatch(t *testing.T) {
 	pb := newMockDoubleProgressBar()
 	path := filepath.Join(filepath.Dir(basePath), "test_data")
 	fs := fsh.CreateSafeFs(path)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	inputFiles := make(chan string, 1)
 	okFiles := make(chan string, 4)
 	changedFiles := make(chan string, 4)
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go checkExistingItems(fs, true, inputFiles, c, pb, okFiles, changedFiles, &wg)
+	go checkExistingItems(context.Background(), fs, true, inputFiles, c, nil, pb, okFiles, changedFiles, &fileErrors{}, &wg)
 	changeFileContent(fs, "test2.txt")
 	inputFiles <- "subfolder/file1.bin"
 	inputFiles <- "test2.txt"
@@ -415,7 +450,8 @@ func TestCheckFilterByCatalogNoFiles(t *testing.T) {
 	basePath, _ := os.Getwd()
 	path := filepath.Join(filepath.Dir(basePath), "test_data")
 	fs := fsh.CreateSafeFs(path)
-	c := ScanFolder(fs, "", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
 	input := make(chan string, 1)
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -450,7 +486,8 @@ func TestCheckFilterByCatalogMixed(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("txt")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 
 	inputFiles := []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}
 	input := make(chan string, 10)
@@ -479,7 +516,7 @@ func TestReadCatalogItems(t *testing.T) {
 	input := make(chan string, 10)
 	var wg sync.WaitGroup
 	wg.Add(1)
-	catalogItems := readCatalogItems(fs, input, pb, &wg)
+	catalogItems := readCatalogItems(context.Background(), fs, input, catalog.MD5, nil, pb, &fileErrors{}, &wg)
 	for _, item := range inputFiles {
 		input <- item
 	}
@@ -519,7 +556,7 @@ func TestReadCatalogItemsEmpty(t *testing.T) {
 	input := make(chan string, 10)
 	var wg sync.WaitGroup
 	wg.Add(1)
-	catalogItems := readCatalogItems(fs, input, pb, &wg)
+	catalogItems := readCatalogItems(context.Background(), fs, input, catalog.MD5, nil, pb, &fileErrors{}, &wg)
 	input <- ""
 
 	wg.Wait()
@@ -537,11 +574,11 @@ func TestSaveCatalogEmpty(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go saveCatalog(fs, catalog.CatalogFileName, items, result, &wg)
+	go saveCatalog(context.Background(), fs, catalog.CatalogFileName, catalog.MD5, items, result, &wg)
 	items <- catalog.Item{}
 	wg.Wait()
 	c := <-result
-	th.Equals(t, catalog.NewCatalog(), c)
+	th.Equals(t, newInitializedCatalog(), c)
 }
 
 func TestSaveCatalog(t *testing.T) {
@@ -553,7 +590,7 @@ func TestSaveCatalog(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go saveCatalog(fs, catalog.CatalogFileName, items, result, &wg)
+	go saveCatalog(context.Background(), fs, catalog.CatalogFileName, catalog.MD5, items, result, &wg)
 	item1, err := catalog.NewItem(fs, "test1.txt")
 	th.Ok(t, err)
 	item2, err := catalog.NewItem(fs, "subfolder/file1.bin")
@@ -563,7 +600,7 @@ func TestSaveCatalog(t *testing.T) {
 	items <- catalog.Item{}
 	wg.Wait()
 	c := <-result
-	expectedCatalog := catalog.NewCatalog()
+	expectedCatalog := newInitializedCatalog()
 	expectedCatalog.Add(*item1)
 	expectedCatalog.Add(*item2)
 	th.Equals(t, expectedCatalog, c)
@@ -1,20 +1,26 @@
 package scan
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
 	fsh "github.com/mitro42/coback/fshelper"
 	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
 func TestEmptyFoldersCatalogIsEmpty(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	fs.Mkdir("root", 0755)
-	c := ScanFolder(fs, "root", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "root", noFilter{})
+	th.Ok(t, err)
 	th.Equals(t, c.Count(), 0)
 	th.Equals(t, c.DeletedCount(), 0)
 }
@@ -27,13 +33,14 @@ func checkFilesInCatalog(t *testing.T, c catalog.Catalog, path string, size int6
 	item1, err := c.Item(path)
 	th.Ok(t, err)
 	th.Equals(t, path, item1.Path)
-	th.Equals(t, md5sum, item1.Md5Sum)
+	th.Equals(t, md5sum, item1.Checksum)
 	th.Equals(t, size, item1.Size)
 }
 
 func TestScanOneLevelFolder(t *testing.T) {
 	fs := fsh.CreateSafeFs("../test_data")
-	c := ScanFolder(fs, "subfolder", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "subfolder", noFilter{})
+	th.Ok(t, err)
 
 	th.Equals(t, c.Count(), 2)
 	th.Equals(t, c.DeletedCount(), 0)
@@ -45,7 +52,8 @@ func TestScanOneLevelFolder(t *testing.T) {
 func TestScanFolderRecursive(t *testing.T) {
 	basePath, _ := os.Getwd()
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
-	c := ScanFolder(fs, "test_data", noFilter{})
+	c, err := ScanFolder(context.Background(), fs, "test_data", noFilter{})
+	th.Ok(t, err)
 
 	th.Equals(t, c.Count(), 4)
 	th.Equals(t, c.DeletedCount(), 0)
@@ -58,7 +66,8 @@ func TestScanFolderRecursive(t *testing.T) {
 func TestScanRecursive(t *testing.T) {
 	basePath, _ := os.Getwd()
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), "test_data"))
-	c := Scan(fs)
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
 
 	th.Equals(t, c.Count(), 4)
 	th.Equals(t, c.DeletedCount(), 0)
@@ -71,7 +80,8 @@ func TestScanRecursive(t *testing.T) {
 func TestScanWithExtensionFilter(t *testing.T) {
 	basePath, _ := os.Getwd()
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
-	c := ScanFolder(fs, "test_data", ExtensionFilter("txt"))
+	c, err := ScanFolder(context.Background(), fs, "test_data", ExtensionFilter("txt"))
+	th.Ok(t, err)
 
 	th.Equals(t, c.Count(), 2)
 	th.Equals(t, c.DeletedCount(), 0)
@@ -82,7 +92,8 @@ func TestScanWithExtensionFilter(t *testing.T) {
 func TestScanWithExtensionFilter2(t *testing.T) {
 	basePath, _ := os.Getwd()
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
-	c := ScanFolder(fs, "test_data", ExtensionFilter("txt", "bin"))
+	c, err := ScanFolder(context.Background(), fs, "test_data", ExtensionFilter("txt", "bin"))
+	th.Ok(t, err)
 
 	th.Equals(t, c.Count(), 0)
 	th.Equals(t, c.DeletedCount(), 0)
@@ -92,8 +103,10 @@ func TestDiffOk(t *testing.T) {
 	basePath, _ := os.Getwd()
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
-	c := Scan(fs)
-	diff := Diff(fs, c, true)
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	th.Equals(t, 0, len(diff.Add))
 	th.Equals(t, 0, len(diff.Delete))
 	th.Equals(t, 0, len(diff.Update))
@@ -105,8 +118,10 @@ func TestDiffFileMissingFromCatalog(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("bin")
-	c := ScanFolder(fs, "", filter)
-	diff := Diff(fs, c, true)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	expAdd := map[string]bool{"subfolder/file1.bin": true, "subfolder/file2.bin": true}
 	th.Equals(t, expAdd, diff.Add)
 	th.Equals(t, 0, len(diff.Delete))
@@ -117,11 +132,13 @@ func TestDiffFileMissingFromCatalog(t *testing.T) {
 func TestDiffFileMissingFromDisk(t *testing.T) {
 	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
 
-	c := ScanFolder(fs, "", noFilter{})
-	err := fs.Remove("test1.txt")
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
+	err = fs.Remove("test1.txt")
 	th.Ok(t, err)
 
-	diff := Diff(fs, c, true)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	expDelete := map[string]bool{"test1.txt": true}
 	th.Equals(t, 0, len(diff.Add))
 	th.Equals(t, expDelete, diff.Delete)
@@ -134,13 +151,15 @@ func TestDiffItemChecksumMismatch(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("bin")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 	item, err := c.Item("test1.txt")
 	th.Ok(t, err)
-	item.Md5Sum = "abcdef"
+	item.Checksum = "abcdef"
 	err = c.Set(item)
 	th.Ok(t, err)
-	diff := Diff(fs, c, true)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	expAdd := map[string]bool{"subfolder/file1.bin": true, "subfolder/file2.bin": true}
 	expUpdate := map[string]bool{"test1.txt": true}
 	th.Equals(t, expAdd, diff.Add)
@@ -154,13 +173,15 @@ func TestDiffItemSizeMismatch(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("bin")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 	item, err := c.Item("test1.txt")
 	th.Ok(t, err)
 	item.Size = 6854
 	err = c.Set(item)
 	th.Ok(t, err)
-	diff := Diff(fs, c, true)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	expAdd := map[string]bool{"subfolder/file1.bin": true, "subfolder/file2.bin": true}
 	expUpdate := map[string]bool{"test1.txt": true}
 	th.Equals(t, expAdd, diff.Add)
@@ -174,13 +195,15 @@ func TestDiffItemModificationTimeMismatch(t *testing.T) {
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
 	filter := ExtensionFilter("bin")
-	c := ScanFolder(fs, "", filter)
+	c, err := ScanFolder(context.Background(), fs, "", filter)
+	th.Ok(t, err)
 	item, err := c.Item("test1.txt")
 	th.Ok(t, err)
 	item.ModificationTime = "1924"
 	err = c.Set(item)
 	th.Ok(t, err)
-	diff := Diff(fs, c, true)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
 	expAdd := map[string]bool{"subfolder/file1.bin": true, "subfolder/file2.bin": true}
 	expUpdate := map[string]bool{"test1.txt": true}
 	th.Equals(t, expAdd, diff.Add)
@@ -193,15 +216,18 @@ func TestScanAdd(t *testing.T) {
 	basePath, _ := os.Getwd()
 	path := "test_data"
 	fs := fsh.CreateSafeFs(filepath.Join(filepath.Dir(basePath), path))
-	c := Scan(fs)
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
 
 	dummy0 := dummies[0]
 	dummy1 := dummies[1]
 	createDummyFile(fs, dummy0)
 	createDummyFile(fs, dummy1)
 
-	diff := Diff(fs, c, true)
-	c2 := ScanAdd(fs, c, diff)
+	diff, err := Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
+	c2, err := ScanAdd(context.Background(), fs, c, diff)
+	th.Ok(t, err)
 
 	th.Equals(t, 4, c.Count())
 	th.Equals(t, 0, c.DeletedCount())
@@ -211,6 +237,358 @@ func TestScanAdd(t *testing.T) {
 	checkFilesInCatalog(t, c2, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
 	checkFilesInCatalog(t, c2, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
 	checkFilesInCatalog(t, c2, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
-	checkFilesInCatalog(t, c2, dummy0.Path, dummy0.Size, dummy0.Md5Sum)
-	checkFilesInCatalog(t, c2, dummy1.Path, dummy1.Size, dummy1.Md5Sum)
+	checkFilesInCatalog(t, c2, dummy0.Path, dummy0.Size, dummy0.Checksum)
+	checkFilesInCatalog(t, c2, dummy1.Path, dummy1.Size, dummy1.Checksum)
+}
+
+func TestDiffFileMoved(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	th.Ok(t, err)
+	th.Ok(t, fs.MkdirAll("other/folder", 0755))
+	th.Ok(t, fs.Rename("test1.txt", "other/folder/test1.txt"))
+	th.Ok(t, fs.Rename("subfolder/file1.bin", "renamed.bin"))
+
+	for _, deepCheck := range []bool{false, true} {
+		diff, err := Diff(context.Background(), fs, c, deepCheck)
+		th.Ok(t, err)
+		expMoved := map[string]string{"other/folder/test1.txt": "test1.txt", "renamed.bin": "subfolder/file1.bin"}
+		th.Equals(t, expMoved, diff.Moved)
+		th.Equals(t, 0, len(diff.Add))
+		th.Equals(t, 0, len(diff.Delete))
+		th.Equals(t, 0, len(diff.Update))
+		th.Equals(t, 2, len(diff.Ok))
+	}
+}
+
+func TestDiffFileMovedPrefersSameName(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	const modificationTime = "2019-01-02T03:04:05Z"
+	a := dummyFileDescription{Path: "a", Content: "some content"}
+	b := dummyFileDescription{Path: "b", Content: "other stuff!"}
+	th.Ok(t, createDummyFileWithTimestamp(fs, a, modificationTime))
+	th.Ok(t, createDummyFileWithTimestamp(fs, b, modificationTime))
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+
+	th.Ok(t, fs.Mkdir("x", 0755))
+	th.Ok(t, fs.Rename("a", "x/c"))
+	th.Ok(t, fs.Rename("b", "x/b"))
+	diff, err := Diff(context.Background(), fs, c, false)
+	th.Ok(t, err)
+	th.Equals(t, map[string]string{"x/b": "b", "x/c": "a"}, diff.Moved)
+}
+
+func TestDiffFileMovedDifferentContent(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	const modificationTime = "2019-01-02T03:04:05Z"
+	th.Ok(t, createDummyFileWithTimestamp(fs, dummyFileDescription{Path: "a", Content: "some content"}, modificationTime))
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	th.Ok(t, fs.Remove("a"))
+	th.Ok(t, createDummyFileWithTimestamp(fs, dummyFileDescription{Path: "b", Content: "other stuff!"}, modificationTime))
+
+	// without checking the content the files look the same
+	diff, err := Diff(context.Background(), fs, c, false)
+	th.Ok(t, err)
+	th.Equals(t, map[string]string{"b": "a"}, diff.Moved)
+	verifyMoves(fs, c, diff, nil)
+	th.Equals(t, 0, len(diff.Moved))
+	th.Equals(t, map[string]bool{"b": true}, diff.Add)
+	th.Equals(t, map[string]bool{"a": true}, diff.Delete)
+
+	diff, err = Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
+	th.Equals(t, 0, len(diff.Moved))
+	th.Equals(t, map[string]bool{"b": true}, diff.Add)
+	th.Equals(t, map[string]bool{"a": true}, diff.Delete)
+}
+
+func TestScanChangesHandlerError(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	th.Ok(t, createDummyFile(fs, dummies[0]))
+	th.Ok(t, fs.Remove("test1.txt"))
+	diff, err := Diff(context.Background(), fs, c, false)
+	th.Ok(t, err)
+
+	deleted := make([]string, 0)
+	h := changeHandler{
+		deleted: func(c catalog.Catalog, path string) error {
+			deleted = append(deleted, path)
+			c.ForgetPath(path)
+			return nil
+		},
+		changed: func(c catalog.Catalog, item catalog.Item) error {
+			return errors.Errorf("Rejected: %v", item.Path)
+		},
+		save: true,
+	}
+	c2, err := scanChanges(context.Background(), fs, c, diff, h, newOptions(nil))
+	th.NokPrefix(t, err, "Rejected: subfolder/dummy1")
+	th.Equals(t, nil, c2)
+	th.Equals(t, []string{"test1.txt"}, deleted)
+	th.Equals(t, 4, c.Count())
+	cRead, err := catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, c, cRead)
+}
+
+func TestScanFolderCancelled(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c, err := ScanFolder(ctx, fs, "", noFilter{})
+	th.Equals(t, context.Canceled, err)
+	th.Equals(t, 0, c.Count())
+	th.Equals(t, catalog.Initializing, c.State())
+	cRead, err := catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initializing, cRead.State())
+}
+
+func TestScanFolderMissing(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c, err := ScanFolder(context.Background(), fs, "root", noFilter{})
+	th.NokPrefix(t, err, "Cannot scan 'root'")
+	th.Equals(t, ErrFolderNotFound, errors.Cause(err))
+	th.Equals(t, nil, c)
+	_, err = Diff(context.Background(), afero.NewBasePathFs(fs, "root"), catalog.NewCatalog(), false)
+	th.Equals(t, ErrFolderNotFound, errors.Cause(err))
+}
+
+func TestScanFolderUnreadableFile(t *testing.T) {
+	fs := cth.NewUnreadableFs(afero.NewBasePathFs(createMemFsTestData(), "test_data"), "test1.txt")
+	c, err := ScanFolder(context.Background(), fs, "", noFilter{})
+	unreadable, ok := err.(*UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, 1, len(unreadable.Files))
+	th.Equals(t, "test1.txt", unreadable.Files[0].Path)
+	th.Equals(t, 3, c.Count())
+	th.Equals(t, catalog.Initialized, c.State())
+	_, err = c.Item("test1.txt")
+	th.NokPrefix(t, err, "No such file")
+}
+
+func TestDiffUnreadableFolderIsNotDeleted(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+
+	diff, err := Diff(context.Background(), cth.NewUnreadableFs(fs, "subfolder"), c, true)
+	unreadable, ok := err.(*UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, "subfolder", unreadable.Files[0].Path)
+	th.Equals(t, 0, len(diff.Delete))
+	th.Equals(t, 0, len(diff.Add))
+	th.Equals(t, 2, len(diff.Ok))
+}
+
+// gatedFs blocks reading the gate folder until the file is opened, or a timeout expires
+type gatedFs struct {
+	afero.Fs
+	file     string
+	gate     string
+	opened   chan struct{}
+	once     sync.Once
+	timedOut bool
+}
+
+func (fs *gatedFs) Open(name string) (afero.File, error) {
+	switch filepath.Clean(name) {
+	case fs.file:
+		fs.once.Do(func() { close(fs.opened) })
+	case fs.gate:
+		select {
+		case <-fs.opened:
+		case <-time.After(5 * time.Second):
+			fs.timedOut = true
+		}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestScanFolderReadsFilesWhileWalking(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(memFs, "a/1.txt", []byte("first"), 0644))
+	th.Ok(t, afero.WriteFile(memFs, "b/2.txt", []byte("second"), 0644))
+	fs := &gatedFs{Fs: memFs, file: filepath.Join("a", "1.txt"), gate: "b", opened: make(chan struct{})}
+
+	recorder := &progressRecorder{}
+	c, err := ScanFolder(context.Background(), fs, ".", noFilter{}, WithProgress(recorder))
+	th.Ok(t, err)
+	th.Equals(t, false, fs.timedOut)
+	th.Equals(t, 2, c.Count())
+	checkProgressTotals(t, recorder.bars)
+}
+
+func TestScanAddWithFilter(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	th.Ok(t, createDummyFile(fs, dummies[0]))
+	th.Ok(t, createDummyFile(fs, dummies[1]))
+
+	diff, err := Diff(context.Background(), fs, c, false)
+	th.Ok(t, err)
+	filter := pathFilter{excluded: map[string]bool{"subfolder": true}, seen: make(map[string]bool)}
+	c2, err := ScanAdd(context.Background(), fs, c, diff, WithFilter(filter))
+	th.Ok(t, err)
+	th.Equals(t, 5, c2.Count())
+	checkFilesInCatalog(t, c2, dummies[1].Path, dummies[1].Size, dummies[1].Checksum)
+	_, err = c2.Item(dummies[0].Path)
+	th.NokPrefix(t, err, "No such file")
+}
+
+func TestIncludePathChecksParentFolders(t *testing.T) {
+	filter := pathFilter{excluded: map[string]bool{"a/b": true}, seen: make(map[string]bool)}
+	th.Equals(t, false, includePath(filter, "a/b/c/d.jpg", cth.FileInfo{}))
+	th.Equals(t, map[string]bool{"a": true, "a/b": true}, filter.seen)
+	th.Equals(t, true, includePath(filter, "a/c/d.jpg", cth.FileInfo{}))
+	th.Equals(t, true, includePath(filter, "d.jpg", cth.FileInfo{}))
+	th.Equals(t, false, includePath(filter, "a/b", cth.FileInfo{}))
+}
+
+// progressRecorder is a ProgressSink that collects mock progress bars to check the reported totals
+type progressRecorder struct {
+	mu   sync.Mutex
+	bars []*mockDoubleProgressBar
+}
+
+func (r *progressRecorder) NewProgressBar(name string) DoubleProgressBar {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pb := newMockDoubleProgressBar()
+	r.bars = append(r.bars, pb)
+	return pb
+}
+
+// checkProgressTotals checks that the totals set in the progress bars match the files processed
+func checkProgressTotals(t *testing.T, bars []*mockDoubleProgressBar) {
+	t.Helper()
+	th.Assert(t, len(bars) > 0, "no progress bar was created")
+	for _, pb := range bars {
+		th.Assert(t, pb.setTotalCount == 1 && pb.addTotalCount == 0 || pb.setTotalCount == 0,
+			"SetTotal and AddTotal were both used")
+		th.Equals(t, pb.countTotal, pb.count)
+		th.Equals(t, pb.sizeTotal, pb.size)
+	}
+}
+
+// progressTestFilters are the filters used to check that the progress totals match the processed files
+func progressTestFilters(t *testing.T, fs afero.Fs) map[string]FileFilter {
+	bins, err := GlobFilter("**/*.bin")
+	th.Ok(t, err)
+	return map[string]FileFilter{
+		"none":      noFilter{},
+		"extension": ExtensionFilter("txt"),
+		"ignore":    IgnoreFilter(fs),
+		"folder":    pathFilter{excluded: map[string]bool{"subfolder": true}, seen: make(map[string]bool)},
+		"and":       And(MinSizeFilter(1100), bins),
+		"or":        Or(MaxSizeFilter(1100), ExtensionFilter("bin")),
+		"not":       Not(bins),
+	}
+}
+
+func TestProgressTotalsMatchScannedFiles(t *testing.T) {
+	recorder := &progressRecorder{}
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	th.Ok(t, afero.WriteFile(fs, "subfolder/"+IgnoreFileName, []byte("file1.bin\n"), 0644))
+	for name, filter := range progressTestFilters(t, fs) {
+		recorder.bars = nil
+		c, err := ScanFolder(context.Background(), fs, ".", filter, WithProgress(recorder))
+		th.Ok(t, err)
+		checkProgressTotals(t, recorder.bars)
+		th.Assert(t, recorder.bars[0].count == int64(c.Count()), "%v: %v files counted, %v scanned", name, recorder.bars[0].count, c.Count())
+	}
+}
+
+func TestProgressTotalsMatchDiffAndAddedFiles(t *testing.T) {
+	recorder := &progressRecorder{}
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	th.Ok(t, createDummyFile(fs, dummies[0]))
+	th.Ok(t, createDummyFile(fs, dummies[1]))
+	th.Ok(t, changeFileContent(fs, "test1.txt"))
+	th.Ok(t, afero.WriteFile(fs, IgnoreFileName, []byte("dummy2\n"), 0644))
+	for name, filter := range progressTestFilters(t, fs) {
+		recorder.bars = nil
+		diff, err := DiffFiltered(context.Background(), fs, c, filter, true, WithProgress(recorder))
+		th.Ok(t, err)
+		checkProgressTotals(t, recorder.bars)
+		processed := int64(len(diff.Ok) + len(diff.Update) + len(diff.Add))
+		th.Assert(t, recorder.bars[0].count == processed, "%v: %v files counted, %v diffed", name, recorder.bars[0].count, processed)
+
+		recorder.bars = nil
+		added, err := ScanAdd(context.Background(), fs, c, diff, WithFilter(filter), WithProgress(recorder))
+		th.Ok(t, err)
+		if added.Count() == c.Count() {
+			// nothing to add, no progress is shown
+			th.Equals(t, 0, len(recorder.bars))
+			continue
+		}
+		checkProgressTotals(t, recorder.bars)
+		th.Assert(t, recorder.bars[0].count == int64(added.Count()-c.Count()), "%v: %v files counted, %v added",
+			name, recorder.bars[0].count, added.Count()-c.Count())
+	}
+}
+
+func TestProgressTotalsMatchSyncedFiles(t *testing.T) {
+	recorder := &progressRecorder{}
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	th.Ok(t, afero.WriteFile(importFs, IgnoreFileName, []byte("test2.txt\n"), 0644))
+	_, err = SyncCatalogWithImportFolder(context.Background(), importFs, WithFilter(ExtensionFilter("bin")), WithProgress(recorder))
+	th.Ok(t, err)
+	checkProgressTotals(t, recorder.bars)
+	th.Equals(t, int64(1), recorder.bars[0].count)
+}
+
+// pathHashCache is a catalog.HashCache that identifies the files by their path
+type pathHashCache struct {
+	mu   sync.Mutex
+	sums map[string]catalog.Checksum
+}
+
+func newPathHashCache() *pathHashCache {
+	return &pathHashCache{sums: make(map[string]catalog.Checksum)}
+}
+
+func (c *pathHashCache) Checksum(fs afero.Fs, path string, fi os.FileInfo, alg catalog.HashAlgorithm) (catalog.Checksum, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum, ok := c.sums[path]
+	return sum, ok && sum.Algorithm() == alg
+}
+
+func (c *pathHashCache) Add(fs afero.Fs, path string, fi os.FileInfo, sum catalog.Checksum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sums[path] = sum
+}
+
+func TestScanFolderWithHashCache(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	cache := newPathHashCache()
+	c, err := Scan(context.Background(), fs, WithHashCache(cache))
+	th.Ok(t, err)
+	th.Equals(t, 4, len(cache.sums))
+	th.Equals(t, catalog.Checksum("b3cd1cf6179bca32fd5d76473b129117"), cache.sums["test1.txt"])
+
+	// the cached checksums are used without reading the files
+	cache.sums["test1.txt"] = "11111111111111111111111111111111"
+	c, err = Scan(context.Background(), fs, WithHashCache(cache))
+	th.Ok(t, err)
+	checkFilesInCatalog(t, c, "test1.txt", 1160, "11111111111111111111111111111111")
+
+	diff, err := Diff(context.Background(), fs, c, true, WithHashCache(cache))
+	th.Ok(t, err)
+	th.Equals(t, 4, len(diff.Ok))
+	diff, err = Diff(context.Background(), fs, c, true)
+	th.Ok(t, err)
+	th.Equals(t, map[string]bool{"test1.txt": true}, diff.Update)
 }
@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/mitro42/coback/catalog"
@@ -23,128 +24,269 @@ func InitializeFolder(baseFs afero.Fs, path string) (afero.Fs, error) {
 	return fs, nil
 }
 
+// ErrCorruptedCatalog is the cause of the error returned by the sync functions if the catalog of the folder
+// is marked as corrupted and it wasn't allowed to repair it
+var ErrCorruptedCatalog = errors.New("The catalog is marked as corrupted")
+
+// markCorrupted marks the catalog as corrupted and saves it, so the folder is not used again until the catalog is
+// repaired. The deleted checksums are kept, a repair rescans the folder but doesn't lose them.
+// Returns err, the mismatch that was found.
+func (o options) markCorrupted(fs afero.Fs, c catalog.Catalog, err error) error {
+	c.SetState(catalog.Corrupted)
+	if writeErr := c.Write(fs); writeErr != nil {
+		o.message("Failed to mark the catalog as corrupted: %v", writeErr)
+	}
+	return err
+}
+
 // readAndDiffCatalog attempts to read a catalog. If the catalog is present, it diffs the contents with the file system.
-// If the catalog is missing a full scan is performed and an empty diff is returned.
-func readAndDiffCatalog(fs afero.Fs, name string) (catalog.Catalog, FileSystemDiff, error) {
-	fmt.Println("Reading catalog")
+// If the catalog was written by a newer version of CoBack an error is returned, so that it is not overwritten.
+// If the catalog is marked as corrupted it is repaired if the options allow it, otherwise an error is returned.
+// If the catalog file is damaged, its newest valid backup is used and a warning naming it is reported.
+// If the catalog is missing a full scan is performed with the requested hash algorithm and an empty diff is returned.
+// The files excluded by the filter of the options are left out of the scan, and they are ignored by the diff.
+// The files that cannot be read are added to errs.
+// Returns the error of the context if it is cancelled.
+func readAndDiffCatalog(ctx context.Context, fs afero.Fs, name string, o options, errs *fileErrors) (catalog.Catalog, FileSystemDiff, error) {
+	o.message("Reading catalog")
 	c, err := catalog.Read(fs, catalog.CatalogFileName)
-	if err != nil {
-		fmt.Println("Cannot read catalog. Folder must be rescanned...")
-		c = Scan(fs)
+	if _, ok := err.(*catalog.BackupUsedError); ok {
+		// the backup is still better than a rescan, which would lose all the deleted files
+		o.message("Warning: %v", err)
+		err = nil
+	}
+	if errors.Cause(err) == catalog.ErrUnsupportedVersion {
+		return nil, FileSystemDiff{}, err
+	} else if err != nil {
+		o.message("Cannot read catalog. Folder must be rescanned...")
+		c, err = ScanFolder(ctx, fs, ".", o.filter, o.forward(o.hashAlgorithm)...)
+		if err = errs.check(err); err != nil {
+			return nil, FileSystemDiff{}, err
+		}
 		return c, NewFileSystemDiff(), nil
 	}
-	fmt.Println("Comparing folder contents with catalog")
-	diff := Diff(fs, c, false)
+
+	switch c.State() {
+	case catalog.Corrupted:
+		if !o.repair {
+			return nil, FileSystemDiff{}, errors.Wrapf(ErrCorruptedCatalog, "Cannot use the catalog of the %v folder", name)
+		}
+		o.message("Catalog is marked as corrupted. Folder must be rescanned...")
+		repaired, err := repairCatalog(ctx, fs, c, o, errs)
+		return repaired, NewFileSystemDiff(), err
+	case catalog.Initializing:
+		o.message("The previous scan of the folder was interrupted, continuing")
+	}
+	o.message("Comparing folder contents with catalog")
+	diff, err := DiffFiltered(ctx, fs, c, o.filter, false, WithProgress(o.progress))
+	if err = errs.check(err); err != nil {
+		return nil, FileSystemDiff{}, err
+	}
+	if o.verifyMoves {
+		verifyMoves(fs, c, diff, o.hashCache)
+	}
 	return c, diff, nil
 }
 
+// repairCatalog rebuilds a catalog by rescanning the whole folder except the files excluded by the filter of the
+// options. The deleted checksums of the original catalog
+// are kept, unless the file is found in the folder again. The repaired catalog is saved.
+// The files that cannot be read are added to errs, they are missing from the repaired catalog.
+// If the context is cancelled, the original catalog is restored, so the deleted checksums are not lost.
+func repairCatalog(ctx context.Context, fs afero.Fs, c catalog.Catalog, o options, errs *fileErrors) (catalog.Catalog, error) {
+	repaired, err := ScanFolder(ctx, fs, ".", o.filter, o.forward(c.HashAlgorithm())...)
+	if err = errs.check(err); err != nil {
+		if err := c.Write(fs); err != nil {
+			o.message("Failed to restore catalog: %v", err)
+		}
+		return nil, err
+	}
+	for sum := range c.DeletedChecksums() {
+		if !repaired.IsKnownChecksum(sum) {
+			tombstone, _ := c.Tombstone(sum)
+			repaired.AddTombstone(sum, tombstone)
+		}
+	}
+	if err := repaired.Write(fs); err != nil {
+		o.message("Failed to update catalog: %v", err)
+	}
+	return repaired, nil
+}
+
 // SyncCatalogWithImportFolder makes sure that the catalog in the folder is in sync with the file system
 // The fs parameter is treated as the root of the import folder.
-func SyncCatalogWithImportFolder(fs afero.Fs) (catalog.Catalog, error) {
-	fmt.Println("***************** Processing import folder ***************")
-	c, diff, err := readAndDiffCatalog(fs, "import")
+// The files and folders matched by the .cobackignore files of the folder or excluded by the filter set with
+// WithFilter are left out of the catalog.
+// Returns the error of the context if it is cancelled before the catalog is up to date.
+// If some files cannot be read, the synced catalog is returned with an UnreadableFilesError listing them.
+// The import catalog is only useful if it can be compared to the collection, so if the existing catalog
+// uses a different hash algorithm than the one requested with WithHashAlgorithm, the folder is rescanned.
+// A corrupted import catalog is always rebuilt, it contains nothing that cannot be recreated from the folder.
+// Otherwise only the added and modified files are read, deleted files are simply removed from the catalog,
+// and files moved inside the folder are updated in the catalog without reading them again.
+// If the folder hasn't changed, the state of the catalog is kept, so the progress of the import is not lost.
+// The start and the end of the sync, its steps and the unreadable files are sent to the Reporter set with WithReporter.
+func SyncCatalogWithImportFolder(ctx context.Context, fs afero.Fs, opts ...Option) (catalog.Catalog, error) {
+	o := newOptions(opts)
+	o.startPhase("import", "***************** Processing import folder ***************")
+	c, err := syncImportFolder(ctx, fs, o)
+	o.finishPhase("import", c, err)
+	return c, err
+}
+
+func syncImportFolder(ctx context.Context, fs afero.Fs, o options) (catalog.Catalog, error) {
+	o.repair = true
+	o.filter = And(o.filter, IgnoreFilter(fs))
+	var errs fileErrors
+	c, diff, err := readAndDiffCatalog(ctx, fs, "import", o, &errs)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(diff.Delete) > 0 || len(diff.Update) > 0 {
-		c = Scan(fs)
-	} else if len(diff.Add) > 0 {
-		c = ScanAdd(fs, c, diff)
+	if c.HashAlgorithm() != o.hashAlgorithm {
+		o.message("Catalog uses %v instead of %v. Folder must be rescanned...", c.HashAlgorithm(), o.hashAlgorithm)
+		errs = fileErrors{}
+		c, err = ScanFolder(ctx, fs, ".", o.filter, o.forward(o.hashAlgorithm)...)
+	} else {
+		c, err = scanChanges(ctx, fs, c, diff, forgetChanges, o)
+	}
+	if err = errs.check(err); err != nil {
+		return nil, err
 	}
 
-	return c, nil
+	if state := c.State(); state == catalog.Initializing || state == catalog.Incomplete {
+		c.SetState(catalog.Initialized)
+	}
+	return c, errs.err("import")
+}
+
+// stagingChanges returns the changeHandler of the staging folder.
+// Files deleted from the staging folder are stored as deleted, unless the collection already has them
+// (the user moved them to the collection). New files are only accepted if they are not known by the collection
+// and were not deleted from the staging folder before.
+func stagingChanges(collection catalog.Catalog) changeHandler {
+	return changeHandler{
+		deleted: func(c catalog.Catalog, path string) error {
+			item, err := c.Item(path)
+			if err != nil {
+				return errors.Wrap(err, "Failed to remove deleted file")
+			}
+			if collection.IsKnownChecksum(item.Checksum) {
+				c.ForgetPath(item.Path)
+			}
+			c.DeletePath(path)
+			return nil
+		},
+		changed: func(c catalog.Catalog, item catalog.Item) error {
+			if collection.IsDeletedChecksum(item.Checksum) {
+				return fmt.Errorf("File is already deleted from the collection: %v", item.Path)
+			}
+			if collection.IsKnownChecksum(item.Checksum) {
+				return fmt.Errorf("File is already in the collection: %v", item.Path)
+			}
+			if c.IsDeletedChecksum(item.Checksum) {
+				return fmt.Errorf("File is already deleted from the staging folder: %v", item.Path)
+			}
+			return c.Add(item)
+		},
+	}
 }
 
 // SyncCatalogWithStagingFolder makes sure that the catalog in the folder is in sync with the file system
 // The fs parameter is treated as the root of the staging folder.
-func SyncCatalogWithStagingFolder(fs afero.Fs, collection catalog.Catalog) (catalog.Catalog, error) {
-	fmt.Println("***************** Processing staging folder ***************")
-	c, diff, err := readAndDiffCatalog(fs, "staging")
+// The files and folders matched by the .cobackignore files of the folder are left out of the catalog, and so are the
+// run reports and the audit log CoBack writes into the staging folder (see IsRunFile).
+// Returns the error of the context if it is cancelled before the catalog is up to date.
+// The staging catalog always uses the same hash algorithm as the collection, returns error if an existing catalog uses a different one.
+// Returns error if the catalog is marked as corrupted, unless WithRepair is used.
+// If a file already in the staging catalog is modified, or it's found in the collection or among its deleted files,
+// the catalog doesn't match the folder anymore: it is marked as corrupted and an error caused by ErrCorruptedCatalog
+// is returned. The conflicting file has to be removed from the staging folder before the catalog is repaired.
+// If some files cannot be read, the synced catalog is returned with an UnreadableFilesError listing them.
+// The start and the end of the sync, its steps and the unreadable files are sent to the Reporter set with WithReporter.
+func SyncCatalogWithStagingFolder(ctx context.Context, fs afero.Fs, collection catalog.Catalog, opts ...Option) (catalog.Catalog, error) {
+	o := newOptions(opts)
+	o.startPhase("staging", "***************** Processing staging folder ***************")
+	c, err := syncStagingFolder(ctx, fs, collection, o)
+	o.finishPhase("staging", c, err)
+	return c, err
+}
+
+func syncStagingFolder(ctx context.Context, fs afero.Fs, collection catalog.Catalog, o options) (catalog.Catalog, error) {
+	o.hashAlgorithm = collection.HashAlgorithm()
+	o.filter = And(IgnoreFilter(fs), runFileFilter{})
+	var errs fileErrors
+	c, diff, err := readAndDiffCatalog(ctx, fs, "staging", o, &errs)
 	if err != nil {
 		return nil, err
 	}
-
-	for deletedPath := range diff.Delete {
-		item, err := c.Item(deletedPath)
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed to remove deleted file")
-		}
-		if collection.IsKnownChecksum(item.Md5Sum) {
-			c.ForgetPath(item.Path)
-		}
-		c.DeletePath(deletedPath)
+	if c.HashAlgorithm() != collection.HashAlgorithm() {
+		return nil, fmt.Errorf("The staging catalog uses %v but the collection uses %v", c.HashAlgorithm(), collection.HashAlgorithm())
 	}
 
-	for addedPath := range diff.Add {
-		item, err := catalog.NewItem(fs, addedPath)
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed to check new file")
-		}
-		if collection.IsDeletedChecksum(item.Md5Sum) {
-			return nil, fmt.Errorf("File is already deleted from the collection: %v", item.Path)
-		}
-		if collection.IsKnownChecksum(item.Md5Sum) {
-			return nil, fmt.Errorf("File is already in the collection: %v", item.Path)
-		}
-		if c.IsDeletedChecksum(item.Md5Sum) {
-			return nil, fmt.Errorf("File is already deleted from the staging folder: %v", item.Path)
-		}
-		c.Add(*item)
+	for modifiedPath := range diff.Update { // in later versions all modified files will be returned in some form
+		return nil, o.markCorrupted(fs, c, errors.Wrapf(ErrCorruptedCatalog, "A file already in the staging folder has been modified: %v", modifiedPath))
 	}
 
-	for addedPath := range diff.Update { // in later versions all modified files will be returned in some form
-		return nil, fmt.Errorf("A file already in the staging folder has been modified: %v", addedPath)
+	c, err = scanChanges(ctx, fs, c, diff, stagingChanges(collection), o)
+	if err = errs.check(err); err != nil {
+		return nil, err
 	}
 
 	for item := range c.AllItems() {
-		if collection.IsDeletedChecksum(item.Md5Sum) {
-			fs.Remove(catalog.CatalogFileName)
-			return nil, fmt.Errorf("File is already deleted from the collection: %v", item.Path)
+		if collection.IsDeletedChecksum(item.Checksum) {
+			return nil, o.markCorrupted(fs, c, errors.Wrapf(ErrCorruptedCatalog, "File is already deleted from the collection: %v", item.Path))
 		}
-		if collection.IsKnownChecksum(item.Md5Sum) {
-			fs.Remove(catalog.CatalogFileName)
-			return nil, fmt.Errorf("File is already in the collection: %v", item.Path)
+		if collection.IsKnownChecksum(item.Checksum) {
+			return nil, o.markCorrupted(fs, c, errors.Wrapf(ErrCorruptedCatalog, "File is already in the collection: %v", item.Path))
 		}
 	}
 
-	return c, nil
+	c.SetState(catalog.Initialized)
+	return c, errs.err("staging")
+}
+
+// collectionChanges is the changeHandler of the collection folder. The deleted files are stored as deleted,
+// so they are not imported again.
+var collectionChanges = changeHandler{
+	deleted: func(c catalog.Catalog, path string) error {
+		c.DeletePath(path)
+		return nil
+	},
+	changed: func(c catalog.Catalog, item catalog.Item) error {
+		return c.Set(item)
+	},
 }
 
 // SyncCatalogWithCollectionFolder makes sure that the catalog in the folder is in sync with the file system
 // The fs parameter is treated as the root of the Collection folder.
-func SyncCatalogWithCollectionFolder(fs afero.Fs) (catalog.Catalog, error) {
-	fmt.Println("***************** Processing collection folder ***************")
-	c, diff, err := readAndDiffCatalog(fs, "collection")
+// The files and folders matched by the .cobackignore files of the folder are left out of the catalog.
+// Returns the error of the context if it is cancelled before the catalog is up to date.
+// The hash algorithm set with WithHashAlgorithm is only used if the catalog has to be created from scratch.
+// Returns error if the catalog is marked as corrupted, unless WithRepair is used.
+// If some files cannot be read, the synced catalog is returned with an UnreadableFilesError listing them.
+// The start and the end of the sync, its steps and the unreadable files are sent to the Reporter set with WithReporter.
+func SyncCatalogWithCollectionFolder(ctx context.Context, fs afero.Fs, opts ...Option) (catalog.Catalog, error) {
+	o := newOptions(opts)
+	o.startPhase("collection", "***************** Processing collection folder ***************")
+	c, err := syncCollectionFolder(ctx, fs, o)
+	o.finishPhase("collection", c, err)
+	return c, err
+}
+
+func syncCollectionFolder(ctx context.Context, fs afero.Fs, o options) (catalog.Catalog, error) {
+	o.filter = IgnoreFilter(fs)
+	var errs fileErrors
+	c, diff, err := readAndDiffCatalog(ctx, fs, "collection", o, &errs)
 	if err != nil {
 		return nil, err
 	}
 
-	for deletedPath := range diff.Delete {
-		c.DeletePath(deletedPath)
-	}
-
-	for addedPath := range diff.Add {
-		item, err := catalog.NewItem(fs, addedPath)
-		if err != nil {
-			return nil, err
-		}
-		err = c.Add(*item)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	for modifiedPath := range diff.Update {
-		item, err := catalog.NewItem(fs, modifiedPath)
-		if err != nil {
-			return nil, err
-		}
-		err = c.Set(*item)
-		if err != nil {
-			return nil, err
-		}
+	c, err = scanChanges(ctx, fs, c, diff, collectionChanges, o)
+	if err = errs.check(err); err != nil {
+		return nil, err
 	}
 
-	return c, nil
+	c.SetState(catalog.Initialized)
+	return c, errs.err("collection")
 }
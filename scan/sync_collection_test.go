@@ -1,13 +1,17 @@
 package scan
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
 	fsh "github.com/mitro42/coback/fshelper"
 	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
@@ -15,10 +19,10 @@ func TestSyncCollectionCreate(t *testing.T) {
 	memFs := afero.NewMemMapFs()
 	collectionFs, err := InitializeFolder(memFs, "photos")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithCollectionFolder(collectionFs)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
-	th.Equals(t, catalog.NewCatalog(), c)
+	th.Equals(t, newInitializedCatalog(), c)
 }
 
 func TestSyncCollectionStart(t *testing.T) {
@@ -26,7 +30,7 @@ func TestSyncCollectionStart(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithCollectionFolder(collectionFs)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 4, c.Count())
@@ -42,11 +46,11 @@ func TestSyncCollectionWhenCatalogIsUpToDate(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cSynced, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cSynced, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	cRead, err := catalog.Read(collectionFs, catalog.CatalogFileName)
 	th.Ok(t, err)
-	cSynced2, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cSynced2, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, cSynced, cRead)
@@ -58,7 +62,7 @@ func TestSyncCollectionWhenNonDuplicateFileRemovedFromDisk(t *testing.T) {
 
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithCollectionFolder(collectionFs)
+	_, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	err = collectionFs.Remove("test1.txt")
@@ -66,7 +70,7 @@ func TestSyncCollectionWhenNonDuplicateFileRemovedFromDisk(t *testing.T) {
 	err = collectionFs.Remove("subfolder/file2.bin")
 	th.Ok(t, err)
 
-	cAfterDelete, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cAfterDelete, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	th.Equals(t, 2, cAfterDelete.Count())
 	th.Equals(t, 2, cAfterDelete.DeletedCount())
@@ -87,7 +91,7 @@ func TestSyncCollectionWhenDuplicateFileRemovedFromDisk(t *testing.T) {
 	th.Ok(t, err)
 	fsh.CopyFile(collectionFs, item.Path, item.ModificationTime, afero.NewBasePathFs(collectionFs, "subfolder"))
 
-	cOrig, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cOrig, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 5, cOrig.Count())
@@ -100,7 +104,7 @@ func TestSyncCollectionWhenDuplicateFileRemovedFromDisk(t *testing.T) {
 
 	err = collectionFs.Remove("test1.txt")
 	th.Ok(t, err)
-	cAfterDelete, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cAfterDelete, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	th.Equals(t, 4, cAfterDelete.Count())
 	th.Equals(t, 0, cAfterDelete.DeletedCount())
@@ -111,7 +115,7 @@ func TestSyncCollectionWhenDuplicateFileRemovedFromDisk(t *testing.T) {
 
 	err = collectionFs.Remove("subfolder/test1.txt")
 	th.Ok(t, err)
-	cAfterDelete2, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cAfterDelete2, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	th.Equals(t, 3, cAfterDelete2.Count())
 	th.Equals(t, 1, cAfterDelete2.DeletedCount())
@@ -126,13 +130,13 @@ func TestSyncCollectionWhenFileAddedToDisk(t *testing.T) {
 
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithCollectionFolder(collectionFs)
+	_, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	dummy0 := dummies[0]
 	createDummyFile(collectionFs, dummy0)
 
-	cAfterAdd, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cAfterAdd, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 5, cAfterAdd.Count())
@@ -141,7 +145,7 @@ func TestSyncCollectionWhenFileAddedToDisk(t *testing.T) {
 	checkFilesInCatalog(t, cAfterAdd, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
 	checkFilesInCatalog(t, cAfterAdd, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
 	checkFilesInCatalog(t, cAfterAdd, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
-	checkFilesInCatalog(t, cAfterAdd, dummy0.Path, dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cAfterAdd, dummy0.Path, dummy0.Size, dummy0.Checksum)
 }
 
 func TestSyncCollectionWhenFileWithDeletedChecksumAddedToDisk(t *testing.T) {
@@ -149,21 +153,21 @@ func TestSyncCollectionWhenFileWithDeletedChecksumAddedToDisk(t *testing.T) {
 
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cOrig, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	dummy0 := dummies[0]
-	cOrig.DeleteChecksum(dummy0.Md5Sum)
-	th.Equals(t, true, cOrig.IsDeletedChecksum(dummy0.Md5Sum))
+	cOrig.DeleteChecksum(dummy0.Checksum)
+	th.Equals(t, true, cOrig.IsDeletedChecksum(dummy0.Checksum))
 	cOrig.Write(collectionFs)
 
-	cRead, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cRead, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	th.Equals(t, cOrig, cRead)
 
 	err = createDummyFile(collectionFs, dummy0)
 	th.Ok(t, err)
-	cModified, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cModified, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 5, cModified.Count())
@@ -171,8 +175,8 @@ func TestSyncCollectionWhenFileWithDeletedChecksumAddedToDisk(t *testing.T) {
 	checkFilesInCatalog(t, cModified, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
 	checkFilesInCatalog(t, cModified, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
 	checkFilesInCatalog(t, cModified, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
-	checkFilesInCatalog(t, cModified, dummy0.Path, dummy0.Size, dummy0.Md5Sum)
-	th.Equals(t, false, cModified.IsDeletedChecksum(dummy0.Md5Sum))
+	checkFilesInCatalog(t, cModified, dummy0.Path, dummy0.Size, dummy0.Checksum)
+	th.Equals(t, false, cModified.IsDeletedChecksum(dummy0.Checksum))
 }
 
 func TestSyncCollectionWhenFileModifiedOnDisk(t *testing.T) {
@@ -180,7 +184,7 @@ func TestSyncCollectionWhenFileModifiedOnDisk(t *testing.T) {
 
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithCollectionFolder(collectionFs)
+	_, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	// overwrite test1.txt with the dummy0
@@ -191,14 +195,14 @@ func TestSyncCollectionWhenFileModifiedOnDisk(t *testing.T) {
 	err = createDummyFile(collectionFs, dummy0)
 	th.Ok(t, err)
 
-	cModified, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cModified, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 4, cModified.Count())
 	th.Equals(t, 0, cModified.DeletedCount())
 	checkFilesInCatalog(t, cModified, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
 	checkFilesInCatalog(t, cModified, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
-	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Checksum)
 	checkFilesInCatalog(t, cModified, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
 }
 
@@ -207,15 +211,15 @@ func TestSyncCollectionWhenFileModifiedOnDiskToHaveADeletedCheckSum(t *testing.T
 
 	collectionFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cOrig, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	// delete the checksum of dummy0 and save new catalog
 	dummy0 := dummies[0]
-	cOrig.DeleteChecksum(dummy0.Md5Sum)
-	th.Equals(t, true, cOrig.IsDeletedChecksum(dummy0.Md5Sum))
+	cOrig.DeleteChecksum(dummy0.Checksum)
+	th.Equals(t, true, cOrig.IsDeletedChecksum(dummy0.Checksum))
 	cOrig.Write(collectionFs)
-	cRead, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cRead, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 	th.Equals(t, cOrig, cRead)
 
@@ -226,13 +230,176 @@ func TestSyncCollectionWhenFileModifiedOnDiskToHaveADeletedCheckSum(t *testing.T
 	err = createDummyFile(collectionFs, dummy0)
 	th.Ok(t, err)
 
-	cModified, err := SyncCatalogWithCollectionFolder(collectionFs)
+	cModified, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 4, cModified.Count())
 	th.Equals(t, 0, cModified.DeletedCount())
 	checkFilesInCatalog(t, cModified, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
 	checkFilesInCatalog(t, cModified, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
-	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Checksum)
 	checkFilesInCatalog(t, cModified, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
 }
+
+func TestSyncCollectionCorrupted(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	c.DeleteChecksum("1234")
+	c.SetState(catalog.Corrupted)
+	th.Ok(t, c.Write(collectionFs))
+
+	_, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.NokPrefix(t, err, "Cannot use the catalog of the collection folder")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
+
+	repaired, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithRepair())
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, repaired.State())
+	th.Equals(t, 4, repaired.Count())
+	th.Equals(t, true, repaired.IsDeletedChecksum("1234"))
+	cRead, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cRead.State())
+}
+
+func TestSyncCollectionContinuesInterruptedScan(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Scan(ctx, collectionFs)
+	th.Equals(t, context.Canceled, err)
+	cRead, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initializing, cRead.State())
+
+	var r eventRecorder
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithReporter(&r))
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, c.State())
+	th.Equals(t, 4, c.Count())
+	continued := false
+	for _, e := range r.ofType(MessageEvent) {
+		continued = continued || e.Message == "The previous scan of the folder was interrupted, continuing"
+	}
+	th.Assert(t, continued, "the interrupted scan is not continued")
+}
+
+func TestSyncCollectionDamagedCatalog(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	c.DeleteChecksum("1234")
+	th.Ok(t, c.Write(collectionFs))
+	th.Ok(t, c.Write(collectionFs))
+	th.Ok(t, afero.WriteFile(collectionFs, catalog.CatalogFileName, []byte(`{"version":3,"content":{`), 0644))
+
+	// the backup is used with a warning, the deleted file is not forgotten
+	var r eventRecorder
+	c, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithReporter(&r))
+	th.Ok(t, err)
+	th.Equals(t, 4, c.Count())
+	th.Equals(t, true, c.IsDeletedChecksum("1234"))
+	warned := false
+	for _, e := range r.ofType(MessageEvent) {
+		warned = warned || strings.HasPrefix(e.Message, "Warning: The catalog is damaged") &&
+			strings.Contains(e.Message, "its backup 'coback.catalog.1' is used instead")
+	}
+	th.Assert(t, warned, "the use of the backup is not reported")
+}
+
+func TestSyncCollectionWhenFileMoved(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	cOrig, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	// a fake checksum in the catalog shows that the content of the moved file is not read again
+	item, err := cOrig.Item("test1.txt")
+	th.Ok(t, err)
+	item.Checksum = "0123456789abcdef0123456789abcdef"
+	th.Ok(t, cOrig.Set(item))
+	th.Ok(t, cOrig.Write(collectionFs))
+
+	th.Ok(t, collectionFs.MkdirAll("archive", 0755))
+	th.Ok(t, collectionFs.Rename("test1.txt", "archive/test1.txt"))
+	cMoved, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	th.Equals(t, 4, cMoved.Count())
+	th.Equals(t, 0, cMoved.DeletedCount())
+	checkFilesInCatalog(t, cMoved, "archive/test1.txt", 1160, "0123456789abcdef0123456789abcdef")
+	_, err = cMoved.Item("test1.txt")
+	th.NokPrefix(t, err, "No such file")
+
+	// with verification the moved file is read again
+	th.Ok(t, cOrig.Write(collectionFs))
+	cVerified, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithVerifiedMoves())
+	th.Ok(t, err)
+	th.Equals(t, 4, cVerified.Count())
+	checkFilesInCatalog(t, cVerified, "archive/test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
+	th.Equals(t, true, cVerified.IsDeletedChecksum("0123456789abcdef0123456789abcdef"))
+}
+
+func TestSyncCollectionCancelled(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = SyncCatalogWithCollectionFolder(ctx, collectionFs)
+	th.Equals(t, context.Canceled, errors.Cause(err))
+
+	// the next sync continues the interrupted scan
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+	th.Equals(t, 4, c.Count())
+	th.Equals(t, catalog.Initialized, c.State())
+}
+
+func TestSyncCollectionUnreadableFolder(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	_, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
+	th.Ok(t, err)
+
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), cth.NewUnreadableFs(collectionFs, "subfolder"))
+	unreadable, ok := err.(*UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, []FileError{{Folder: "collection", Path: "subfolder", Err: unreadable.Files[0].Err}}, unreadable.Files)
+	th.Equals(t, 4, c.Count())
+	th.Equals(t, 0, c.DeletedCount())
+}
+
+func TestSyncCollectionIgnoresWithFilter(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithFilter(MinSizeFilter(1200)))
+	th.Ok(t, err)
+	th.Equals(t, 4, c.Count())
+}
+
+func TestSyncCollectionWithHashCache(t *testing.T) {
+	fs := createMemFsTestData()
+	collectionFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	cache := newPathHashCache()
+	c, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithHashCache(cache))
+	th.Ok(t, err)
+	th.Ok(t, c.Write(collectionFs))
+	th.Equals(t, 4, len(cache.sums))
+
+	th.Ok(t, createDummyFile(collectionFs, dummies[1]))
+	c, err = SyncCatalogWithCollectionFolder(context.Background(), collectionFs, WithHashCache(cache))
+	th.Ok(t, err)
+	th.Equals(t, 5, len(cache.sums))
+	th.Equals(t, dummies[1].Checksum, cache.sums[dummies[1].Path])
+}
@@ -1,12 +1,14 @@
 package scan
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/mitro42/coback/catalog"
+	cth "github.com/mitro42/coback/catalogtesthelper"
 	fsh "github.com/mitro42/coback/fshelper"
 	th "github.com/mitro42/testhelper"
 	"github.com/spf13/afero"
@@ -16,10 +18,10 @@ func TestSyncImportCreate(t *testing.T) {
 	memFs := afero.NewMemMapFs()
 	importFs, err := InitializeFolder(memFs, "holiday_pictures")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithImportFolder(importFs)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
-	th.Equals(t, catalog.NewCatalog(), c)
+	th.Equals(t, newInitializedCatalog(), c)
 }
 
 func TestSyncImportStart(t *testing.T) {
@@ -27,7 +29,7 @@ func TestSyncImportStart(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithImportFolder(importFs)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 4, c.Count())
@@ -43,11 +45,11 @@ func TestSyncImportWhenCatalogIsUpToDate(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cSynced, err := SyncCatalogWithImportFolder(importFs)
+	cSynced, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 	cRead, err := catalog.Read(importFs, catalog.CatalogFileName)
 	th.Ok(t, err)
-	cSynced2, err := SyncCatalogWithImportFolder(importFs)
+	cSynced2, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	th.Equals(t, cSynced, cRead)
@@ -59,7 +61,7 @@ func TestSyncImportWhenFileRemovedFromDisk(t *testing.T) {
 
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithImportFolder(importFs)
+	_, err = SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	err = importFs.Remove("test1.txt")
@@ -67,7 +69,7 @@ func TestSyncImportWhenFileRemovedFromDisk(t *testing.T) {
 	err = importFs.Remove("subfolder/file2.bin")
 	th.Ok(t, err)
 
-	cAfterDelete, err := SyncCatalogWithImportFolder(importFs)
+	cAfterDelete, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 	th.Equals(t, 2, cAfterDelete.Count())
 	th.Equals(t, 0, cAfterDelete.DeletedCount())
@@ -80,13 +82,13 @@ func TestSyncImportWhenFileAddedToDisk(t *testing.T) {
 
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithImportFolder(importFs)
+	_, err = SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	dummy0 := dummies[0]
 	createDummyFile(importFs, dummy0)
 
-	cAfterAdd, err := SyncCatalogWithImportFolder(importFs)
+	cAfterAdd, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	th.Equals(t, 5, cAfterAdd.Count())
@@ -95,7 +97,7 @@ func TestSyncImportWhenFileAddedToDisk(t *testing.T) {
 	checkFilesInCatalog(t, cAfterAdd, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
 	checkFilesInCatalog(t, cAfterAdd, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
 	checkFilesInCatalog(t, cAfterAdd, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
-	checkFilesInCatalog(t, cAfterAdd, dummy0.Path, dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cAfterAdd, dummy0.Path, dummy0.Size, dummy0.Checksum)
 }
 
 func TestSyncImportWhenFileAddedAndDeleted(t *testing.T) {
@@ -103,7 +105,7 @@ func TestSyncImportWhenFileAddedAndDeleted(t *testing.T) {
 
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig, err := SyncCatalogWithImportFolder(importFs)
+	cOrig, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	dummy0 := dummies[0]
@@ -113,7 +115,7 @@ func TestSyncImportWhenFileAddedAndDeleted(t *testing.T) {
 	err = importFs.Remove("subfolder/file2.bin")
 	th.Ok(t, err)
 
-	cModified, err := SyncCatalogWithImportFolder(importFs)
+	cModified, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	th.Assert(t, !reflect.DeepEqual(cOrig, cModified), "The catalogs must be different")
@@ -122,7 +124,7 @@ func TestSyncImportWhenFileAddedAndDeleted(t *testing.T) {
 	checkFilesInCatalog(t, cModified, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
 	checkFilesInCatalog(t, cModified, "test1.txt", 1160, "b3cd1cf6179bca32fd5d76473b129117")
 	checkFilesInCatalog(t, cModified, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
-	checkFilesInCatalog(t, cModified, dummy0.Path, dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cModified, dummy0.Path, dummy0.Size, dummy0.Checksum)
 }
 
 func TestSyncImportWhenFileHashModified(t *testing.T) {
@@ -130,7 +132,7 @@ func TestSyncImportWhenFileHashModified(t *testing.T) {
 
 	importFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig, err := SyncCatalogWithImportFolder(importFs)
+	cOrig, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	dummy0 := dummies[0]
@@ -141,13 +143,160 @@ func TestSyncImportWhenFileHashModified(t *testing.T) {
 	err = createDummyFile(importFs, dummy0)
 	th.Ok(t, err)
 
-	cModified, err := SyncCatalogWithImportFolder(importFs)
+	cModified, err := SyncCatalogWithImportFolder(context.Background(), importFs)
 	th.Ok(t, err)
 
 	th.Assert(t, !reflect.DeepEqual(cOrig, cModified), "The catalogs must be different")
 	th.Equals(t, 4, cModified.Count())
 	th.Equals(t, 0, cModified.DeletedCount())
 	checkFilesInCatalog(t, cModified, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
-	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Md5Sum)
+	checkFilesInCatalog(t, cModified, "test1.txt", dummy0.Size, dummy0.Checksum)
 	checkFilesInCatalog(t, cModified, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
 }
+
+func TestSyncImportRescanWithDifferentAlgorithm(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	cMd5, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.MD5, cMd5.HashAlgorithm())
+
+	cSha, err := SyncCatalogWithImportFolder(context.Background(), importFs, WithHashAlgorithm(catalog.SHA256))
+	th.Ok(t, err)
+	th.Equals(t, catalog.SHA256, cSha.HashAlgorithm())
+	th.Equals(t, 4, cSha.Count())
+	checkFilesInCatalog(t, cSha, "test1.txt", 1160, "sha256:0f3a7d03932add5452fe02a8ff295d0dee8c87cc921d1160d2a59d31ef8c92fd")
+	checkFilesInCatalog(t, cSha, "subfolder/file1.bin", 1024, "sha256:ec9e99eea530cf1ffb3750d70218346d04b095dd47c18766dc32ead0a62c7af4")
+}
+
+func TestSyncImportCorruptedIsRescanned(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	c.ForgetPath("test1.txt")
+	c.SetState(catalog.Corrupted)
+	th.Ok(t, c.Write(importFs))
+
+	cSynced, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cSynced.State())
+	th.Equals(t, 4, cSynced.Count())
+}
+
+func TestSyncImportKeepsStateIfUnchanged(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, c.State())
+	c.SetState(catalog.Copied)
+	th.Ok(t, c.Write(importFs))
+
+	cSynced, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Copied, cSynced.State())
+
+	err = createDummyFile(importFs, dummies[1])
+	th.Ok(t, err)
+	cSynced, err = SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cSynced.State())
+}
+
+func TestSyncImportWhenFileMoved(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	c.SetState(catalog.Copied)
+	th.Ok(t, c.Write(importFs))
+
+	th.Ok(t, importFs.Mkdir("renamed", 0755))
+	th.Ok(t, importFs.Rename("subfolder/file1.bin", "renamed/file1.bin"))
+	th.Ok(t, importFs.Rename("subfolder/file2.bin", "renamed/file2.bin"))
+	cSynced, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Copied, cSynced.State())
+	th.Equals(t, 4, cSynced.Count())
+	checkFilesInCatalog(t, cSynced, "renamed/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
+	checkFilesInCatalog(t, cSynced, "renamed/file2.bin", 1500, "f350c40373648527aa95b15786473501")
+}
+
+func TestSyncImportOnlyReadsChangedFiles(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	// a fake checksum in the catalog shows that the content of the unchanged file is not read again
+	item, err := c.Item("test2.txt")
+	th.Ok(t, err)
+	item.Checksum = "0123456789abcdef0123456789abcdef"
+	th.Ok(t, c.Set(item))
+	th.Ok(t, c.Write(importFs))
+
+	th.Ok(t, changeFileContent(importFs, "test1.txt"))
+	th.Ok(t, importFs.Remove("subfolder/file2.bin"))
+	th.Ok(t, createDummyFile(importFs, dummies[1]))
+	cSynced, err := SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cSynced.State())
+	th.Equals(t, 4, cSynced.Count())
+	th.Equals(t, 0, cSynced.DeletedCount())
+	checkFilesInCatalog(t, cSynced, "test2.txt", 1304, "0123456789abcdef0123456789abcdef")
+	checkFilesInCatalog(t, cSynced, "subfolder/file1.bin", 1024, "1cb0bad847fb90f95a767854932ec7c4")
+	checkFilesInCatalog(t, cSynced, dummies[1].Path, dummies[1].Size, dummies[1].Checksum)
+	changed, err := catalog.NewItem(importFs, "test1.txt")
+	th.Ok(t, err)
+	checkFilesInCatalog(t, cSynced, "test1.txt", changed.Size, changed.Checksum)
+
+	cRead, err := catalog.Read(importFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, cSynced, cRead)
+}
+
+func TestSyncImportUnreadableFile(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+
+	c, err := SyncCatalogWithImportFolder(context.Background(), cth.NewUnreadableFs(importFs, "subfolder/file2.bin"))
+	unreadable, ok := err.(*UnreadableFilesError)
+	th.Assert(t, ok, "unexpected error: %v", err)
+	th.Equals(t, 1, len(unreadable.Files))
+	th.Equals(t, "import", unreadable.Files[0].Folder)
+	th.Equals(t, "subfolder/file2.bin", unreadable.Files[0].Path)
+	th.Equals(t, 3, c.Count())
+	th.Ok(t, c.Write(importFs))
+
+	// the file is read by the next sync
+	c, err = SyncCatalogWithImportFolder(context.Background(), importFs)
+	th.Ok(t, err)
+	th.Equals(t, 4, c.Count())
+	checkFilesInCatalog(t, c, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
+}
+
+func TestSyncImportWithFilter(t *testing.T) {
+	fs := createMemFsTestData()
+	importFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithImportFolder(context.Background(), importFs, WithFilter(MinSizeFilter(1200)))
+	th.Ok(t, err)
+	th.Equals(t, 2, c.Count())
+	checkFilesInCatalog(t, c, "subfolder/file2.bin", 1500, "f350c40373648527aa95b15786473501")
+	checkFilesInCatalog(t, c, "test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
+	th.Ok(t, c.Write(importFs))
+
+	// files excluded by a new filter are forgotten
+	c, err = SyncCatalogWithImportFolder(context.Background(), importFs, WithFilter(MaxSizeFilter(1400)))
+	th.Ok(t, err)
+	th.Equals(t, 3, c.Count())
+	th.Equals(t, 0, c.DeletedCount())
+	_, err = c.Item("subfolder/file2.bin")
+	th.NokPrefix(t, err, "No such file")
+}
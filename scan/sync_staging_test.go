@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -9,6 +10,7 @@ import (
 	"github.com/mitro42/coback/catalog"
 	fsh "github.com/mitro42/coback/fshelper"
 	th "github.com/mitro42/testhelper"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
@@ -17,10 +19,10 @@ func TestSyncStagingCreate(t *testing.T) {
 	memFs := afero.NewMemMapFs()
 	stagingFs, err := InitializeFolder(memFs, "temp_photos")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
-	th.Equals(t, catalog.NewCatalog(), c)
+	th.Equals(t, newInitializedCatalog(), c)
 }
 
 func TestSyncStagingStartOnlyNewFiles(t *testing.T) {
@@ -29,7 +31,7 @@ func TestSyncStagingStartOnlyNewFiles(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
@@ -54,13 +56,16 @@ func TestSyncStagingStartFileIsAlreadyInCollection(t *testing.T) {
 	th.Ok(t, err)
 	collectionCatalog.Add(*item)
 
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "File is already in the collection")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
 	th.Equals(t, nil, c)
 
+	// the catalog is kept, but it cannot be used until it's repaired
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
-	th.NokPrefix(t, err, "Cannot read catalog")
-	th.Equals(t, nil, cRead)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Corrupted, cRead.State())
+	th.Equals(t, 4, cRead.Count())
 }
 
 func TestSyncStagingStartFileIsAlreadyDeletedInCollection(t *testing.T) {
@@ -71,13 +76,16 @@ func TestSyncStagingStartFileIsAlreadyDeletedInCollection(t *testing.T) {
 	th.Ok(t, err)
 	collectionCatalog.DeleteChecksum("f350c40373648527aa95b15786473501") // subfolder/file2.bin
 
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "File is already deleted from the collection")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
 	th.Equals(t, nil, c)
 
+	// the catalog is kept, but it cannot be used until it's repaired
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
-	th.NokPrefix(t, err, "Cannot read catalog")
-	th.Equals(t, nil, cRead)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Corrupted, cRead.State())
+	th.Equals(t, 4, cRead.Count())
 }
 
 func TestSyncStagingWhenCatalogIsUpToDate(t *testing.T) {
@@ -86,14 +94,14 @@ func TestSyncStagingWhenCatalogIsUpToDate(t *testing.T) {
 	fs := fsh.CreateSafeFs(filepath.Dir(basePath))
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	cSynced.DeleteChecksum("a")
 	cSynced.DeleteChecksum("42")
 	cSynced.Write(stagingFs)
 	th.Ok(t, err)
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
 	th.Ok(t, err)
-	cSynced2, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced2, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
 	th.Equals(t, cSynced, cRead)
@@ -110,7 +118,7 @@ func TestSyncStagingWhenFileIsMovedToCollection(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	Scan(stagingFs)
+	Scan(context.Background(), stagingFs)
 	th.Ok(t, err)
 	movedItem, err := catalog.NewItem(stagingFs, "subfolder/file1.bin")
 	th.Ok(t, err)
@@ -118,15 +126,15 @@ func TestSyncStagingWhenFileIsMovedToCollection(t *testing.T) {
 	th.Ok(t, err)
 	err = stagingFs.Remove(movedItem.Path)
 	th.Ok(t, err)
-	collectionCatalog, err := SyncCatalogWithCollectionFolder(collectionFs)
+	collectionCatalog, err := SyncCatalogWithCollectionFolder(context.Background(), collectionFs)
 	collectionClone := collectionCatalog.Clone()
 	th.Ok(t, err)
 
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
-	th.Equals(t, false, cSynced.IsDeletedChecksum(movedItem.Md5Sum))
-	th.Equals(t, false, cSynced.IsKnownChecksum(movedItem.Md5Sum))
+	th.Equals(t, false, cSynced.IsDeletedChecksum(movedItem.Checksum))
+	th.Equals(t, false, cSynced.IsKnownChecksum(movedItem.Checksum))
 
 	storedItem, err := cSynced.Item(movedItem.Path)
 	th.NokPrefix(t, err, "No such file")
@@ -142,18 +150,18 @@ func TestSyncStagingWhenFileNotInCollectionIsDeletedFromStaging(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	_, err = SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	_, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
 	deletedItem, err := catalog.NewItem(stagingFs, "subfolder/file1.bin")
 	th.Ok(t, err)
 	stagingFs.Remove(deletedItem.Path)
 
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 
-	th.Equals(t, true, cSynced.IsDeletedChecksum(deletedItem.Md5Sum))
-	th.Equals(t, true, cSynced.IsKnownChecksum(deletedItem.Md5Sum))
+	th.Equals(t, true, cSynced.IsDeletedChecksum(deletedItem.Checksum))
+	th.Equals(t, true, cSynced.IsKnownChecksum(deletedItem.Checksum))
 
 	storedItem, err := cSynced.Item(deletedItem.Path)
 	th.NokPrefix(t, err, "No such file")
@@ -166,7 +174,8 @@ func TestSyncStagingNewFileThatIsAlreadyInCollection(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 	dummy0 := dummies[0]
 	createDummyFile(stagingFs, dummy0)
 
@@ -174,7 +183,7 @@ func TestSyncStagingNewFileThatIsAlreadyInCollection(t *testing.T) {
 	th.Ok(t, err)
 	collectionCatalog.Add(*item)
 
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "File is already in the collection")
 	th.Equals(t, nil, c)
 
@@ -188,13 +197,14 @@ func TestSyncStagingNewFileThatIsAlreadyDeletedInCollection(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 	dummy0 := dummies[0]
 	createDummyFile(stagingFs, dummy0)
 
-	collectionCatalog.DeleteChecksum(dummy0.Md5Sum)
+	collectionCatalog.DeleteChecksum(dummy0.Checksum)
 
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "File is already deleted from the collection")
 	th.Equals(t, nil, c)
 
@@ -208,13 +218,14 @@ func TestSyncStagingNewFileThatIsAlreadyInStaging(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 
 	item, err := catalog.NewItem(stagingFs, "test1.txt")
 	th.Ok(t, err)
 	fsh.CopyFile(stagingFs, item.Path, item.ModificationTime, afero.NewBasePathFs(stagingFs, "subfolder"))
 
-	c, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 	item2, err := catalog.NewItem(stagingFs, "subfolder/test1.txt")
 	th.Ok(t, err)
@@ -228,14 +239,15 @@ func TestSyncStagingNewFileThatIsAlreadyDeletedInStaging(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 	dummy0 := dummies[0]
 	createDummyFile(stagingFs, dummy0)
 
-	cOrig.DeleteChecksum(dummy0.Md5Sum)
+	cOrig.DeleteChecksum(dummy0.Checksum)
 	cOrig.Write(stagingFs)
 
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "File is already deleted from the staging folder")
 	th.Equals(t, nil, cSynced)
 
@@ -249,11 +261,12 @@ func TestSyncStagingNewFileThatIsNotKnownInCollectionOrStaging(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 	dummy0 := dummies[0]
 	createDummyFile(stagingFs, dummy0)
 
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.Ok(t, err)
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
 	th.Ok(t, err)
@@ -269,19 +282,100 @@ func TestSyncStagingFileChanged(t *testing.T) {
 	fs := createMemFsTestData()
 	stagingFs, err := InitializeFolder(fs, "test_data")
 	th.Ok(t, err)
-	cOrig := Scan(stagingFs)
+	cOrig, err := Scan(context.Background(), stagingFs)
+	th.Ok(t, err)
 
 	item, err := catalog.NewItem(stagingFs, "test1.txt")
 	th.Ok(t, err)
-	item.Md5Sum = "42"
+	item.Checksum = "42"
 	item.ModificationTime = time.Now().Format(time.RFC3339Nano)
 	cOrig.Set(*item)
 	cOrig.Write(stagingFs)
 
-	cSynced, err := SyncCatalogWithStagingFolder(stagingFs, collectionCatalog)
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
 	th.NokPrefix(t, err, "A file already in the staging folder has been modified")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
 	th.Equals(t, nil, cSynced)
 	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
 	th.Ok(t, err)
+	cOrig.SetState(catalog.Corrupted)
 	th.Equals(t, cOrig, cRead)
+
+	// the rescan of the repair accepts the modified file
+	cSynced, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog, WithRepair())
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cSynced.State())
+	repaired, err := cSynced.Item("test1.txt")
+	th.Ok(t, err)
+	th.Assert(t, repaired.Checksum != "42", "the modified file is not read again")
+}
+
+func TestSyncStagingConflictNeedsRepair(t *testing.T) {
+	fs := createMemFsTestData()
+	stagingFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	collectionCatalog := catalog.NewCatalog()
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.Ok(t, err)
+	c.DeleteChecksum("1234")
+	th.Ok(t, c.Write(stagingFs))
+
+	// the user copied a staged file to the collection instead of moving it
+	item, err := c.Item("test2.txt")
+	th.Ok(t, err)
+	th.Ok(t, collectionCatalog.Add(item))
+	_, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.NokPrefix(t, err, "File is already in the collection: test2.txt")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
+
+	// the corrupted catalog is refused until it's repaired
+	_, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.NokPrefix(t, err, "Cannot use the catalog of the staging folder")
+	th.Equals(t, ErrCorruptedCatalog, errors.Cause(err))
+
+	// the repair cannot resolve the conflict while the file is in the staging folder
+	_, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog, WithRepair())
+	th.NokPrefix(t, err, "File is already in the collection: test2.txt")
+
+	th.Ok(t, stagingFs.Remove("test2.txt"))
+	repaired, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog, WithRepair())
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, repaired.State())
+	th.Equals(t, 3, repaired.Count())
+	th.Equals(t, true, repaired.IsDeletedChecksum("1234"))
+	cRead, err := catalog.Read(stagingFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Initialized, cRead.State())
+	th.Equals(t, true, cRead.IsDeletedChecksum("1234"))
+}
+
+func TestSyncStagingUsesCollectionAlgorithm(t *testing.T) {
+	collectionCatalog := catalog.NewCatalogWithAlgorithm(catalog.BLAKE3)
+	fs := createMemFsTestData()
+	stagingFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	c, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.Ok(t, err)
+	th.Equals(t, catalog.BLAKE3, c.HashAlgorithm())
+	th.Equals(t, 4, c.Count())
+
+	_, err = SyncCatalogWithStagingFolder(context.Background(), stagingFs, catalog.NewCatalog())
+	th.Nok(t, err, "The staging catalog uses blake3 but the collection uses md5")
+}
+
+func TestSyncStagingWhenFileMovedInStaging(t *testing.T) {
+	collectionCatalog := catalog.NewCatalog()
+	fs := createMemFsTestData()
+	stagingFs, err := InitializeFolder(fs, "test_data")
+	th.Ok(t, err)
+	cOrig, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.Ok(t, err)
+	th.Ok(t, cOrig.Write(stagingFs))
+
+	th.Ok(t, stagingFs.Rename("test2.txt", "subfolder/test2.txt"))
+	cSynced, err := SyncCatalogWithStagingFolder(context.Background(), stagingFs, collectionCatalog)
+	th.Ok(t, err)
+	th.Equals(t, 4, cSynced.Count())
+	th.Equals(t, 0, cSynced.DeletedCount())
+	checkFilesInCatalog(t, cSynced, "subfolder/test2.txt", 1304, "89b2b34c7b8d232041f0fcc1d213d7bc")
 }
@@ -18,6 +18,7 @@ type mockDoubleProgressBar struct {
 	sizeTotal     int64
 	incrByCount   int
 	setTotalCount int
+	addTotalCount int
 	mux           sync.Mutex
 }
 
@@ -33,6 +34,14 @@ func (m *mockDoubleProgressBar) SetTotal(count int64, size int64) {
 	m.setTotalCount++
 }
 
+func (m *mockDoubleProgressBar) AddTotal(count int64, size int64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.countTotal += count
+	m.sizeTotal += size
+	m.addTotalCount++
+}
+
 func (m *mockDoubleProgressBar) IncrBy(n int) {
 	m.mux.Lock()
 	defer m.mux.Unlock()
@@ -55,6 +64,13 @@ func (m *mockDoubleProgressBar) CurrentCount() int64 {
 func (m *mockDoubleProgressBar) Wait() {
 }
 
+// newInitializedCatalog returns an empty catalog in the state a catalog has after a completed scan or sync
+func newInitializedCatalog() catalog.Catalog {
+	c := catalog.NewCatalog()
+	c.SetState(catalog.Initialized)
+	return c
+}
+
 func changeFileContent(fs afero.Fs, path string) error {
 	f, err := fs.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -67,10 +83,10 @@ func changeFileContent(fs afero.Fs, path string) error {
 }
 
 type dummyFileDescription struct {
-	Path    string
-	Size    int64
-	Md5Sum  catalog.Checksum
-	Content string
+	Path     string
+	Size     int64
+	Checksum catalog.Checksum
+	Content  string
 }
 
 var dummies = []dummyFileDescription{
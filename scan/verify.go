@@ -0,0 +1,205 @@
+package scan
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// VerifyResult lists the files checked by VerifyFolder, grouped by the outcome of the check.
+// All lists are in alphabetical order.
+type VerifyResult struct {
+	// Ok lists the files whose content matches the catalog
+	Ok []string
+	// Corrupted lists the files whose content doesn't match the catalog, even though their size and modification
+	// time are unchanged. Editing a file changes its modification time, so this is most likely bit rot.
+	Corrupted []string
+	// Modified lists the files whose size or modification time changed since the catalog was updated,
+	// they were edited and the next sync will update the catalog
+	Modified []string
+	// Missing lists the files of the catalog that don't exist anymore
+	Missing []string
+}
+
+// verifyStatus is the outcome of verifying a single file
+type verifyStatus int
+
+const (
+	verifyOk verifyStatus = iota
+	verifyCorrupted
+	verifyModified
+	verifyMissing
+	verifyFailed
+)
+
+// verifiedItem is a catalog item with the outcome of its verification
+type verifiedItem struct {
+	item   catalog.Item
+	status verifyStatus
+}
+
+// verificationTime returns the time the item was last verified, or the zero time if it never was
+func verificationTime(item catalog.Item) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, item.VerificationTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// itemsToVerify returns the given fraction (between 0 and 1) of the items of the catalog, the items that were never
+// verified first, then the ones verified the longest time ago. At least one item is returned if the catalog is not empty.
+func itemsToVerify(c catalog.Catalog, fraction float64) []catalog.Item {
+	items := make([]catalog.Item, 0, c.Count())
+	for item := range c.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return verificationTime(items[i]).Before(verificationTime(items[j]))
+	})
+	count := int(math.Ceil(fraction * float64(len(items))))
+	if count > len(items) {
+		count = len(items)
+	}
+	return items[:count]
+}
+
+// verifyFile reads the file of the item again and compares it to the item.
+// The checksum is always calculated from the content of the file, the hash cache is never used.
+func verifyFile(fs afero.Fs, item catalog.Item, alg catalog.HashAlgorithm) (verifyStatus, error) {
+	fi, err := fs.Stat(item.Path)
+	if os.IsNotExist(err) {
+		return verifyMissing, nil
+	} else if err != nil {
+		return verifyFailed, errors.Wrap(err, "Cannot get file info")
+	}
+	if fi.Size() != item.Size || fi.ModTime().Format(time.RFC3339Nano) != item.ModificationTime {
+		return verifyModified, nil
+	}
+	current, err := catalog.NewItemWithAlgorithm(fs, item.Path, alg)
+	if err != nil {
+		return verifyFailed, err
+	}
+	if !current.SameContent(item) {
+		// the file could have been edited while it was read
+		if current.Size != item.Size || current.ModificationTime != item.ModificationTime {
+			return verifyModified, nil
+		}
+		return verifyCorrupted, nil
+	}
+	return verifyOk, nil
+}
+
+// verifyItems verifies the incoming items concurrently and sends them to the returned channel with their outcome.
+// The files that cannot be read are added to errs. The remaining items are dropped if the context is cancelled.
+// The returned channel is closed when all items are processed.
+func verifyItems(ctx context.Context, fs afero.Fs, items <-chan catalog.Item, alg catalog.HashAlgorithm,
+	pb DoubleProgressBar, errs *fileErrors) <-chan verifiedItem {
+	out := make(chan verifiedItem, 10)
+	var wg sync.WaitGroup
+	const concurrency = 6
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				if ctx.Err() != nil {
+					continue
+				}
+				status, err := verifyFile(fs, item, alg)
+				if err != nil {
+					errs.add(item.Path, err)
+				}
+				pb.IncrBy(int(item.Size))
+				out <- verifiedItem{item: item, status: status}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// VerifyFolder reads the files of the folder again and compares their content to the catalog of the folder, to find
+// the files damaged by bit rot. Only the given fraction (between 0 and 1) of the files is checked, the ones that were
+// not verified for the longest time, so running it regularly with a small fraction eventually checks every file.
+// The verification time of the files that match the catalog is updated. The corrupted files are marked as corrupted,
+// but they keep their original checksum and verification time, so they are checked again first until they are fixed.
+// Nothing else in the catalog is changed, the modified and missing files are updated by the next sync.
+// The catalog is saved every few seconds, so an interrupted verification can be continued by running it again.
+// If the context is cancelled, the result of the files verified so far is returned with the error of the context.
+// The files that cannot be read are returned in an UnreadableFilesError along with the result.
+// The progress is shown by the ProgressSink set with WithProgress.
+func VerifyFolder(ctx context.Context, fs afero.Fs, c catalog.Catalog, fraction float64, opts ...Option) (catalog.Catalog, VerifyResult, error) {
+	var result VerifyResult
+	if fraction <= 0 || fraction > 1 {
+		return c, result, errors.Errorf("Invalid fraction of files to verify: %v", fraction)
+	}
+	toVerify := itemsToVerify(c, fraction)
+	items := make(chan catalog.Item, len(toVerify))
+	var size int64
+	for _, item := range toVerify {
+		items <- item
+		size += item.Size
+	}
+	close(items)
+
+	pb := newOptions(opts).progress.NewProgressBar("verify")
+	pb.SetTotal(int64(len(toVerify)), size)
+	var errs fileErrors
+	ret := c.Clone()
+	lastSave := time.Now()
+	changed := false
+	for v := range verifyItems(ctx, fs, items, c.HashAlgorithm(), pb, &errs) {
+		switch v.status {
+		case verifyOk:
+			result.Ok = append(result.Ok, v.item.Path)
+			v.item.VerificationTime = time.Now().Format(time.RFC3339Nano)
+			v.item.Corrupted = false
+			ret.Set(v.item)
+			changed = true
+		case verifyCorrupted:
+			result.Corrupted = append(result.Corrupted, v.item.Path)
+			v.item.Corrupted = true
+			ret.Set(v.item)
+			changed = true
+		case verifyModified:
+			result.Modified = append(result.Modified, v.item.Path)
+		case verifyMissing:
+			result.Missing = append(result.Missing, v.item.Path)
+		}
+		if time.Since(lastSave).Seconds() > 5.0 {
+			lastSave = time.Now()
+			if err := ret.Write(fs); err != nil {
+				log.Printf("Failed to update catalog: %v", err)
+			}
+		}
+	}
+	pb.Wait()
+	if changed {
+		if err := ret.Write(fs); err != nil {
+			return ret, result, err
+		}
+	}
+	sort.Strings(result.Ok)
+	sort.Strings(result.Corrupted)
+	sort.Strings(result.Modified)
+	sort.Strings(result.Missing)
+	if ctx.Err() != nil {
+		return ret, result, ctx.Err()
+	}
+	return ret, result, errs.err("")
+}
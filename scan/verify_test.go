@@ -0,0 +1,155 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+// rotFile changes the content of a file without changing its size and modification time, like bit rot does
+func rotFile(t *testing.T, fs afero.Fs, c catalog.Catalog, path string) {
+	t.Helper()
+	item, err := c.Item(path)
+	th.Ok(t, err)
+	content, err := afero.ReadFile(fs, path)
+	th.Ok(t, err)
+	content[0] ^= 0x01
+	th.Ok(t, afero.WriteFile(fs, path, content, 0644))
+	th.Ok(t, fsh.SetFileAttributes(fs, path, item.ModificationTime))
+}
+
+func TestVerifyFolder(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+
+	rotFile(t, fs, c, "test1.txt")
+	th.Ok(t, changeFileContent(fs, "test2.txt"))
+	th.Ok(t, fs.Remove("subfolder/file2.bin"))
+
+	verified, result, err := VerifyFolder(context.Background(), fs, c, 1)
+	th.Ok(t, err)
+	th.Equals(t, []string{"subfolder/file1.bin"}, result.Ok)
+	th.Equals(t, []string{"test1.txt"}, result.Corrupted)
+	th.Equals(t, []string{"test2.txt"}, result.Modified)
+	th.Equals(t, []string{"subfolder/file2.bin"}, result.Missing)
+
+	// only the verified and the corrupted files are changed in the catalog
+	item, err := verified.Item("subfolder/file1.bin")
+	th.Ok(t, err)
+	th.Assert(t, item.VerificationTime != "", "verification time is not set")
+	corrupted, err := verified.Item("test1.txt")
+	th.Ok(t, err)
+	th.Equals(t, true, corrupted.Corrupted)
+	corrupted.Corrupted = false
+	original, _ := c.Item("test1.txt")
+	th.Equals(t, original, corrupted)
+	for _, path := range []string{"test2.txt", "subfolder/file2.bin"} {
+		item, err := verified.Item(path)
+		th.Ok(t, err)
+		original, _ := c.Item(path)
+		th.Equals(t, original, item)
+	}
+
+	saved, err := catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, verified, saved)
+
+	// the catalog is still in sync with the folder
+	item.VerificationTime = ""
+	original, _ = c.Item("subfolder/file1.bin")
+	th.Equals(t, original, item)
+}
+
+func TestVerifyFolderOldestFirst(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+
+	var verified []string
+	for i := 0; i < 4; i++ {
+		var result VerifyResult
+		c, result, err = VerifyFolder(context.Background(), fs, c, 0.25)
+		th.Ok(t, err)
+		th.Equals(t, 1, len(result.Ok))
+		verified = append(verified, result.Ok...)
+	}
+	th.Equals(t, []string{"subfolder/file1.bin", "subfolder/file2.bin", "test1.txt", "test2.txt"}, verified)
+
+	_, result, err := VerifyFolder(context.Background(), fs, c, 0.5)
+	th.Ok(t, err)
+	th.Equals(t, []string{"subfolder/file1.bin", "subfolder/file2.bin"}, result.Ok)
+}
+
+func TestVerifyFolderCorruptedFilesAreVerifiedFirst(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+
+	rotFile(t, fs, c, "subfolder/file2.bin")
+	c, result, err := VerifyFolder(context.Background(), fs, c, 1)
+	th.Ok(t, err)
+	th.Equals(t, []string{"subfolder/file1.bin", "test1.txt", "test2.txt"}, result.Ok)
+	th.Equals(t, []string{"subfolder/file2.bin"}, result.Corrupted)
+
+	// the corrupted file is not marked as verified, so it's checked again first
+	c, result, err = VerifyFolder(context.Background(), fs, c, 0.25)
+	th.Ok(t, err)
+	th.Equals(t, []string(nil), result.Ok)
+	th.Equals(t, []string{"subfolder/file2.bin"}, result.Corrupted)
+
+	// until it's restored
+	rotFile(t, fs, c, "subfolder/file2.bin")
+	c, result, err = VerifyFolder(context.Background(), fs, c, 0.25)
+	th.Ok(t, err)
+	th.Equals(t, []string{"subfolder/file2.bin"}, result.Ok)
+	item, err := c.Item("subfolder/file2.bin")
+	th.Ok(t, err)
+	th.Equals(t, false, item.Corrupted)
+}
+
+func TestVerifyFolderInvalidFraction(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := newInitializedCatalog()
+	_, _, err := VerifyFolder(context.Background(), fs, c, 0)
+	th.NokPrefix(t, err, "Invalid fraction of files to verify")
+	_, _, err = VerifyFolder(context.Background(), fs, c, 1.5)
+	th.NokPrefix(t, err, "Invalid fraction of files to verify")
+}
+
+func TestVerifyFolderInterrupted(t *testing.T) {
+	fs := afero.NewBasePathFs(createMemFsTestData(), "test_data")
+	c, err := Scan(context.Background(), fs)
+	th.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, result, err := VerifyFolder(ctx, fs, c, 1)
+	th.Equals(t, context.Canceled, err)
+	th.Equals(t, []string(nil), result.Ok)
+}
+
+func TestItemsToVerify(t *testing.T) {
+	c := catalog.NewCatalog()
+	now := time.Now()
+	th.Ok(t, c.Add(catalog.Item{Path: "a", Checksum: "1", VerificationTime: now.Format(time.RFC3339Nano)}))
+	th.Ok(t, c.Add(catalog.Item{Path: "b", Checksum: "2", VerificationTime: now.Add(-time.Hour).Format(time.RFC3339Nano)}))
+	th.Ok(t, c.Add(catalog.Item{Path: "c", Checksum: "3"}))
+	th.Ok(t, c.Add(catalog.Item{Path: "d", Checksum: "4", VerificationTime: now.Add(-time.Minute).UTC().Format(time.RFC3339Nano)}))
+
+	paths := func(items []catalog.Item) []string {
+		ret := make([]string, 0)
+		for _, item := range items {
+			ret = append(ret, item.Path)
+		}
+		return ret
+	}
+	th.Equals(t, []string{"c", "b", "d", "a"}, paths(itemsToVerify(c, 1)))
+	th.Equals(t, []string{"c", "b"}, paths(itemsToVerify(c, 0.5)))
+	th.Equals(t, []string{"c"}, paths(itemsToVerify(c, 0.01)))
+	th.Equals(t, []string{}, paths(itemsToVerify(catalog.NewCatalog(), 0.5)))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestScanFolderCatalog(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	c, err := scanFolderCatalog(context.Background(), fs, false)
+	th.Ok(t, err)
+	th.Equals(t, 2, c.Count())
+	saved, err := catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, c, saved)
+
+	// deleted files are only remembered in a collection
+	th.Ok(t, fs.Remove("a.txt"))
+	c, err = scanFolderCatalog(context.Background(), fs, true)
+	th.Ok(t, err)
+	th.Equals(t, 1, c.Count())
+	th.Equals(t, 1, c.DeletedCount())
+
+	th.Ok(t, fs.Remove("b.txt"))
+	c, err = scanFolderCatalog(context.Background(), fs, false)
+	th.Ok(t, err)
+	th.Equals(t, 0, c.Count())
+}
+
+func TestScanCommandArguments(t *testing.T) {
+	th.NokPrefix(t, scanCommand(context.Background(), []string{}), "The folder must be specified")
+	th.NokPrefix(t, scanCommand(context.Background(), []string{"no_such_folder_to_scan"}), "The folder 'no_such_folder_to_scan' doesn't exist")
+}
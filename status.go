@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// folderStatus summarizes the catalog of a folder and its changes since the last run
+type folderStatus struct {
+	state     catalog.State
+	algorithm catalog.HashAlgorithm
+	files     int
+	deleted   int
+	// diff contains the changes of the folder since its catalog was last updated
+	diff scan.FileSystemDiff
+	// notInCollection is the number of files that are not in the collection and were not deleted from it,
+	// -1 if the folder was not compared to a collection
+	notInCollection int
+}
+
+// completelyImported returns true if all files of the folder were imported, and the folder hasn't changed since.
+// If the folder was compared to the collection, it's enough that all of its files are in the collection.
+func (s folderStatus) completelyImported() bool {
+	if len(s.diff.Add) > 0 || len(s.diff.Update) > 0 {
+		return false
+	}
+	if s.notInCollection >= 0 {
+		return s.notInCollection == 0
+	}
+	return s.state == catalog.Done
+}
+
+// print prints the status in a human readable form
+func (s folderStatus) print() {
+	fmt.Printf("State: %v\n", s.state)
+	fmt.Printf("Files: %v, deleted checksums: %v, hash algorithm: %v\n", s.files, s.deleted, s.algorithm)
+	if len(s.diff.Add)+len(s.diff.Update)+len(s.diff.Delete)+len(s.diff.Moved) == 0 {
+		fmt.Println("The folder hasn't changed since the last run")
+	} else {
+		fmt.Printf("Changes since the last run: %v added, %v modified, %v removed, %v moved\n",
+			len(s.diff.Add), len(s.diff.Update), len(s.diff.Delete), len(s.diff.Moved))
+	}
+	if s.notInCollection >= 0 {
+		fmt.Printf("%v file(s) are not in the collection\n", s.notInCollection)
+	}
+	if s.completelyImported() {
+		fmt.Println("The folder is completely imported")
+	} else {
+		fmt.Println("The folder is not completely imported")
+	}
+}
+
+// status reads the catalog of the folder and compares it to the folder, without changing anything.
+// Only the size and the modification time of the files are checked.
+// If collectionFs is not nil, the files of the catalog are looked up in the catalog of the collection too.
+func status(ctx context.Context, fs afero.Fs, collectionFs afero.Fs) (folderStatus, error) {
+	c, err := readCatalog(fs)
+	if err != nil {
+		return folderStatus{}, errors.Wrapf(err, "Cannot read the catalog of the folder")
+	}
+	ret := folderStatus{
+		state:           c.State(),
+		algorithm:       c.HashAlgorithm(),
+		files:           c.Count(),
+		deleted:         c.DeletedCount(),
+		notInCollection: -1,
+	}
+	if collectionFs != nil {
+		collectionCatalog, err := readCatalog(collectionFs)
+		if err != nil {
+			return folderStatus{}, errors.Wrapf(err, "Cannot read the catalog of the collection")
+		}
+		if collectionCatalog.HashAlgorithm() != c.HashAlgorithm() {
+			return folderStatus{}, errors.Errorf("The collection uses %v instead of %v, the folder must be imported again",
+				collectionCatalog.HashAlgorithm(), c.HashAlgorithm())
+		}
+		ret.notInCollection = c.FilterNew(collectionCatalog).Count()
+	}
+	ret.diff, err = scan.DiffFiltered(ctx, fs, c, scan.IgnoreFilter(fs), false)
+	return ret, err
+}
+
+// statusCommand runs the status subcommand with the given command line arguments (without the subcommand itself)
+func statusCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	collectionPath := flags.String("collection", "", "also check which files of the folder are in this collection")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v status [options] folder-path\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The folder must be specified")
+	}
+	baseFs := afero.NewOsFs()
+	fs, err := openFolder(baseFs, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var collectionFs afero.Fs
+	if *collectionPath != "" {
+		if collectionFs, err = openFolder(baseFs, *collectionPath); err != nil {
+			return err
+		}
+	}
+	s, err := status(ctx, fs, collectionFs)
+	if err != nil {
+		return err
+	}
+	s.print()
+	return nil
+}
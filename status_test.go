@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestStatus(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	_, err = status(context.Background(), import1Fs, nil)
+	th.NokPrefix(t, err, "Cannot read the catalog of the folder")
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	s, err := status(context.Background(), import1Fs, nil)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Copied, s.state)
+	th.Equals(t, 7, s.files)
+	th.Equals(t, -1, s.notInCollection)
+	th.Equals(t, false, s.completelyImported())
+
+	s, err = status(context.Background(), import1Fs, collectionFs)
+	th.Ok(t, err)
+	th.Equals(t, 7, s.notInCollection)
+	th.Equals(t, false, s.completelyImported())
+
+	// the user moves the staged files to the collection, the import folder is completely imported
+	// even before it's imported again
+	th.Ok(t, moveFolder(stagingFs, "1_folder1", collectionFs, "."))
+	th.Ok(t, run(context.Background(), afero.NewMemMapFs(), "empty", stagingFs, collectionFs))
+	s, err = status(context.Background(), import1Fs, collectionFs)
+	th.Ok(t, err)
+	th.Equals(t, 0, s.notInCollection)
+	th.Equals(t, true, s.completelyImported())
+
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	s, err = status(context.Background(), import1Fs, nil)
+	th.Ok(t, err)
+	th.Equals(t, catalog.Done, s.state)
+	th.Equals(t, true, s.completelyImported())
+
+	// a new file on the drive is not imported yet
+	th.Ok(t, afero.WriteFile(import1Fs, "new.txt", []byte("new content"), 0644))
+	s, err = status(context.Background(), import1Fs, nil)
+	th.Ok(t, err)
+	th.Equals(t, 1, len(s.diff.Add))
+	th.Equals(t, false, s.completelyImported())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitro42/coback/catalog"
+	"github.com/mitro42/coback/scan"
+)
+
+// fileCount is the number and the total size of a group of files
+type fileCount struct {
+	files int
+	bytes int64
+}
+
+func (c *fileCount) add(item catalog.Item) {
+	c.files++
+	c.bytes += item.Size
+}
+
+func (c fileCount) String() string {
+	return fmt.Sprintf("%v file(s), %v", c.files, formatSize(c.bytes))
+}
+
+// jsonFileCount is the JSON form of fileCount
+type jsonFileCount struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (c fileCount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFileCount{c.files, c.bytes})
+}
+
+func (c *fileCount) UnmarshalJSON(data []byte) error {
+	var j jsonFileCount
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*c = fileCount{j.Files, j.Bytes}
+	return nil
+}
+
+// The reasons why a file of the import folder is not staged
+const (
+	skipInCollection = "in_collection"
+	skipInStaging    = "in_staging"
+	skipDeleted      = "deleted"
+)
+
+// skipReason returns why the file of the import folder is not staged, or an empty string if it has to be staged
+func skipReason(plan importPlan, item catalog.Item) string {
+	switch {
+	case plan.collectionCatalog.IsDeletedChecksum(item.Checksum):
+		return skipDeleted
+	case !isNew(plan.notInCollection, item):
+		return skipInCollection
+	case !isNew(plan.notInStaging, item):
+		return skipInStaging
+	default:
+		return ""
+	}
+}
+
+// reportSkippedFiles reports the files of the import folder that are not staged with the reason
+func reportSkippedFiles(plan importPlan, r scan.Reporter) {
+	for item := range plan.importCatalog.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		if reason := skipReason(plan, item); reason != "" {
+			r.Report(scan.Event{Type: scan.FileSkipped, Phase: "import", Path: item.Path, Reason: reason})
+		}
+	}
+}
+
+// stagingSummary groups the files of an import folder by what the import does with them
+type stagingSummary struct {
+	// toStage are the new files, they are copied to the staging folder
+	toStage fileCount
+	// inCollection are skipped because the collection already has them
+	inCollection fileCount
+	// inStaging are skipped because they are already in the staging folder, waiting for a decision
+	inStaging fileCount
+	// rejected are skipped because they were deleted from the collection or the staging folder before
+	rejected fileCount
+}
+
+// importSummary is the staging summary of an import folder and of each of its folders
+type importSummary struct {
+	total stagingSummary
+	// folders contains the summary of the files directly in each folder, the root folder is "."
+	folders map[string]*stagingSummary
+}
+
+// summarizeImport sorts the files of the import folder into the categories of stagingSummary by their skipReason
+func summarizeImport(plan importPlan) importSummary {
+	summary := importSummary{folders: make(map[string]*stagingSummary)}
+	for item := range plan.importCatalog.AllItems() {
+		if item.Path == "" {
+			break
+		}
+		folder := filepath.Dir(item.Path)
+		if summary.folders[folder] == nil {
+			summary.folders[folder] = &stagingSummary{}
+		}
+		for _, s := range []*stagingSummary{&summary.total, summary.folders[folder]} {
+			switch skipReason(plan, item) {
+			case skipDeleted:
+				s.rejected.add(item)
+			case skipInCollection:
+				s.inCollection.add(item)
+			case skipInStaging:
+				s.inStaging.add(item)
+			default:
+				s.toStage.add(item)
+			}
+		}
+	}
+	return summary
+}
+
+// isNew returns true if the item is in the catalog of the new files
+func isNew(newFiles catalog.Catalog, item catalog.Item) bool {
+	_, err := newFiles.Item(item.Path)
+	return err == nil
+}
+
+// String returns the summary of a dry run in a human readable form, the folders in alphabetical order
+func (s importSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "Dry run, nothing was copied")
+	fmt.Fprintf(&b, "To be copied to the staging folder: %v\n", s.total.toStage)
+	fmt.Fprintf(&b, "Skipped, already in the collection: %v\n", s.total.inCollection)
+	fmt.Fprintf(&b, "Skipped, already in the staging folder: %v\n", s.total.inStaging)
+	fmt.Fprintf(&b, "Skipped, deleted before: %v", s.total.rejected)
+	if len(s.folders) == 0 {
+		return b.String()
+	}
+
+	folders := make([]string, 0, len(s.folders))
+	for folder := range s.folders {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	fmt.Fprint(&b, "\n\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "To copy\tIn collection\tIn staging\tDeleted\t\tFolder")
+	for _, folder := range folders {
+		f := s.folders[folder]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t\t%v\n", shortCount(f.toStage), shortCount(f.inCollection),
+			shortCount(f.inStaging), shortCount(f.rejected), folder)
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// shortCount formats a file count for the columns of the summary
+func shortCount(c fileCount) string {
+	if c.files == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%v (%v)", c.files, formatSize(c.bytes))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestRunDryRun(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	// nothing is copied in a dry run
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFileCount(t, stagingFs, 0)
+	expectFileMissing(t, import1Fs, catalog.CatalogFileName)
+
+	// the user keeps the family photos, deletes funny.png and doesn't decide about the rest yet
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+	th.Ok(t, afero.WriteFile(import1Fs, "new.txt", []byte("new content"), 0644))
+
+	th.Ok(t, previewRun(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	expectFileCount(t, stagingFs, 3)
+	expectFileMissing(t, stagingFs, "2_folder1/new.txt")
+}
+
+func TestSummarizeImport(t *testing.T) {
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+	th.Ok(t, run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs))
+	th.Ok(t, moveFolder(stagingFs, "1_folder1/family", collectionFs, "family"))
+	th.Ok(t, stagingFs.Remove("1_folder1/funny.png"))
+	th.Ok(t, afero.WriteFile(import1Fs, "new.txt", []byte("new content"), 0644))
+
+	plan, err := planImport(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, newRunOptions([]runOption{withDryRun()}))
+	th.Ok(t, err)
+	summary := summarizeImport(plan)
+	th.Equals(t, fileCount{files: 1, bytes: 11}, summary.total.toStage)
+	th.Equals(t, 3, summary.total.inCollection.files)
+	th.Equals(t, 3, summary.total.inStaging.files)
+	th.Equals(t, 1, summary.total.rejected.files)
+
+	th.Equals(t, 3, len(summary.folders))
+	th.Equals(t, 1, summary.folders["."].toStage.files)
+	th.Equals(t, 1, summary.folders["."].rejected.files)
+	th.Equals(t, 0, summary.folders["."].inCollection.files)
+	th.Equals(t, 3, summary.folders["family"].inCollection.files)
+	th.Equals(t, 3, summary.folders["friends"].inStaging.files)
+	th.Equals(t, summary.total.inCollection, summary.folders["family"].inCollection)
+	text := summary.String()
+	th.Assert(t, strings.HasPrefix(text, "Dry run, nothing was copied\n"), "unexpected summary: %v", text)
+	th.Assert(t, strings.HasSuffix(text, "friends"), "unexpected summary: %v", text)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// dateFormat is the format of the dates accepted by the command line filters
+const dateFormat = "2006-01-02"
+
+// tombstoneFilter selects tombstones from the collection catalog. Empty fields match every tombstone.
+type tombstoneFilter struct {
+	// checksum must be equal to the deleted checksum
+	checksum catalog.Checksum
+	// name is a shell pattern (as used by filepath.Match) matched against the base name of the original paths
+	name string
+	// after and before limit the time of the deletion, tombstones without a deletion time never match them
+	after  time.Time
+	before time.Time
+}
+
+// isEmpty returns true if the filter matches all tombstones
+func (f tombstoneFilter) isEmpty() bool {
+	return f.checksum == "" && f.name == "" && f.after.IsZero() && f.before.IsZero()
+}
+
+// matches returns true if the deleted checksum and its tombstone are selected by the filter
+func (f tombstoneFilter) matches(sum catalog.Checksum, tombstone catalog.Tombstone) bool {
+	if f.checksum != "" && f.checksum != sum {
+		return false
+	}
+	if f.name != "" && !tombstoneNameMatches(tombstone, f.name) {
+		return false
+	}
+	if !f.after.IsZero() || !f.before.IsZero() {
+		deletedAt, err := time.Parse(time.RFC3339Nano, tombstone.DeletedAt)
+		if err != nil {
+			return false
+		}
+		if !f.after.IsZero() && deletedAt.Before(f.after) {
+			return false
+		}
+		if !f.before.IsZero() && !deletedAt.Before(f.before) {
+			return false
+		}
+	}
+	return true
+}
+
+func tombstoneNameMatches(tombstone catalog.Tombstone, pattern string) bool {
+	for _, path := range tombstone.Paths {
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// findTombstones returns the deleted checksums of the catalog that are selected by the filter, in alphabetical order
+func findTombstones(c catalog.Catalog, filter tombstoneFilter) []catalog.Checksum {
+	ret := make([]catalog.Checksum, 0)
+	for sum := range c.DeletedChecksums() {
+		tombstone, err := c.Tombstone(sum)
+		if err == nil && filter.matches(sum, tombstone) {
+			ret = append(ret, sum)
+		}
+	}
+	return ret
+}
+
+// printTombstone prints the details of a deleted checksum in a human readable form
+func printTombstone(sum catalog.Checksum, tombstone catalog.Tombstone) {
+	deletedAt := tombstone.DeletedAt
+	if deletedAt == "" {
+		deletedAt = "unknown time"
+	}
+	fmt.Printf("%v  deleted at %v, %v bytes", sum, deletedAt, tombstone.Size)
+	if tombstone.ImportFolder != "" {
+		fmt.Printf(", imported to %v", tombstone.ImportFolder)
+	}
+	fmt.Println()
+	for _, path := range tombstone.Paths {
+		fmt.Printf("    %v\n", path)
+	}
+}
+
+// undelete removes the tombstones selected by the filter from the collection catalog, so the files are imported again.
+// If listOnly is true, the selected tombstones are only printed, the catalog is not changed.
+// Returns the selected checksums.
+func undelete(collectionFs afero.Fs, filter tombstoneFilter, listOnly bool) ([]catalog.Checksum, error) {
+	if !listOnly && filter.isEmpty() {
+		return nil, errors.New("At least one filter must be specified to undelete files")
+	}
+	collectionCatalog, err := readCatalog(collectionFs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read the catalog of the collection")
+	}
+	sums := findTombstones(collectionCatalog, filter)
+	for _, sum := range sums {
+		tombstone, _ := collectionCatalog.Tombstone(sum)
+		printTombstone(sum, tombstone)
+	}
+	if listOnly || len(sums) == 0 {
+		return sums, nil
+	}
+	for _, sum := range sums {
+		collectionCatalog.UnDeleteChecksum(sum)
+	}
+	if err := collectionCatalog.Write(collectionFs); err != nil {
+		return nil, err
+	}
+	fmt.Printf("%v file(s) undeleted\n", len(sums))
+	return sums, nil
+}
+
+// restage copies the files with the given checksums from an import folder to a new folder in the staging folder,
+// unless they are already in the staging folder or in the collection.
+// The import folder must be given, CoBack doesn't keep track of where the import folders are.
+// The folders are synced with the scan options of the run options, and the files are staged with their staging mode,
+// reporter and progress sink, like in a run.
+// Returns the number of files copied. Files that cannot be read are skipped and returned in an UnreadableFilesError.
+func restage(ctx context.Context, importFs afero.Fs, importName string, stagingFs afero.Fs, collectionFs afero.Fs,
+	sums []catalog.Checksum, opts ...runOption) (int, error) {
+	o := newRunOptions(opts)
+	collectionCatalog, err := readCatalog(collectionFs)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Cannot read the catalog of the collection")
+	}
+	var unreadable []scan.FileError
+	importOpts := append(append([]scan.Option{}, o.scanOptions...), scan.WithHashAlgorithm(collectionCatalog.HashAlgorithm()))
+	importCatalog, err := scan.SyncCatalogWithImportFolder(ctx, importFs, importOpts...)
+	if err = collectUnreadable(&unreadable, err); err != nil {
+		return 0, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+	stagingCatalog, err := scan.SyncCatalogWithStagingFolder(ctx, stagingFs, collectionCatalog, o.scanOptions...)
+	if err = collectUnreadable(&unreadable, err); err != nil {
+		return 0, errors.Wrapf(err, "Cannot sync folder contents")
+	}
+
+	undeleted := catalog.NewCatalogWithAlgorithm(importCatalog.HashAlgorithm())
+	for _, sum := range sums {
+		items, err := importCatalog.ItemsByChecksum(sum)
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			undeleted.Add(item)
+		}
+	}
+	toStage := undeleted.FilterNew(collectionCatalog).FilterNew(stagingCatalog)
+	if toStage.Count() == 0 {
+		return 0, unreadableError(unreadable)
+	}
+
+	targetFolder := fsh.NextUnusedFolder(stagingFs) + "_" + importName
+	if _, err = stageFiles(ctx, importFs, targetFolder, toStage, stagingFs, o); err != nil {
+		return 0, errors.Wrapf(err, "Failed to copy files")
+	}
+	stagingCatalog, err = scan.SyncCatalogWithStagingFolder(ctx, stagingFs, collectionCatalog, o.scanOptions...)
+	if err = collectUnreadable(&unreadable, err); err != nil {
+		return 0, errors.Wrapf(err, "Cannot sync folder contents after staging")
+	}
+	stagingCatalog.Write(stagingFs)
+	if importCatalog.State() == catalog.Done {
+		importCatalog.SetState(catalog.Copied)
+	}
+	importCatalog.Write(importFs)
+	return toStage.Count(), unreadableError(unreadable)
+}
+
+// parseDate parses a date given on the command line. Both plain dates and RFC3339 timestamps are accepted.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(dateFormat, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("Invalid date: '%v'", value)
+	}
+	return t, nil
+}
+
+// undeleteCommand runs the undelete subcommand with the given command line arguments (without the subcommand itself)
+func undeleteCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("undelete", flag.ContinueOnError)
+	checksum := flags.String("checksum", "", "select the deleted file with this checksum")
+	name := flags.String("name", "", "select deleted files whose original name matches this pattern (e.g. 'IMG_12*.jpg')")
+	after := flags.String("after", "", "select files deleted on or after this date (YYYY-MM-DD)")
+	before := flags.String("before", "", "select files deleted before this date (YYYY-MM-DD)")
+	list := flags.Bool("list", false, "only list the selected deleted files, don't undelete them")
+	stagingPath := flags.String("restage", "", "copy the undeleted files from the import folders given after the collection path to this staging folder right away")
+	syncFlags := addSyncFlags(flags)
+	stagingFlags := addStagingFlags(flags)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v undelete [options] collection-path [import-from-path...]\n", os.Args[0])
+		fmt.Println("The files are only restaged from the import folders listed, CoBack doesn't remember where the import folders are.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return errors.New("Collection path is missing")
+	}
+	if *stagingPath != "" && flags.NArg() < 2 {
+		return errors.New("At least one import folder must be specified to restage files")
+	}
+
+	filter := tombstoneFilter{checksum: catalog.Checksum(*checksum), name: *name}
+	var err error
+	if filter.after, err = parseDate(*after); err != nil {
+		return err
+	}
+	if filter.before, err = parseDate(*before); err != nil {
+		return err
+	}
+
+	baseFs := afero.NewOsFs()
+	collectionFs, err := scan.InitializeFolder(baseFs, flags.Arg(0))
+	if err != nil {
+		return errors.Wrapf(err, "Cannot initialize folder")
+	}
+	sums, err := undelete(collectionFs, filter, *list)
+	if err != nil || *list || *stagingPath == "" || len(sums) == 0 {
+		return err
+	}
+
+	opts, cache, err := syncFlags.options()
+	if err != nil {
+		return err
+	}
+	if cache != nil {
+		defer saveHashCache(cache)
+	}
+	stagingMode, err := stagingFlags.stagingMode()
+	if err != nil {
+		return err
+	}
+	progress, err := syncFlags.progressSink(nil)
+	if err != nil {
+		return err
+	}
+	runOpts := []runOption{withScanOptions(opts...), withProgress(progress), withStagingMode(stagingMode)}
+	stagingFs, err := scan.InitializeFolder(baseFs, *stagingPath)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot initialize folder")
+	}
+	if err = checkUsableStagingFolder(stagingFs); err != nil {
+		return err
+	}
+	for _, importPath := range flags.Args()[1:] {
+		importFs, err := scan.InitializeFolder(baseFs, importPath)
+		if err != nil {
+			return errors.Wrapf(err, "Cannot initialize folder")
+		}
+		_, importName := filepath.Split(filepath.Clean(importPath))
+		count, err := restage(ctx, importFs, importName, stagingFs, collectionFs, sums, runOpts...)
+		if unreadable, ok := errors.Cause(err).(*scan.UnreadableFilesError); ok {
+			for _, e := range unreadable.Files {
+				fmt.Printf("%v\n", e)
+			}
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("%v file(s) restaged from %v\n", count, importPath)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestTombstoneFilter(t *testing.T) {
+	tombstone := catalog.Tombstone{
+		Paths:     []string{"1_photos/family/IMG_1234.jpg", "1_photos/mom.jpg"},
+		Size:      42,
+		DeletedAt: "2019-03-10T12:00:00Z",
+	}
+	date := func(s string) time.Time {
+		d, err := parseDate(s)
+		th.Ok(t, err)
+		return d
+	}
+
+	th.Equals(t, true, tombstoneFilter{}.isEmpty())
+	th.Equals(t, true, tombstoneFilter{}.matches("1234", tombstone))
+	th.Equals(t, true, tombstoneFilter{checksum: "1234"}.matches("1234", tombstone))
+	th.Equals(t, false, tombstoneFilter{checksum: "1234"}.matches("5678", tombstone))
+	th.Equals(t, true, tombstoneFilter{name: "mom.jpg"}.matches("1234", tombstone))
+	th.Equals(t, true, tombstoneFilter{name: "IMG_12*"}.matches("1234", tombstone))
+	th.Equals(t, false, tombstoneFilter{name: "family"}.matches("1234", tombstone))
+	th.Equals(t, true, tombstoneFilter{after: date("2019-03-10")}.matches("1234", tombstone))
+	th.Equals(t, false, tombstoneFilter{after: date("2019-03-11T00:00:00Z")}.matches("1234", tombstone))
+	th.Equals(t, true, tombstoneFilter{before: date("2019-03-11T00:00:00Z")}.matches("1234", tombstone))
+	th.Equals(t, false, tombstoneFilter{before: date("2019-03-10T12:00:00Z")}.matches("1234", tombstone))
+	th.Equals(t, false, tombstoneFilter{name: "mom.jpg", checksum: "5678"}.matches("1234", tombstone))
+	// tombstones of old catalogs have no deletion time
+	th.Equals(t, false, tombstoneFilter{after: date("2000-01-01")}.matches("1234", catalog.Tombstone{}))
+	th.Equals(t, true, tombstoneFilter{checksum: "1234"}.matches("1234", catalog.Tombstone{}))
+
+	_, err := parseDate("March")
+	th.NokPrefix(t, err, "Invalid date: 'March'")
+}
+
+func TestUndelete(t *testing.T) {
+	collectionFs := afero.NewMemMapFs()
+	c := catalog.NewCatalog()
+	c.AddTombstone("1234", catalog.Tombstone{Paths: []string{"a/mom.jpg"}, DeletedAt: "2019-03-10T12:00:00Z"})
+	c.AddTombstone("5678", catalog.Tombstone{Paths: []string{"a/dad.jpg"}, DeletedAt: "2019-04-10T12:00:00Z"})
+	c.AddTombstone("9999", catalog.Tombstone{})
+	th.Ok(t, c.Write(collectionFs))
+
+	_, err := undelete(collectionFs, tombstoneFilter{}, false)
+	th.NokPrefix(t, err, "At least one filter must be specified")
+
+	sums, err := undelete(collectionFs, tombstoneFilter{}, true)
+	th.Ok(t, err)
+	th.Equals(t, []catalog.Checksum{"1234", "5678", "9999"}, sums)
+
+	sums, err = undelete(collectionFs, tombstoneFilter{name: "*.jpg"}, true)
+	th.Ok(t, err)
+	th.Equals(t, []catalog.Checksum{"1234", "5678"}, sums)
+	cRead, err := catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 3, cRead.DeletedCount())
+
+	sums, err = undelete(collectionFs, tombstoneFilter{name: "dad.jpg"}, false)
+	th.Ok(t, err)
+	th.Equals(t, []catalog.Checksum{"5678"}, sums)
+	cRead, err = catalog.Read(collectionFs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	th.Equals(t, 2, cRead.DeletedCount())
+	th.Equals(t, false, cRead.IsDeletedChecksum("5678"))
+
+	_, err = undelete(afero.NewMemMapFs(), tombstoneFilter{name: "dad.jpg"}, false)
+	th.NokPrefix(t, err, "Cannot read the catalog of the collection")
+}
+
+func TestUndeleteAndRestage(t *testing.T) {
+	// 1. Import folder1
+	// 2. Move all files from staging to collection (user action)
+	// 3. Delete family/mom.jpg and family/dad.jpg from the collection (user action)
+	// 4. Import folder1 again - nothing is staged
+	// 5. Undelete mom.jpg and restage it from folder1 - only mom.jpg is staged
+	// 6. Undelete dad.jpg without restaging, then import folder1 again - dad.jpg is staged
+
+	fs, err := prepareTestFs(t, "folder1")
+	th.Ok(t, err)
+	import1Fs, stagingFs, collectionFs, err := initializeFolders(fs, "folder1", "staging", "collection")
+	th.Ok(t, err)
+
+	// 1
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+
+	// 2 (user action)
+	err = moveFolder(stagingFs, "1_folder1", collectionFs, ".")
+	th.Ok(t, err)
+
+	// 3 (user action)
+	th.Ok(t, collectionFs.Remove("family/mom.jpg"))
+	th.Ok(t, collectionFs.Remove("family/dad.jpg"))
+
+	// 4
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFileCount(t, stagingFs, 0)
+	expectCatalogState(t, import1Fs, catalog.Done)
+
+	// 5
+	sums, err := undelete(collectionFs, tombstoneFilter{name: "mom.jpg"}, false)
+	th.Ok(t, err)
+	th.Equals(t, 1, len(sums))
+	var r eventRecorder
+	count, err := restage(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, sums,
+		withReporter(&r), withProgress(scan.NoProgress()), withStagingMode(fsh.CopyMode))
+	th.Ok(t, err)
+	th.Equals(t, 1, count)
+	// the file is staged with the run options
+	staged := r.ofType(scan.FileStaged)
+	th.Equals(t, 1, len(staged))
+	th.Equals(t, string(fsh.CopyMode), staged[0].Mode)
+	expectFileCount(t, stagingFs, 1)
+	expectFile(t, stagingFs, "2_folder1/family/mom.jpg")
+	expectCatalogState(t, import1Fs, catalog.Copied)
+	// restaging again doesn't copy the file twice
+	count, err = restage(context.Background(), import1Fs, "folder1", stagingFs, collectionFs, sums)
+	th.Ok(t, err)
+	th.Equals(t, 0, count)
+
+	// 6
+	_, err = undelete(collectionFs, tombstoneFilter{name: "dad.jpg"}, false)
+	th.Ok(t, err)
+	err = run(context.Background(), import1Fs, "folder1", stagingFs, collectionFs)
+	th.Ok(t, err)
+	expectFileCount(t, stagingFs, 2)
+	expectFile(t, stagingFs, "3_folder1/family/dad.jpg")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mitro42/coback/scan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// printFileList prints the title and the files indented below it, nothing if the list is empty
+func printFileList(title string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Println(title)
+	for _, path := range paths {
+		fmt.Printf("    %v\n", path)
+	}
+}
+
+// verify checks the given percentage of the files of the folder against its catalog and prints the result.
+// Returns error if a file is corrupted, or if the files cannot be checked.
+func verify(ctx context.Context, fs afero.Fs, percent float64) (scan.VerifyResult, error) {
+	c, err := readCatalog(fs)
+	if err != nil {
+		return scan.VerifyResult{}, errors.Wrapf(err, "Cannot read the catalog of the folder")
+	}
+	_, result, err := scan.VerifyFolder(ctx, fs, c, percent/100)
+	printFileList("Possible bit rot, the content changed but the size and modification time didn't:", result.Corrupted)
+	printFileList("Modified since the last sync:", result.Modified)
+	printFileList("Missing since the last sync:", result.Missing)
+	checked := len(result.Ok) + len(result.Corrupted) + len(result.Modified) + len(result.Missing)
+	fmt.Printf("%v file(s) checked: %v ok, %v corrupted, %v modified, %v missing\n",
+		checked, len(result.Ok), len(result.Corrupted), len(result.Modified), len(result.Missing))
+	if err != nil {
+		return result, err
+	}
+	if len(result.Corrupted) > 0 {
+		return result, errors.Errorf("%v file(s) may be corrupted, restore them with the repair command or from a backup", len(result.Corrupted))
+	}
+	return result, nil
+}
+
+// verifyCommand runs the verify subcommand with the given command line arguments (without the subcommand itself)
+func verifyCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	percent := flags.Float64("percent", 100, "only check this percentage of the files, the ones checked the longest time ago")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %v verify [options] folder-path\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The folder to verify must be specified")
+	}
+	if *percent <= 0 || *percent > 100 {
+		return errors.Errorf("Invalid percentage: %v", *percent)
+	}
+	fs, err := openFolder(afero.NewOsFs(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = verify(ctx, fs, *percent)
+	return err
+}
+
+// printVerifyError prints the error that stopped the verification with a hint how to continue
+func printVerifyError(err error) {
+	if unreadable, ok := errors.Cause(err).(*scan.UnreadableFilesError); ok {
+		fmt.Println("The following files could not be read:")
+		for _, e := range unreadable.Files {
+			fmt.Printf("    %v\n", e)
+		}
+		return
+	}
+	if errors.Cause(err) == context.Canceled {
+		fmt.Println("The verification was interrupted, run it again to continue where it stopped")
+		return
+	}
+	fmt.Println(err)
+}
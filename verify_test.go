@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitro42/coback/catalog"
+	fsh "github.com/mitro42/coback/fshelper"
+	"github.com/mitro42/coback/scan"
+	th "github.com/mitro42/testhelper"
+	"github.com/spf13/afero"
+)
+
+func TestVerify(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	th.Ok(t, afero.WriteFile(fs, "a.txt", []byte("content a"), 0644))
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content b"), 0644))
+	_, err := verify(context.Background(), fs, 100)
+	th.NokPrefix(t, err, "Cannot read the catalog of the folder")
+
+	c, err := scan.Scan(context.Background(), fs)
+	th.Ok(t, err)
+	result, err := verify(context.Background(), fs, 100)
+	th.Ok(t, err)
+	th.Equals(t, []string{"a.txt", "b.txt"}, result.Ok)
+
+	item, err := c.Item("b.txt")
+	th.Ok(t, err)
+	th.Ok(t, afero.WriteFile(fs, "b.txt", []byte("content B"), 0644))
+	th.Ok(t, fsh.SetFileAttributes(fs, "b.txt", item.ModificationTime))
+	result, err = verify(context.Background(), fs, 100)
+	th.NokPrefix(t, err, "1 file(s) may be corrupted")
+	th.Equals(t, []string{"b.txt"}, result.Corrupted)
+
+	// the verification time of the intact file is saved
+	c, err = catalog.Read(fs, catalog.CatalogFileName)
+	th.Ok(t, err)
+	item, err = c.Item("a.txt")
+	th.Ok(t, err)
+	th.Assert(t, item.VerificationTime != "", "verification time is not saved")
+}
+
+func TestVerifyCommandArguments(t *testing.T) {
+	th.NokPrefix(t, verifyCommand(context.Background(), []string{}), "The folder to verify must be specified")
+	th.NokPrefix(t, verifyCommand(context.Background(), []string{"-percent", "0", "folder"}), "Invalid percentage: 0")
+	th.NokPrefix(t, verifyCommand(context.Background(), []string{"-percent", "101", "folder"}), "Invalid percentage: 101")
+	th.NokPrefix(t, verifyCommand(context.Background(), []string{"no_such_folder_to_verify"}), "The folder 'no_such_folder_to_verify' doesn't exist")
+}